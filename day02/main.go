@@ -1,13 +1,23 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+var (
+	targetFlag  = flag.Int("target", 19690720, "output value to find noun/verb pairs for")
+	allFlag     = flag.Bool("all", false, "print all noun/verb pairs producing the target")
+	formulaFlag = flag.Bool("formula", false, "print the formula derived for memory[0]")
+)
+
 func main() {
+	flag.Parse()
+
 	input := readFile("input.txt")
 
 	var program []int
@@ -24,16 +34,289 @@ func main() {
 	fmt.Println(result)
 
 	fmt.Println("--- Part Two ---")
-loop:
+	formula, symbolic := deriveFormula(program)
+	if *formulaFlag {
+		if symbolic {
+			fmt.Printf("memory[0] = %v\n", formula)
+		} else {
+			fmt.Println("memory[0] cannot be derived symbolically, using brute force")
+		}
+	}
+
+	var pairs [][2]int
+	if symbolic {
+		pairs = solveFormula(program, formula, *targetFlag)
+	} else {
+		pairs = bruteForce(program, *targetFlag)
+	}
+
+	if len(pairs) == 0 {
+		fmt.Println("no noun/verb pair produces", *targetFlag)
+		return
+	}
+
+	if !*allFlag {
+		pairs = pairs[:1]
+	}
+
+	for _, pair := range pairs {
+		fmt.Printf("%02d%02d\n", pair[0], pair[1])
+	}
+}
+
+// Monomial is a product noun^Noun * verb^Verb.
+type Monomial struct {
+	Noun, Verb int
+}
+
+// Polynomial maps monomials to their coefficients. A nil Polynomial stands
+// for a memory cell whose value is unknown.
+type Polynomial map[Monomial]int
+
+func constant(c int) Polynomial {
+	p := make(Polynomial)
+	if c != 0 {
+		p[Monomial{}] = c
+	}
+	return p
+}
+
+// Constant returns the value of p if it does not depend on noun or verb.
+func (p Polynomial) Constant() (int, bool) {
+	if p == nil {
+		return 0, false
+	}
+
+	for m := range p {
+		if m != (Monomial{}) {
+			return 0, false
+		}
+	}
+
+	return p[Monomial{}], true
+}
+
+func (p Polynomial) Add(q Polynomial) Polynomial {
+	result := make(Polynomial)
+	for m, c := range p {
+		result[m] += c
+	}
+	for m, c := range q {
+		result[m] += c
+	}
+	return result.trim()
+}
+
+func (p Polynomial) Mul(q Polynomial) Polynomial {
+	result := make(Polynomial)
+	for m1, c1 := range p {
+		for m2, c2 := range q {
+			result[Monomial{m1.Noun + m2.Noun, m1.Verb + m2.Verb}] += c1 * c2
+		}
+	}
+	return result.trim()
+}
+
+func (p Polynomial) Eval(noun, verb int) int {
+	result := 0
+	for m, c := range p {
+		result += c * pow(noun, m.Noun) * pow(verb, m.Verb)
+	}
+	return result
+}
+
+func (p Polynomial) trim() Polynomial {
+	for m, c := range p {
+		if c == 0 {
+			delete(p, m)
+		}
+	}
+	return p
+}
+
+func (p Polynomial) String() string {
+	if len(p) == 0 {
+		return "0"
+	}
+
+	var monomials []Monomial
+	for m := range p {
+		monomials = append(monomials, m)
+	}
+
+	// Highest degree first, noun before verb.
+	sort.Slice(monomials, func(i, j int) bool {
+		di := monomials[i].Noun + monomials[i].Verb
+		dj := monomials[j].Noun + monomials[j].Verb
+		if di != dj {
+			return di > dj
+		}
+		return monomials[i].Noun > monomials[j].Noun
+	})
+
+	var builder strings.Builder
+	for i, m := range monomials {
+		c := p[m]
+		if i == 0 {
+			if c < 0 {
+				builder.WriteString("-")
+			}
+		} else if c < 0 {
+			builder.WriteString(" - ")
+		} else {
+			builder.WriteString(" + ")
+		}
+
+		if c < 0 {
+			c = -c
+		}
+
+		var factors []string
+		if c != 1 || m == (Monomial{}) {
+			factors = append(factors, strconv.Itoa(c))
+		}
+		for _, variable := range []struct {
+			name   string
+			degree int
+		}{{"noun", m.Noun}, {"verb", m.Verb}} {
+			switch variable.degree {
+			case 0:
+			case 1:
+				factors = append(factors, variable.name)
+			default:
+				factors = append(factors, fmt.Sprintf("%s^%d", variable.name, variable.degree))
+			}
+		}
+		builder.WriteString(strings.Join(factors, "*"))
+	}
+
+	return builder.String()
+}
+
+// deriveFormula runs the program with addresses 1 and 2 holding the symbols
+// noun and verb and returns memory[0] as a polynomial in them. It reports
+// false if the program is not symbolic-friendly, i.e. if an opcode, a write
+// address or the final result depends on the noun or verb.
+func deriveFormula(program []int) (Polynomial, bool) {
+	memory := make([]Polynomial, len(program))
+	for i, value := range program {
+		memory[i] = constant(value)
+	}
+	memory[1] = Polynomial{Monomial{Noun: 1}: 1}
+	memory[2] = Polynomial{Monomial{Verb: 1}: 1}
+
+	// Reading through a symbolic address yields an unknown (nil) value.
+	// That is fine, as long as it is overwritten before it is used.
+	read := func(address Polynomial) Polynomial {
+		a, ok := address.Constant()
+		if !ok || a < 0 || a >= len(memory) {
+			return nil
+		}
+		return memory[a]
+	}
+
+	ip := 0
+	for ip < len(memory) {
+		opcode, ok := memory[ip].Constant()
+		if !ok {
+			return nil, false
+		}
+
+		switch opcode {
+		case 1, 2:
+			if ip+3 >= len(memory) {
+				return nil, false
+			}
+
+			c, ok := memory[ip+3].Constant()
+			if !ok || c < 0 || c >= len(memory) {
+				return nil, false
+			}
+
+			x, y := read(memory[ip+1]), read(memory[ip+2])
+			switch {
+			case x == nil || y == nil:
+				memory[c] = nil
+			case opcode == 1:
+				memory[c] = x.Add(y)
+			default:
+				memory[c] = x.Mul(y)
+			}
+			ip += 4
+		case 99:
+			return memory[0], memory[0] != nil
+		default:
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+// solveFormula finds all noun/verb pairs for which formula equals target.
+// For every noun the formula is reduced to a polynomial in verb, which is
+// solved directly when it is linear. Candidates are verified by emulating
+// the program, so pairs that would fault are never reported.
+func solveFormula(program []int, formula Polynomial, target int) (pairs [][2]int) {
+	for noun := 0; noun < 100; noun++ {
+		// Coefficients of the polynomial in verb, indexed by degree.
+		coefficients := make(map[int]int)
+		for m, c := range formula {
+			coefficients[m.Verb] += c * pow(noun, m.Noun)
+		}
+
+		// Only once every term is summed is it known which cancel out.
+		degree := 0
+		for d, c := range coefficients {
+			if c != 0 && d > degree {
+				degree = d
+			}
+		}
+
+		var candidates []int
+		switch degree {
+		case 0:
+			if coefficients[0] == target {
+				for verb := 0; verb < 100; verb++ {
+					candidates = append(candidates, verb)
+				}
+			}
+		case 1:
+			rest := target - coefficients[0]
+			if rest%coefficients[1] == 0 {
+				candidates = append(candidates, rest/coefficients[1])
+			}
+		default:
+			for verb := 0; verb < 100; verb++ {
+				if formula.Eval(noun, verb) == target {
+					candidates = append(candidates, verb)
+				}
+			}
+		}
+
+		for _, verb := range candidates {
+			if verb < 0 || verb >= 100 {
+				continue
+			}
+			if result, fault := emulate(program, noun, verb); !fault && result == target {
+				pairs = append(pairs, [2]int{noun, verb})
+			}
+		}
+	}
+
+	return pairs
+}
+
+func bruteForce(program []int, target int) (pairs [][2]int) {
 	for noun := 0; noun < 100; noun++ {
 		for verb := 0; verb < 100; verb++ {
-			result, _ := emulate(program, noun, verb)
-			if result == 19690720 {
-				fmt.Printf("%02d%02d\n", noun, verb)
-				break loop
+			if result, fault := emulate(program, noun, verb); !fault && result == target {
+				pairs = append(pairs, [2]int{noun, verb})
 			}
 		}
 	}
+
+	return pairs
 }
 
 func emulate(program []int, noun, verb int) (result int, fault bool) {
@@ -41,17 +324,36 @@ func emulate(program []int, noun, verb int) (result int, fault bool) {
 	copy(memory, program)
 	memory[1], memory[2] = noun, verb
 
+	// Addresses outside of memory are a fault, like unknown opcodes.
+	valid := func(addresses ...int) bool {
+		for _, address := range addresses {
+			if address < 0 || address >= len(memory) {
+				return false
+			}
+		}
+		return true
+	}
+
 	ip := 0
 	for {
+		if !valid(ip) {
+			return 0, true
+		}
 		opcode := memory[ip]
 		switch opcode {
-		case 1:
-			a, b, c := memory[ip+1], memory[ip+2], memory[ip+3]
-			memory[c] = memory[a] + memory[b]
-			ip += 4
-		case 2:
+		case 1, 2:
+			if !valid(ip + 3) {
+				return 0, true
+			}
 			a, b, c := memory[ip+1], memory[ip+2], memory[ip+3]
-			memory[c] = memory[a] * memory[b]
+			if !valid(a, b, c) {
+				return 0, true
+			}
+			if opcode == 1 {
+				memory[c] = memory[a] + memory[b]
+			} else {
+				memory[c] = memory[a] * memory[b]
+			}
 			ip += 4
 		case 99:
 			return memory[0], false
@@ -78,3 +380,15 @@ func check(err error) {
 		panic(err)
 	}
 }
+
+func pow(a, b int) int {
+	p := 1
+	for b > 0 {
+		if b&1 != 0 {
+			p *= a
+		}
+		b >>= 1
+		a *= a
+	}
+	return p
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+var systemFlag = flag.Int("system", 1, "system ID to run the diagnostic program with in part one")
+
 func main() {
+	flag.Parse()
+
 	input := readFile("input.txt")
 
 	var program []int
@@ -16,17 +23,16 @@ func main() {
 	}
 
 	fmt.Println("--- Part One ---")
-	output := emulate(program, []int{1})
-	for i := 0; i < len(output)-1; i++ {
-		if output[i] != 0 {
-			panic(fmt.Sprintf("test failure: %v", output))
-		}
+	code, failure := runDiagnostics(program, *systemFlag)
+	if failure != nil {
+		fmt.Print(failure)
+		os.Exit(1)
 	}
 
-	fmt.Println(output[len(output)-1])
+	fmt.Println(code)
 
 	fmt.Println("--- Part Two ---")
-	output = emulate(program, []int{5})
+	output := emulate(program, []int{5})
 	if len(output) != 1 {
 		panic(fmt.Sprintf("unexpected output: %v", output))
 	}
@@ -34,12 +40,184 @@ func main() {
 	fmt.Println(output[0])
 }
 
+// CheckFailure describes a non-zero output of the TEST program, i.e. a
+// failed diagnostic check, together with the code that produced it.
+type CheckFailure struct {
+	Index   int // index of the check in the output
+	Value   int
+	Address int // address of the OUT instruction, or of the faulting one
+	Fault   error
+	Window  []string
+}
+
+func (f *CheckFailure) String() string {
+	header := fmt.Sprintf("diagnostic check %d failed: output %d at ip=%d", f.Index, f.Value, f.Address)
+	if f.Fault != nil {
+		header = fmt.Sprintf("diagnostic program faulted after %d outputs at ip=%d: %v", f.Index, f.Address, f.Fault)
+	}
+	return header + "\n" + strings.Join(f.Window, "\n") + "\n"
+}
+
+// Trace records which instructions were executed, which OUT instruction
+// produced each output and the memory as it was then, and the instruction
+// and memory the program halted or faulted at.
+type Trace struct {
+	Executed  map[int]bool
+	Sources   []int
+	Snapshots [][]int
+	IP        int
+	Memory    []int
+}
+
+// runDiagnostics runs the TEST program with the given system ID. All outputs
+// but the last are checks that must be zero; the last one is the diagnostic
+// code.
+func runDiagnostics(program []int, systemID int) (code int, failure *CheckFailure) {
+	trace := &Trace{Executed: make(map[int]bool)}
+	output, fault := emulateRecovering(program, []int{systemID}, trace)
+	if fault != nil {
+		return 0, &CheckFailure{
+			Index:   len(trace.Sources),
+			Address: trace.IP,
+			Fault:   fault,
+			Window:  disassembleWindow(trace.Memory, trace.Executed, trace.IP, 8, 3),
+		}
+	}
+	if len(output) == 0 {
+		panic("no diagnostic code")
+	}
+
+	for i := 0; i < len(output)-1; i++ {
+		if output[i] != 0 {
+			address := trace.Sources[i]
+			return 0, &CheckFailure{
+				Index:   i,
+				Value:   output[i],
+				Address: address,
+				// The program modifies its own instructions, so disassemble
+				// the memory as it was at the output rather than the original
+				// program.
+				Window: disassembleWindow(trace.Snapshots[i], trace.Executed, address, 8, 3),
+			}
+		}
+	}
+
+	return output[len(output)-1], nil
+}
+
+// emulateRecovering runs the program like emulateWithTrace, but returns a
+// fault of the emulator, such as an invalid opcode, rather than panicking.
+func emulateRecovering(program []int, input []int, trace *Trace) (output []int, fault error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fault = fmt.Errorf("%v", r)
+		}
+	}()
+	return emulateWithTrace(program, input, trace), nil
+}
+
+// disassembleWindow disassembles the executed instructions around address,
+// at most before of them preceding it and after of them following it. Only
+// executed addresses are used, as the program mixes code and data.
+func disassembleWindow(memory []int, executed map[int]bool, address, before, after int) (lines []string) {
+	var addresses []int
+	for a := range executed {
+		addresses = append(addresses, a)
+	}
+	sort.Ints(addresses)
+
+	index := sort.SearchInts(addresses, address)
+	from, to := index-before, index+after+1
+	if from < 0 {
+		from = 0
+	}
+	if to > len(addresses) {
+		to = len(addresses)
+	}
+
+	next := -1
+	for _, a := range addresses[from:to] {
+		if next != -1 && a != next {
+			lines = append(lines, "         ...")
+		}
+
+		text, length := disassemble(memory, a)
+		marker := "  "
+		if a == address {
+			marker = "=>"
+		}
+		lines = append(lines, fmt.Sprintf("%s %5d: %s", marker, a, text))
+		next = a + length
+	}
+
+	return lines
+}
+
+var mnemonics = map[int]struct {
+	name       string
+	parameters int
+}{
+	1:  {"ADD", 3},
+	2:  {"MUL", 3},
+	3:  {"IN", 1},
+	4:  {"OUT", 1},
+	5:  {"JNZ", 2},
+	6:  {"JZ", 2},
+	7:  {"LT", 3},
+	8:  {"EQ", 3},
+	99: {"HALT", 0},
+}
+
+// disassemble returns the text of the instruction at address and its length.
+func disassemble(memory []int, address int) (string, int) {
+	instruction := memory[address]
+	mnemonic, ok := mnemonics[instruction%100]
+	if !ok {
+		return fmt.Sprintf("DATA %d", instruction), 1
+	}
+
+	words := []string{strconv.Itoa(instruction)}
+	var operands []string
+	for i := 1; i <= mnemonic.parameters && address+i < len(memory); i++ {
+		parameter := memory[address+i]
+		words = append(words, strconv.Itoa(parameter))
+		if instruction/pow10(i+1)%10 == 1 {
+			operands = append(operands, strconv.Itoa(parameter))
+		} else {
+			operands = append(operands, fmt.Sprintf("[%d]", parameter))
+		}
+	}
+
+	text := fmt.Sprintf("%-24s %s %s", strings.Join(words, ","), mnemonic.name, strings.Join(operands, ", "))
+	return strings.TrimSpace(text), 1 + mnemonic.parameters
+}
+
+func pow10(n int) int {
+	p := 1
+	for ; n > 0; n-- {
+		p *= 10
+	}
+	return p
+}
+
 func emulate(program []int, input []int) (output []int) {
+	return emulateWithTrace(program, input, nil)
+}
+
+func emulateWithTrace(program []int, input []int, trace *Trace) (output []int) {
 	memory := make([]int, len(program))
 	copy(memory, program)
+	if trace != nil {
+		trace.Memory = memory
+	}
 
 	ip := 0
 	for {
+		if trace != nil {
+			trace.Executed[ip] = true
+			trace.IP = ip
+		}
+
 		instruction := memory[ip]
 		a := instruction / 10000
 		b := (instruction - a*10000) / 1000
@@ -78,6 +256,10 @@ func emulate(program []int, input []int) (output []int) {
 		case 4:
 			x := fetchValue(c, memory, ip+1)
 			output = append(output, x)
+			if trace != nil {
+				trace.Sources = append(trace.Sources, ip)
+				trace.Snapshots = append(trace.Snapshots, append([]int(nil), memory...))
+			}
 			ip += 2
 
 		case 5: // JUMP IF TRUE
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+var systemFlag = flag.Int64("system", 1, "system ID to run the BOOST program with in part one")
+
 func main() {
+	flag.Parse()
+
 	input := readFile("input.txt")
 
 	var program []int64
@@ -16,17 +23,16 @@ func main() {
 	}
 
 	fmt.Println("--- Part One ---")
-	output := emulate(program, []int64{1})
-	for i := 0; i < len(output)-1; i++ {
-		if output[i] != 0 {
-			panic(fmt.Sprintf("test failure: %v", output))
-		}
+	code, failure := runDiagnostics(program, *systemFlag)
+	if failure != nil {
+		fmt.Print(failure)
+		os.Exit(1)
 	}
 
-	fmt.Println(output[len(output)-1])
+	fmt.Println(code)
 
 	fmt.Println("--- Part Two ---")
-	output = emulate(program, []int64{2})
+	output := emulate(program, []int64{2})
 	if len(output) != 1 {
 		panic(fmt.Sprintf("unexpected output: %v", output))
 	}
@@ -34,15 +40,190 @@ func main() {
 	fmt.Println(output[0])
 }
 
+// CheckFailure describes a non-zero output of the BOOST program in test
+// mode, i.e. a malfunctioning opcode, together with the code that produced it.
+type CheckFailure struct {
+	Index   int // index of the check in the output
+	Value   int64
+	Address int64 // address of the OUT instruction, or of the faulting one
+	Fault   error
+	Window  []string
+}
+
+func (f *CheckFailure) String() string {
+	header := fmt.Sprintf("diagnostic check %d failed: output %d at ip=%d", f.Index, f.Value, f.Address)
+	if f.Fault != nil {
+		header = fmt.Sprintf("diagnostic program faulted after %d outputs at ip=%d: %v", f.Index, f.Address, f.Fault)
+	}
+	return header + "\n" + strings.Join(f.Window, "\n") + "\n"
+}
+
+// Trace records which instructions were executed, which OUT instruction
+// produced each output and the memory as it was then, and the instruction
+// and memory the program halted or faulted at.
+type Trace struct {
+	Executed  map[int64]bool
+	Sources   []int64
+	Snapshots [][]int64
+	IP        int64
+	Memory    []int64
+}
+
+// runDiagnostics runs the program with the given system ID. All outputs but
+// the last are checks that must be zero; the last one is the diagnostic code.
+func runDiagnostics(program []int64, systemID int64) (code int64, failure *CheckFailure) {
+	trace := &Trace{Executed: make(map[int64]bool)}
+	output, fault := emulateRecovering(program, []int64{systemID}, trace)
+	if fault != nil {
+		return 0, &CheckFailure{
+			Index:   len(trace.Sources),
+			Address: trace.IP,
+			Fault:   fault,
+			Window:  disassembleWindow(trace.Memory, trace.Executed, trace.IP, 8, 3),
+		}
+	}
+	if len(output) == 0 {
+		panic("no diagnostic code")
+	}
+
+	for i := 0; i < len(output)-1; i++ {
+		if output[i] != 0 {
+			address := trace.Sources[i]
+			return 0, &CheckFailure{
+				Index:   i,
+				Value:   output[i],
+				Address: address,
+				// The program may modify its own instructions, so disassemble
+				// the memory as it was at the output rather than the original
+				// program.
+				Window: disassembleWindow(trace.Snapshots[i], trace.Executed, address, 8, 3),
+			}
+		}
+	}
+
+	return output[len(output)-1], nil
+}
+
+// emulateRecovering runs the program like emulateWithTrace, but returns a
+// fault of the emulator, such as an invalid opcode, rather than panicking.
+func emulateRecovering(program []int64, input []int64, trace *Trace) (output []int64, fault error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fault = fmt.Errorf("%v", r)
+		}
+	}()
+	return emulateWithTrace(program, input, trace), nil
+}
+
+// disassembleWindow disassembles the executed instructions around address,
+// at most before of them preceding it and after of them following it. Only
+// executed addresses are used, as the program mixes code and data.
+func disassembleWindow(memory []int64, executed map[int64]bool, address int64, before, after int) (lines []string) {
+	var addresses []int64
+	for a := range executed {
+		addresses = append(addresses, a)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+
+	index := sort.Search(len(addresses), func(i int) bool { return addresses[i] >= address })
+	from, to := index-before, index+after+1
+	if from < 0 {
+		from = 0
+	}
+	if to > len(addresses) {
+		to = len(addresses)
+	}
+
+	var next int64 = -1
+	for _, a := range addresses[from:to] {
+		if next != -1 && a != next {
+			lines = append(lines, "         ...")
+		}
+
+		text, length := disassemble(memory, a)
+		marker := "  "
+		if a == address {
+			marker = "=>"
+		}
+		lines = append(lines, fmt.Sprintf("%s %5d: %s", marker, a, text))
+		next = a + length
+	}
+
+	return lines
+}
+
+var mnemonics = map[int64]struct {
+	name       string
+	parameters int64
+}{
+	1:  {"ADD", 3},
+	2:  {"MUL", 3},
+	3:  {"IN", 1},
+	4:  {"OUT", 1},
+	5:  {"JNZ", 2},
+	6:  {"JZ", 2},
+	7:  {"LT", 3},
+	8:  {"EQ", 3},
+	9:  {"ARB", 1},
+	99: {"HALT", 0},
+}
+
+// disassemble returns the text of the instruction at address and its length.
+func disassemble(memory []int64, address int64) (string, int64) {
+	instruction := memory[address]
+	mnemonic, ok := mnemonics[instruction%100]
+	if !ok {
+		return fmt.Sprintf("DATA %d", instruction), 1
+	}
+
+	words := []string{strconv.FormatInt(instruction, 10)}
+	var operands []string
+	for i := int64(1); i <= mnemonic.parameters && address+i < int64(len(memory)); i++ {
+		parameter := memory[address+i]
+		words = append(words, strconv.FormatInt(parameter, 10))
+		switch instruction / pow10(i+1) % 10 {
+		case 1:
+			operands = append(operands, strconv.FormatInt(parameter, 10))
+		case 2:
+			operands = append(operands, fmt.Sprintf("[rb%+d]", parameter))
+		default:
+			operands = append(operands, fmt.Sprintf("[%d]", parameter))
+		}
+	}
+
+	text := fmt.Sprintf("%-24s %s %s", strings.Join(words, ","), mnemonic.name, strings.Join(operands, ", "))
+	return strings.TrimSpace(text), 1 + mnemonic.parameters
+}
+
+func pow10(n int64) int64 {
+	var p int64 = 1
+	for ; n > 0; n-- {
+		p *= 10
+	}
+	return p
+}
+
 func emulate(program []int64, input []int64) (output []int64) {
+	return emulateWithTrace(program, input, nil)
+}
+
+func emulateWithTrace(program []int64, input []int64, trace *Trace) (output []int64) {
 	// We will get pointers as for write operators we might get relative mode
 	// so z=memory(ip+3) is not correct anymore
 	memory := make([]int64, 3000)
 	copy(memory, program)
+	if trace != nil {
+		trace.Memory = memory
+	}
 
 	var ip, relativeBase int64
 
 	for {
+		if trace != nil {
+			trace.Executed[ip] = true
+			trace.IP = ip
+		}
+
 		instruction := memory[ip]
 		a := instruction / 10000
 		b := (instruction - a*10000) / 1000
@@ -73,6 +254,10 @@ func emulate(program []int64, input []int64) (output []int64) {
 		case 4: // OUTPUT
 			x := fetchPointerToMemory(c, &memory, ip+1, relativeBase)
 			output = append(output, *x)
+			if trace != nil {
+				trace.Sources = append(trace.Sources, ip)
+				trace.Snapshots = append(trace.Snapshots, append([]int64(nil), memory...))
+			}
 			ip += 2
 
 		case 5: // JUMP IF TRUE
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestEmulateEmergencyHullPaintingRobot(t *testing.T) {
+	fake := loadFakeMachine("../intcode/examples/day11.fake")
+	grid := emulateEmergencyHullPaintingRobot(fake, 0)
+	if err := fake.Done(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(grid) != 6 {
+		t.Errorf("painted %d panels, want 6", len(grid))
+	}
+
+	// The first panel is painted white, then black again.
+	want := map[Vector2]int64{{0, 0}: 0, {-1, 0}: 0, {-1, 1}: 1, {0, 1}: 1, {1, 0}: 1, {1, -1}: 1}
+	for pos, color := range want {
+		if grid[pos] != color {
+			t.Errorf("panel %v is %d, want %d", pos, grid[pos], color)
+		}
+	}
+}
+
+func TestEmulateEmergencyHullPaintingRobotMismatch(t *testing.T) {
+	// The robot starts on a black panel, so it sends 0.
+	fake, err := parseFakeMachine("white.fake", "in 1\nout 1,0\nhalt\n")
+	check(err)
+
+	expectPanic(t, "fake machine: white.fake:1: sent 0, expected 1", func() {
+		emulateEmergencyHullPaintingRobot(fake, 0)
+	})
+}
+
+// expectPanic calls f, and checks it panics with the error message want.
+func expectPanic(t *testing.T, want string, f func()) {
+	t.Helper()
+	defer func() {
+		err, _ := recover().(error)
+		if err == nil {
+			t.Errorf("did not panic with an error, want %q", want)
+		} else if err.Error() != want {
+			t.Errorf("panicked with %q, want %q", err, want)
+		}
+	}()
+	f()
+}
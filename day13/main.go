@@ -19,9 +19,19 @@ const (
 // Warning: For my input, this outputs about 150k lines.
 var printFlag = flag.Bool("print", false, "print game state before each input is provided")
 
+var fakeFlag = flag.String("fake", "", "play against a fake machine playing back the script in `file`, instead of the program")
+
 func main() {
 	flag.Parse()
 
+	if *fakeFlag != "" {
+		fake := loadFakeMachine(*fakeFlag)
+		score := emulateArcadeCabinet(fake)
+		check(fake.Done())
+		fmt.Println("Score:", score)
+		return
+	}
+
 	input := readFile("input.txt")
 
 	var program []int64
@@ -30,40 +40,35 @@ func main() {
 	}
 
 	fmt.Println("--- Part One ---")
-	fmt.Println(countBlocks(program))
+	fmt.Println(countBlocks(startMachine(program)))
 
 	fmt.Println("--- Part Two ---")
-	fmt.Println(emulateArcadeCabinet(program))
+	// Insert quarters.
+	program[0] = 2
+	fmt.Println(emulateArcadeCabinet(startMachine(program)))
 }
 
-func countBlocks(program []int64) (count int) {
-	input := make(chan int64)
-	messages := make(chan Message)
-
-	go emulate(program, input, messages)
+// The cabinet draws tiles, and writes the score as a tile at (-1, 0).
+var tileShape = &RecordShape{
+	Name:      "tile",
+	Fields:    []string{"x", "y", "tile"},
+	Sentinels: []Sentinel{{Name: "score", Match: map[string]int64{"x": -1, "y": 0}}},
+}
 
+func countBlocks(machine Machine) (count int) {
 	grid := make(map[Vector2]int64)
+	framer := makeFramer(tileShape)
 
 	for {
-		message := <-messages
+		message := machine.Receive()
 		switch message.Kind {
 		case MessageOutput:
-			var pos Vector2
-			pos.X = int(message.Value)
-
-			message = <-messages
-			if message.Kind != MessageOutput {
-				panic("unexpected message")
-			}
-			pos.Y = int(message.Value)
-
-			message = <-messages
-			if message.Kind != MessageOutput {
-				panic("unexpected message")
+			if tile, ok := framer.Push(message.Value); ok && tile.Kind == "tile" {
+				grid[Vector2{int(tile.Field("x")), int(tile.Field("y"))}] = tile.Field("tile")
 			}
-			grid[pos] = message.Value
 
 		case MessageHalt:
+			check(framer.End("halted"))
 			for _, tile := range grid {
 				if tile == Block {
 					count++
@@ -77,22 +82,17 @@ func countBlocks(program []int64) (count int) {
 	}
 }
 
-func emulateArcadeCabinet(program []int64) int64 {
-	// Insert quarters.
-	program[0] = 2
-
-	input := make(chan int64)
-	messages := make(chan Message)
-
-	go emulate(program, input, messages)
-
+func emulateArcadeCabinet(machine Machine) int64 {
 	grid := make(map[Vector2]int64)
+	framer := makeFramer(tileShape)
 	var score int64
 
 	for {
-		message := <-messages
+		message := machine.Receive()
 		switch message.Kind {
 		case MessageWaitingForInput:
+			check(framer.End("waited for input"))
+
 			if *printFlag {
 				var min, max Vector2
 				for pos := range grid {
@@ -132,29 +132,20 @@ func emulateArcadeCabinet(program []int64) int64 {
 					paddle = pos
 				}
 			}
-			input <- int64(sign(ball.X - paddle.X))
+			machine.Send(int64(sign(ball.X - paddle.X)))
 
 		case MessageOutput:
-			var pos Vector2
-			pos.X = int(message.Value)
-
-			message = <-messages
-			if message.Kind != MessageOutput {
-				panic("unexpected message")
-			}
-			pos.Y = int(message.Value)
-
-			message = <-messages
-			if message.Kind != MessageOutput {
-				panic("unexpected message")
-			}
-			if pos.X == -1 && pos.Y == 0 {
-				score = message.Value
-			} else {
-				grid[pos] = message.Value
+			record, ok := framer.Push(message.Value)
+			switch {
+			case !ok:
+			case record.Kind == "score":
+				score = record.Field("tile")
+			default:
+				grid[Vector2{int(record.Field("x")), int(record.Field("y"))}] = record.Field("tile")
 			}
 
 		case MessageHalt:
+			check(framer.End("halted"))
 			return score
 
 		default:
@@ -163,6 +154,119 @@ func emulateArcadeCabinet(program []int64) int64 {
 	}
 }
 
+// Output is framed into records by a copy of the framer in
+// intcode/framing.go.
+
+// RecordShape declares the records a device writes.
+type RecordShape struct {
+	Name      string
+	Fields    []string
+	Sentinels []Sentinel
+}
+
+// Sentinel is a record with fixed values in some of its fields, which means
+// something else, like the score of day 13.
+type Sentinel struct {
+	Name  string
+	Match map[string]int64
+}
+
+type Record struct {
+	Kind   string // the name of the shape, or of the sentinel it matched
+	Values []int64
+	shape  *RecordShape
+}
+
+// Field returns the value of the field with the given name.
+func (record Record) Field(name string) int64 {
+	return record.Values[record.shape.index(name)]
+}
+
+func (shape *RecordShape) index(field string) int {
+	for i, name := range shape.Fields {
+		if name == field {
+			return i
+		}
+	}
+	panic(fmt.Sprintf("%s records have no field %q", shape.Name, field))
+}
+
+// FramingError is a record cut short.
+type FramingError struct {
+	Shape   *RecordShape
+	Partial []int64
+	Event   string // what happened instead of the next value
+}
+
+func (err *FramingError) Error() string {
+	var got []string
+	for i, value := range err.Partial {
+		got = append(got, fmt.Sprintf("%s=%d", err.Shape.Fields[i], value))
+	}
+	return fmt.Sprintf("incomplete %s record: program %s after %s, before %s",
+		err.Shape.Name, err.Event, strings.Join(got, ", "), strings.Join(err.Shape.Fields[len(err.Partial):], ", "))
+}
+
+type Framer struct {
+	shape   *RecordShape
+	partial []int64
+}
+
+func makeFramer(shape *RecordShape) *Framer {
+	for _, sentinel := range shape.Sentinels {
+		for field := range sentinel.Match {
+			shape.index(field)
+		}
+	}
+	return &Framer{shape: shape}
+}
+
+// Push adds an output value, and returns the record if it is complete.
+func (framer *Framer) Push(value int64) (Record, bool) {
+	framer.partial = append(framer.partial, value)
+	if len(framer.partial) < len(framer.shape.Fields) {
+		return Record{}, false
+	}
+
+	record := Record{Kind: framer.shape.Name, Values: framer.partial, shape: framer.shape}
+	framer.partial = nil
+	for _, sentinel := range framer.shape.Sentinels {
+		matches := true
+		for field, value := range sentinel.Match {
+			matches = matches && record.Field(field) == value
+		}
+		if matches {
+			record.Kind = sentinel.Name
+			break
+		}
+	}
+	return record, true
+}
+
+// End reports an error if the program stopped writing in the middle of a
+// record, because of the event (e.g. "halted").
+func (framer *Framer) End(event string) error {
+	if len(framer.partial) == 0 {
+		return nil
+	}
+	err := &FramingError{Shape: framer.shape, Partial: framer.partial, Event: event}
+	framer.partial = nil
+	return err
+}
+
+// Drivers talk to the program through a Machine, so that they can be run
+// against a FakeMachine playing back a script instead (see -fake). Machine
+// and FakeMachine are a copy of intcode/machine.go, which describes scripts.
+
+type Machine interface {
+	// Send gives the machine an input value.
+	Send(value int64)
+
+	// Receive runs the machine until it writes an output value, waits for
+	// input or halts.
+	Receive() Message
+}
+
 const (
 	MessageWaitingForInput = iota
 	MessageOutput
@@ -174,7 +278,241 @@ type Message struct {
 	Value int64
 }
 
-func emulate(program []int64, input <-chan int64, messages chan<- Message) {
+type FakeStep struct {
+	Line  int
+	Kind  int // MessageWaitingForInput for an input
+	Value int64
+	Any   bool // any input value
+}
+
+// A FakeMachine panics if the driver sends input other than the script
+// expects, or goes past its end, with an error naming the line of the step.
+type FakeMachine struct {
+	Filename string
+	Steps    []FakeStep
+	next     int
+}
+
+func (fake *FakeMachine) fail(format string, args ...interface{}) {
+	line := 0
+	if fake.next < len(fake.Steps) {
+		line = fake.Steps[fake.next].Line
+	} else if len(fake.Steps) > 0 {
+		line = fake.Steps[len(fake.Steps)-1].Line
+	}
+	panic(fmt.Errorf("fake machine: %s:%d: %s", fake.Filename, line, fmt.Sprintf(format, args...)))
+}
+
+func (fake *FakeMachine) Send(value int64) {
+	if fake.next == len(fake.Steps) {
+		fake.fail("sent %d after the end of the script", value)
+	}
+	step := fake.Steps[fake.next]
+	switch {
+	case step.Kind == MessageOutput:
+		fake.fail("sent %d, but the program writes %d first", value, step.Value)
+	case step.Kind == MessageHalt:
+		fake.fail("sent %d, but the program halted", value)
+	case !step.Any && value != step.Value:
+		fake.fail("sent %d, expected %d", value, step.Value)
+	}
+	fake.next++
+}
+
+func (fake *FakeMachine) Receive() Message {
+	if fake.next == len(fake.Steps) {
+		fake.fail("received after the end of the script")
+	}
+	step := fake.Steps[fake.next]
+	if step.Kind == MessageOutput {
+		fake.next++
+	}
+	return Message{Kind: step.Kind, Value: step.Value}
+}
+
+// Done returns an error if the driver stopped before the end of the script.
+func (fake *FakeMachine) Done() error {
+	if fake.next < len(fake.Steps) && fake.Steps[fake.next].Kind != MessageHalt {
+		return fmt.Errorf("fake machine: %s:%d: the driver stopped here", fake.Filename, fake.Steps[fake.next].Line)
+	}
+	return nil
+}
+
+// parseFakeMachine parses a script.
+func parseFakeMachine(filename, text string) (*FakeMachine, error) {
+	fake := &FakeMachine{Filename: filename}
+
+	for i, line := range strings.Split(text, "\n") {
+		if j := strings.Index(line, "#"); j >= 0 {
+			line = line[:j]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		errorf := func(format string, args ...interface{}) (*FakeMachine, error) {
+			return nil, fmt.Errorf("%s:%d: %s", filename, i+1, fmt.Sprintf(format, args...))
+		}
+
+		var values []int64
+		if len(fields) > 1 {
+			for _, field := range strings.Split(strings.Join(fields[1:], ""), ",") {
+				value, err := strconv.ParseInt(field, 10, 64)
+				if err != nil {
+					return errorf("invalid value %q", field)
+				}
+				values = append(values, value)
+			}
+		}
+
+		switch fields[0] {
+		case "out":
+			if len(values) == 0 {
+				return errorf("expected out values")
+			}
+			for _, value := range values {
+				fake.Steps = append(fake.Steps, FakeStep{Line: i + 1, Kind: MessageOutput, Value: value})
+			}
+
+		case "in":
+			if len(values) > 1 {
+				return errorf("expected in [value]")
+			}
+			step := FakeStep{Line: i + 1, Kind: MessageWaitingForInput, Any: len(values) == 0}
+			if len(values) == 1 {
+				step.Value = values[0]
+			}
+			fake.Steps = append(fake.Steps, step)
+
+		case "halt":
+			if len(values) > 0 {
+				return errorf("expected halt")
+			}
+			fake.Steps = append(fake.Steps, FakeStep{Line: i + 1, Kind: MessageHalt})
+
+		default:
+			return errorf("unknown step %q", fields[0])
+		}
+	}
+
+	return fake, nil
+}
+
+// loadFakeMachine reads a script.
+func loadFakeMachine(filename string) *FakeMachine {
+	fake, err := parseFakeMachine(filename, readFile(filename))
+	check(err)
+	return fake
+}
+
+// The emulator hands its output and input over in batches, rather than a
+// value at a time, by a copy of BatchedMachine in intcode/transport.go.
+
+// batchSize is how many values the program writes before they are handed
+// over anyway, so that long output streams.
+const batchSize = 1024
+
+type outputBatch struct {
+	Values []int64
+	Event  int // MessageOutput if the program runs on
+}
+
+type BatchedMachine struct {
+	inputs  chan []int64
+	outputs chan outputBatch
+
+	// The side of the emulator.
+	written []int64 // not handed over yet
+	unread  []int64 // handed over, not read yet
+
+	// The side of the driver.
+	received []int64 // handed over, not received yet
+	event    int     // that ended the last batch received
+	sent     []int64 // not handed over yet
+}
+
+func makeBatchedMachine() *BatchedMachine {
+	return &BatchedMachine{
+		inputs:  make(chan []int64),
+		outputs: make(chan outputBatch),
+		event:   MessageOutput,
+	}
+}
+
+// write is called by the emulator for an output value.
+func (machine *BatchedMachine) write(value int64) {
+	machine.written = append(machine.written, value)
+	if len(machine.written) == batchSize {
+		machine.flush(MessageOutput)
+	}
+}
+
+// read is called by the emulator for an input value, and blocks until the
+// driver sends some.
+func (machine *BatchedMachine) read() int64 {
+	if len(machine.unread) == 0 {
+		machine.flush(MessageWaitingForInput)
+		machine.unread = <-machine.inputs
+	}
+	value := machine.unread[0]
+	machine.unread = machine.unread[1:]
+	return value
+}
+
+// halt is called by the emulator when the program halts.
+func (machine *BatchedMachine) halt() {
+	machine.flush(MessageHalt)
+}
+
+func (machine *BatchedMachine) flush(event int) {
+	machine.outputs <- outputBatch{Values: machine.written, Event: event}
+	machine.written = nil
+}
+
+func (machine *BatchedMachine) Send(value int64) {
+	machine.sent = append(machine.sent, value)
+}
+
+func (machine *BatchedMachine) Receive() Message {
+	for {
+		if len(machine.received) > 0 {
+			value := machine.received[0]
+			machine.received = machine.received[1:]
+			return Message{Kind: MessageOutput, Value: value}
+		}
+
+		switch machine.event {
+		case MessageHalt:
+			return Message{Kind: MessageHalt}
+		case MessageWaitingForInput:
+			if len(machine.sent) == 0 {
+				return Message{Kind: MessageWaitingForInput}
+			}
+			machine.inputs <- machine.sent
+			machine.sent = nil
+		}
+
+		batch := <-machine.outputs
+		machine.received, machine.event = batch.Values, batch.Event
+	}
+}
+
+// Transport is the side of a machine the emulator talks to: a BatchedMachine,
+// or in the benchmarks unbuffered channels, as the emulator used before.
+type Transport interface {
+	write(value int64)
+	read() int64
+	halt()
+}
+
+func startMachine(program []int64) Machine {
+	machine := makeBatchedMachine()
+	go emulate(program, machine)
+	return machine
+}
+
+func emulate(program []int64, machine Transport) {
 	memory := make([]int64, 3000)
 	copy(memory, program)
 
@@ -203,14 +541,13 @@ func emulate(program []int64, input <-chan int64, messages chan<- Message) {
 			ip += 4
 
 		case 3: // INPUT
-			messages <- Message{Kind: MessageWaitingForInput}
 			x := fetchPointerToMemory(c, &memory, ip+1, relativeBase)
-			*x = <-input
+			*x = machine.read()
 			ip += 2
 
 		case 4: // OUTPUT
 			x := fetchPointerToMemory(c, &memory, ip+1, relativeBase)
-			messages <- Message{Kind: MessageOutput, Value: *x}
+			machine.write(*x)
 			ip += 2
 
 		case 5: // JUMP IF TRUE
@@ -262,7 +599,7 @@ func emulate(program []int64, input <-chan int64, messages chan<- Message) {
 			ip += 2
 
 		case 99: // HALT
-			messages <- Message{Kind: MessageHalt}
+			machine.halt()
 			return
 		default:
 			panic(fmt.Sprintf("error: invalid opcode: ip=%d, instruction=%d, opcode=%d", ip, instruction, opcode))
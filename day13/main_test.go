@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCountBlocks(t *testing.T) {
+	// Two blocks are drawn, and one of them is drawn over.
+	fake, err := parseFakeMachine("blocks.fake", "out 0,0,1, 2,1,2, 3,1,2, 2,1,0\nhalt\n")
+	check(err)
+
+	if count := countBlocks(fake); count != 1 {
+		t.Errorf("counted %d blocks, want 1", count)
+	}
+	if err := fake.Done(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEmulateArcadeCabinet(t *testing.T) {
+	fake := loadFakeMachine("../intcode/examples/day13.fake")
+	score := emulateArcadeCabinet(fake)
+	if err := fake.Done(); err != nil {
+		t.Fatal(err)
+	}
+
+	if score != 100 {
+		t.Errorf("score %d, want 100", score)
+	}
+}
+
+func BenchmarkEmulateArcadeCabinet(b *testing.B) {
+	program := loadGame()
+	for i := 0; i < b.N; i++ {
+		emulateArcadeCabinet(startMachine(program))
+	}
+}
+
+// BenchmarkEmulateArcadeCabinetChannel is the baseline for
+// BenchmarkEmulateArcadeCabinet, with every value passed through an
+// unbuffered channel.
+func BenchmarkEmulateArcadeCabinetChannel(b *testing.B) {
+	program := loadGame()
+	for i := 0; i < b.N; i++ {
+		emulateArcadeCabinet(startChannelMachine(program))
+	}
+}
+
+// loadGame reads the program, with quarters inserted.
+func loadGame() []int64 {
+	var program []int64
+	for _, value := range strings.Split(readFile("input.txt"), ",") {
+		program = append(program, toInt64(value))
+	}
+	program[0] = 2
+	return program
+}
+
+func TestEmulateArcadeCabinetMismatch(t *testing.T) {
+	// The ball is left of the paddle, so the joystick tilts left.
+	fake, err := parseFakeMachine("right.fake", "out 1,3,3, 0,2,4\nin 1\nhalt\n")
+	check(err)
+
+	expectPanic(t, "fake machine: right.fake:2: sent -1, expected 1", func() {
+		emulateArcadeCabinet(fake)
+	})
+}
+
+// expectPanic calls f, and checks it panics with the error message want.
+func expectPanic(t *testing.T, want string, f func()) {
+	t.Helper()
+	defer func() {
+		err, _ := recover().(error)
+		if err == nil {
+			t.Errorf("did not panic with an error, want %q", want)
+		} else if err.Error() != want {
+			t.Errorf("panicked with %q, want %q", err, want)
+		}
+	}()
+	f()
+}
+
+// channelMachine passes every value through an unbuffered channel, the way
+// the emulator did before BatchedMachine.
+type channelMachine struct {
+	input    chan int64
+	messages chan Message
+}
+
+func startChannelMachine(program []int64) Machine {
+	machine := &channelMachine{input: make(chan int64), messages: make(chan Message)}
+	go emulate(program, machine)
+	return machine
+}
+
+func (machine *channelMachine) write(value int64) {
+	machine.messages <- Message{Kind: MessageOutput, Value: value}
+}
+
+func (machine *channelMachine) read() int64 {
+	machine.messages <- Message{Kind: MessageWaitingForInput}
+	return <-machine.input
+}
+
+func (machine *channelMachine) halt() {
+	machine.messages <- Message{Kind: MessageHalt}
+}
+
+func (machine *channelMachine) Send(value int64) {
+	machine.input <- value
+}
+
+func (machine *channelMachine) Receive() Message {
+	return <-machine.messages
+}
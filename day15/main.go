@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"strconv"
@@ -43,7 +44,20 @@ type QueueItem struct {
 	Next     *QueueItem
 }
 
+var fakeFlag = flag.String("fake", "", "explore with a fake machine playing back the script in `file`, instead of the program")
+
 func main() {
+	flag.Parse()
+
+	if *fakeFlag != "" {
+		fake := loadFakeMachine(*fakeFlag)
+		grid, oxygenPos, oxygenDistance := explore(fake)
+		check(fake.Done())
+		fmt.Println("Oxygen system at", oxygenPos, "distance", oxygenDistance)
+		fmt.Println("Filled with oxygen in", fillWithOxygen(grid, oxygenPos))
+		return
+	}
+
 	text := readFile("input.txt")
 
 	var program []int64
@@ -51,20 +65,50 @@ func main() {
 		program = append(program, toInt64(value))
 	}
 
-	input := make(chan Direction)
-	output := make(chan int64)
-	halt := make(chan bool)
+	grid, oxygenPos, oxygenDistance := explore(startMachine(program))
+
+	fmt.Println("--- Part One ---")
+	fmt.Println(oxygenDistance)
+
+	fmt.Println("--- Part Two ---")
+	fmt.Println(fillWithOxygen(grid, oxygenPos))
+}
+
+// fillWithOxygen returns how long oxygen takes to fill the area.
+func fillWithOxygen(grid map[Vector2]int, oxygenPos Vector2) int {
+	var maxDistance int
+	var queue []QueueItem
+	queue = append(queue, QueueItem{Position: oxygenPos, Distance: 0})
+
+	visited := make(map[Vector2]bool)
+	visited[oxygenPos] = true
+
+	for len(queue) != 0 {
+		item := queue[0]
+		queue = queue[1:]
+
+		maxDistance = max(maxDistance, item.Distance)
+
+		for _, dir := range directions {
+			next := item.Position.Add(dir)
+			if !visited[next] && grid[next] == Path {
+				visited[next] = true
+				queue = append(queue, QueueItem{Position: next, Distance: item.Distance + 1})
+			}
+		}
+	}
 
-	go emulate(program, input, output, halt)
+	return maxDistance
+}
 
+// explore moves the droid through the whole area, breadth-first, and returns
+// the map, and where the oxygen system is and how far from the start.
+func explore(machine Machine) (grid map[Vector2]int, oxygenPos Vector2, oxygenDistance int) {
 	var pos Vector2
 
-	grid := make(map[Vector2]int)
+	grid = make(map[Vector2]int)
 	grid[pos] = Path
 
-	var oxygenPos Vector2
-	var oxygenDistance int
-
 	var queue []QueueItem
 	queue = append(queue, QueueItem{Position: pos, Distance: 0})
 
@@ -72,13 +116,13 @@ func main() {
 		item := queue[0]
 		queue = queue[1:]
 
-		pos = navigate(pos, item.Position, grid, input, output)
+		pos = navigate(pos, item.Position, grid, machine)
 
 		for _, cmd := range commands {
 			next, nextDistance := pos.Add(direction[cmd]), item.Distance+1
 			if _, ok := grid[next]; !ok {
-				input <- cmd
-				switch <-output {
+				machine.Send(int64(cmd))
+				switch receiveStatus(machine) {
 				case 0:
 					grid[next] = Wall
 				case 2:
@@ -91,42 +135,17 @@ func main() {
 					grid[next] = Path
 					queue = append(queue, QueueItem{Position: next, Distance: nextDistance})
 					// Command succeeded, go back to try other commands.
-					input <- reverse[cmd]
-					<-output
+					machine.Send(int64(reverse[cmd]))
+					receiveStatus(machine)
 				}
 			}
 		}
 	}
 
-	fmt.Println("--- Part One ---")
-	fmt.Println(oxygenDistance)
-
-	var maxDistance int
-	queue = append(queue, QueueItem{Position: oxygenPos, Distance: 0})
-
-	visited := make(map[Vector2]bool)
-	visited[oxygenPos] = true
-
-	for len(queue) != 0 {
-		item := queue[0]
-		queue = queue[1:]
-
-		maxDistance = max(maxDistance, item.Distance)
-
-		for _, dir := range directions {
-			next := item.Position.Add(dir)
-			if !visited[next] && grid[next] == Path {
-				visited[next] = true
-				queue = append(queue, QueueItem{Position: next, Distance: item.Distance + 1})
-			}
-		}
-	}
-
-	fmt.Println("--- Part Two ---")
-	fmt.Println(maxDistance)
+	return grid, oxygenPos, oxygenDistance
 }
 
-func navigate(pos, target Vector2, grid map[Vector2]int, input chan Direction, output chan int64) Vector2 {
+func navigate(pos, target Vector2, grid map[Vector2]int, machine Machine) Vector2 {
 	var link *QueueItem
 
 	// Find shortest route from target to pos (note reversed order).
@@ -160,8 +179,8 @@ func navigate(pos, target Vector2, grid map[Vector2]int, input chan Direction, o
 	for link.Next != nil {
 		for cmd, dir := range direction {
 			if link.Position.Add(dir) == link.Next.Position {
-				input <- cmd
-				<-output
+				machine.Send(int64(cmd))
+				receiveStatus(machine)
 				break
 			}
 		}
@@ -172,6 +191,193 @@ func navigate(pos, target Vector2, grid map[Vector2]int, input chan Direction, o
 	return target
 }
 
+// Drivers talk to the program through a Machine, so that they can be run
+// against a FakeMachine playing back a script instead (see -fake). Machine
+// and FakeMachine are a copy of intcode/machine.go, which describes scripts.
+
+type Machine interface {
+	// Send gives the machine an input value.
+	Send(value int64)
+
+	// Receive runs the machine until it writes an output value, waits for
+	// input or halts.
+	Receive() Message
+}
+
+const (
+	MessageWaitingForInput = iota
+	MessageOutput
+	MessageHalt
+)
+
+type Message struct {
+	Kind  int
+	Value int64
+}
+
+type FakeStep struct {
+	Line  int
+	Kind  int // MessageWaitingForInput for an input
+	Value int64
+	Any   bool // any input value
+}
+
+// A FakeMachine panics if the driver sends input other than the script
+// expects, or goes past its end, with an error naming the line of the step.
+type FakeMachine struct {
+	Filename string
+	Steps    []FakeStep
+	next     int
+}
+
+func (fake *FakeMachine) fail(format string, args ...interface{}) {
+	line := 0
+	if fake.next < len(fake.Steps) {
+		line = fake.Steps[fake.next].Line
+	} else if len(fake.Steps) > 0 {
+		line = fake.Steps[len(fake.Steps)-1].Line
+	}
+	panic(fmt.Errorf("fake machine: %s:%d: %s", fake.Filename, line, fmt.Sprintf(format, args...)))
+}
+
+func (fake *FakeMachine) Send(value int64) {
+	if fake.next == len(fake.Steps) {
+		fake.fail("sent %d after the end of the script", value)
+	}
+	step := fake.Steps[fake.next]
+	switch {
+	case step.Kind == MessageOutput:
+		fake.fail("sent %d, but the program writes %d first", value, step.Value)
+	case step.Kind == MessageHalt:
+		fake.fail("sent %d, but the program halted", value)
+	case !step.Any && value != step.Value:
+		fake.fail("sent %d, expected %d", value, step.Value)
+	}
+	fake.next++
+}
+
+func (fake *FakeMachine) Receive() Message {
+	if fake.next == len(fake.Steps) {
+		fake.fail("received after the end of the script")
+	}
+	step := fake.Steps[fake.next]
+	if step.Kind == MessageOutput {
+		fake.next++
+	}
+	return Message{Kind: step.Kind, Value: step.Value}
+}
+
+// Done returns an error if the driver stopped before the end of the script.
+func (fake *FakeMachine) Done() error {
+	if fake.next < len(fake.Steps) && fake.Steps[fake.next].Kind != MessageHalt {
+		return fmt.Errorf("fake machine: %s:%d: the driver stopped here", fake.Filename, fake.Steps[fake.next].Line)
+	}
+	return nil
+}
+
+// parseFakeMachine parses a script.
+func parseFakeMachine(filename, text string) (*FakeMachine, error) {
+	fake := &FakeMachine{Filename: filename}
+
+	for i, line := range strings.Split(text, "\n") {
+		if j := strings.Index(line, "#"); j >= 0 {
+			line = line[:j]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		errorf := func(format string, args ...interface{}) (*FakeMachine, error) {
+			return nil, fmt.Errorf("%s:%d: %s", filename, i+1, fmt.Sprintf(format, args...))
+		}
+
+		var values []int64
+		if len(fields) > 1 {
+			for _, field := range strings.Split(strings.Join(fields[1:], ""), ",") {
+				value, err := strconv.ParseInt(field, 10, 64)
+				if err != nil {
+					return errorf("invalid value %q", field)
+				}
+				values = append(values, value)
+			}
+		}
+
+		switch fields[0] {
+		case "out":
+			if len(values) == 0 {
+				return errorf("expected out values")
+			}
+			for _, value := range values {
+				fake.Steps = append(fake.Steps, FakeStep{Line: i + 1, Kind: MessageOutput, Value: value})
+			}
+
+		case "in":
+			if len(values) > 1 {
+				return errorf("expected in [value]")
+			}
+			step := FakeStep{Line: i + 1, Kind: MessageWaitingForInput, Any: len(values) == 0}
+			if len(values) == 1 {
+				step.Value = values[0]
+			}
+			fake.Steps = append(fake.Steps, step)
+
+		case "halt":
+			if len(values) > 0 {
+				return errorf("expected halt")
+			}
+			fake.Steps = append(fake.Steps, FakeStep{Line: i + 1, Kind: MessageHalt})
+
+		default:
+			return errorf("unknown step %q", fields[0])
+		}
+	}
+
+	return fake, nil
+}
+
+// loadFakeMachine reads a script.
+func loadFakeMachine(filename string) *FakeMachine {
+	fake, err := parseFakeMachine(filename, readFile(filename))
+	check(err)
+	return fake
+}
+
+// channelMachine runs the program in a goroutine.
+type channelMachine struct {
+	input  chan Direction
+	output chan int64
+	halt   chan bool
+}
+
+func startMachine(program []int64) Machine {
+	machine := &channelMachine{input: make(chan Direction), output: make(chan int64), halt: make(chan bool)}
+	go emulate(program, machine.input, machine.output, machine.halt)
+	return machine
+}
+
+func (machine *channelMachine) Send(value int64) {
+	machine.input <- Direction(value)
+}
+
+func (machine *channelMachine) Receive() Message {
+	select {
+	case value := <-machine.output:
+		return Message{Kind: MessageOutput, Value: value}
+	case <-machine.halt:
+		return Message{Kind: MessageHalt}
+	}
+}
+
+// receiveStatus returns the status the droid reports after a move.
+func receiveStatus(machine Machine) int64 {
+	message := machine.Receive()
+	if message.Kind != MessageOutput {
+		panic("the droid did not report its status")
+	}
+	return message.Value
+}
+
 func emulate(program []int64, input <-chan Direction, output chan<- int64, halt chan<- bool) {
 	// We will get pointers as for write operators we might get relative mode
 	// so z=memory(ip+3) is not correct anymore
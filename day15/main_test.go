@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestExplore(t *testing.T) {
+	fake := loadFakeMachine("../intcode/examples/day15.fake")
+	grid, oxygenPos, oxygenDistance := explore(fake)
+	if err := fake.Done(); err != nil {
+		t.Fatal(err)
+	}
+
+	if oxygenPos != (Vector2{1, 0}) || oxygenDistance != 1 {
+		t.Errorf("oxygen system at %v, distance %d, want {1 0}, distance 1", oxygenPos, oxygenDistance)
+	}
+	if minutes := fillWithOxygen(grid, oxygenPos); minutes != 1 {
+		t.Errorf("filled with oxygen in %d, want 1", minutes)
+	}
+}
+
+func TestExploreMismatch(t *testing.T) {
+	// The droid tries north, then south, not east.
+	fake, err := parseFakeMachine("east.fake", "in 1\nout 0\nin 4\nout 0\n")
+	check(err)
+
+	expectPanic(t, "fake machine: east.fake:3: sent 2, expected 4", func() {
+		explore(fake)
+	})
+}
+
+// expectPanic calls f, and checks it panics with the error message want.
+func expectPanic(t *testing.T, want string, f func()) {
+	t.Helper()
+	defer func() {
+		err, _ := recover().(error)
+		if err == nil {
+			t.Errorf("did not panic with an error, want %q", want)
+		} else if err.Error() != want {
+			t.Errorf("panicked with %q, want %q", err, want)
+		}
+	}()
+	f()
+}
@@ -27,30 +27,8 @@ func main() {
 		program = append(program, toInt64(value))
 	}
 
-	var grid []string
-	var width, height int
-
-	input := make(chan int64)
-	output := make(chan int64)
-	halt := make(chan bool)
-
-	go emulate(program, input, output, halt)
-
-	var builder strings.Builder
-
-loop:
-	for {
-		select {
-		case char := <-output:
-			builder.WriteRune(rune(char))
-
-		case <-halt:
-			break loop
-		}
-	}
-
-	grid = strings.Split(strings.TrimSpace(builder.String()), "\n")
-	width, height = len(grid[0]), len(grid)
+	grid := readCamera(startMachine(program))
+	width, height := len(grid[0]), len(grid)
 
 	fmt.Println("--- Part One ---")
 	sumOfAlignmentParameters := 0
@@ -124,11 +102,7 @@ loop:
 		panic("no solution found")
 	}
 
-	input = make(chan int64, 100)
-	output = make(chan int64)
-	halt = make(chan bool)
-
-	go emulate(program, input, output, halt)
+	machine := startMachine(program)
 
 	functions := result[0]
 	main := strings.Join(functions[0], ",")
@@ -137,23 +111,44 @@ loop:
 	c := strings.Join(functions[3], ",")
 
 	for _, c := range fmt.Sprintf("%s\n%s\n%s\n%s\nn\n", main, a, b, c) {
-		input <- int64(c)
+		machine.Send(int64(c))
 	}
 
 loop2:
 	for {
-		select {
-		case char := <-output:
-			if char >= 128 {
-				fmt.Println(char)
+		message := machine.Receive()
+		switch message.Kind {
+		case MessageOutput:
+			if message.Value >= 128 {
+				fmt.Println(message.Value)
 			}
 
-		case <-halt:
+		case MessageHalt:
 			break loop2
 		}
 	}
 }
 
+// readCamera receives the output of the program until it halts, and returns
+// the lines of the image of the camera.
+func readCamera(machine Machine) []string {
+	var builder strings.Builder
+
+loop:
+	for {
+		message := machine.Receive()
+		switch message.Kind {
+		case MessageOutput:
+			builder.WriteRune(rune(message.Value))
+
+		case MessageHalt:
+			break loop
+		}
+	}
+
+	return strings.Split(strings.TrimSpace(builder.String()), "\n")
+}
+
 type MoveList []string
 
 // Parameters:
@@ -174,7 +169,7 @@ func compressPath(path MoveList, fragments []MoveList, functions []MoveList) (re
 		for len(path) != 0 {
 			for i, function := range functions {
 				if hasPrefix(path, function) {
-					mainFunction = append(mainFunction, string('A'+i))
+					mainFunction = append(mainFunction, string(rune('A'+i)))
 					path = path[len(function):]
 				}
 			}
@@ -272,7 +267,134 @@ func index(list, sublist MoveList) int {
 	return -1
 }
 
-func emulate(program []int64, input <-chan int64, output chan<- int64, halt chan<- bool) {
+// The emulator hands its output and input over in batches, rather than a
+// value at a time, by a copy of BatchedMachine in intcode/transport.go, and
+// Machine is a copy of intcode/machine.go.
+
+type Machine interface {
+	// Send gives the machine an input value.
+	Send(value int64)
+
+	// Receive runs the machine until it writes an output value, waits for
+	// input or halts.
+	Receive() Message
+}
+
+const (
+	MessageWaitingForInput = iota
+	MessageOutput
+	MessageHalt
+)
+
+type Message struct {
+	Kind  int
+	Value int64
+}
+
+// batchSize is how many values the program writes before they are handed
+// over anyway, so that long output streams.
+const batchSize = 1024
+
+type outputBatch struct {
+	Values []int64
+	Event  int // MessageOutput if the program runs on
+}
+
+type BatchedMachine struct {
+	inputs  chan []int64
+	outputs chan outputBatch
+
+	// The side of the emulator.
+	written []int64 // not handed over yet
+	unread  []int64 // handed over, not read yet
+
+	// The side of the driver.
+	received []int64 // handed over, not received yet
+	event    int     // that ended the last batch received
+	sent     []int64 // not handed over yet
+}
+
+func makeBatchedMachine() *BatchedMachine {
+	return &BatchedMachine{
+		inputs:  make(chan []int64),
+		outputs: make(chan outputBatch),
+		event:   MessageOutput,
+	}
+}
+
+// write is called by the emulator for an output value.
+func (machine *BatchedMachine) write(value int64) {
+	machine.written = append(machine.written, value)
+	if len(machine.written) == batchSize {
+		machine.flush(MessageOutput)
+	}
+}
+
+// read is called by the emulator for an input value, and blocks until the
+// driver sends some.
+func (machine *BatchedMachine) read() int64 {
+	if len(machine.unread) == 0 {
+		machine.flush(MessageWaitingForInput)
+		machine.unread = <-machine.inputs
+	}
+	value := machine.unread[0]
+	machine.unread = machine.unread[1:]
+	return value
+}
+
+// halt is called by the emulator when the program halts.
+func (machine *BatchedMachine) halt() {
+	machine.flush(MessageHalt)
+}
+
+func (machine *BatchedMachine) flush(event int) {
+	machine.outputs <- outputBatch{Values: machine.written, Event: event}
+	machine.written = nil
+}
+
+func (machine *BatchedMachine) Send(value int64) {
+	machine.sent = append(machine.sent, value)
+}
+
+func (machine *BatchedMachine) Receive() Message {
+	for {
+		if len(machine.received) > 0 {
+			value := machine.received[0]
+			machine.received = machine.received[1:]
+			return Message{Kind: MessageOutput, Value: value}
+		}
+
+		switch machine.event {
+		case MessageHalt:
+			return Message{Kind: MessageHalt}
+		case MessageWaitingForInput:
+			if len(machine.sent) == 0 {
+				return Message{Kind: MessageWaitingForInput}
+			}
+			machine.inputs <- machine.sent
+			machine.sent = nil
+		}
+
+		batch := <-machine.outputs
+		machine.received, machine.event = batch.Values, batch.Event
+	}
+}
+
+// Transport is the side of a machine the emulator talks to: a BatchedMachine,
+// or in the benchmarks unbuffered channels, as the emulator used before.
+type Transport interface {
+	write(value int64)
+	read() int64
+	halt()
+}
+
+func startMachine(program []int64) Machine {
+	machine := makeBatchedMachine()
+	go emulate(program, machine)
+	return machine
+}
+
+func emulate(program []int64, machine Transport) {
 	// We will get pointers as for write operators we might get relative mode
 	// so z=memory(ip+3) is not correct anymore
 	memory := make([]int64, 3000)
@@ -304,12 +426,12 @@ func emulate(program []int64, input <-chan int64, output chan<- int64, halt chan
 
 		case 3: // INPUT
 			x := fetchPointerToMemory(c, &memory, ip+1, relativeBase)
-			*x = <-input
+			*x = machine.read()
 			ip += 2
 
 		case 4: // OUTPUT
 			x := fetchPointerToMemory(c, &memory, ip+1, relativeBase)
-			output <- *x
+			machine.write(*x)
 			ip += 2
 
 		case 5: // JUMP IF TRUE
@@ -361,7 +483,7 @@ func emulate(program []int64, input <-chan int64, output chan<- int64, halt chan
 			ip += 2
 
 		case 99: // HALT
-			halt <- true
+			machine.halt()
 			return
 		default:
 			panic(fmt.Sprintf("error: invalid opcode: ip=%d, instruction=%d, opcode=%d", ip, instruction, opcode))
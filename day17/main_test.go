@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func BenchmarkReadCamera(b *testing.B) {
+	program := loadProgram()
+	for i := 0; i < b.N; i++ {
+		readCamera(startMachine(program))
+	}
+}
+
+// BenchmarkReadCameraChannel is the baseline for BenchmarkReadCamera, with
+// every value passed through an unbuffered channel.
+func BenchmarkReadCameraChannel(b *testing.B) {
+	program := loadProgram()
+	for i := 0; i < b.N; i++ {
+		readCamera(startChannelMachine(program))
+	}
+}
+
+func loadProgram() []int64 {
+	var program []int64
+	for _, value := range strings.Split(readFile("input.txt"), ",") {
+		program = append(program, toInt64(value))
+	}
+	return program
+}
+
+// channelMachine passes every value through an unbuffered channel, the way
+// the emulator did before BatchedMachine.
+type channelMachine struct {
+	input    chan int64
+	messages chan Message
+}
+
+func startChannelMachine(program []int64) Machine {
+	machine := &channelMachine{input: make(chan int64), messages: make(chan Message)}
+	go emulate(program, machine)
+	return machine
+}
+
+func (machine *channelMachine) write(value int64) {
+	machine.messages <- Message{Kind: MessageOutput, Value: value}
+}
+
+func (machine *channelMachine) read() int64 {
+	machine.messages <- Message{Kind: MessageWaitingForInput}
+	return <-machine.input
+}
+
+func (machine *channelMachine) halt() {
+	machine.messages <- Message{Kind: MessageHalt}
+}
+
+func (machine *channelMachine) Send(value int64) {
+	machine.input <- value
+}
+
+func (machine *channelMachine) Receive() Message {
+	return <-machine.messages
+}
@@ -3,27 +3,39 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 var program []int64
 
+// beam answers the probes of part two, one after the other.
+var beam *Machine
+var probeInput, probeOutput = make([]int64, 2), make([]int64, 0, 1)
+
 func main() {
 	text := readFile("input.txt")
 
 	for _, value := range strings.Split(text, ",") {
 		program = append(program, toInt64(value))
 	}
-	fmt.Println("--- Part One ---")
+	beam = makeMachine(program)
 
-	count := 0
+	fmt.Println("--- Part One ---")
 
+	var queries [][]int64
 	for y := 0; y < 50; y++ {
 		for x := 0; x < 50; x++ {
-			if probe(x, y) {
-				count++
-			}
+			queries = append(queries, []int64{int64(x), int64(y)})
+		}
+	}
+
+	count := 0
+	for _, output := range makePool(program, runtime.NumCPU()).Batch(queries) {
+		if output[0] == 1 {
+			count++
 		}
 	}
 
@@ -55,28 +67,85 @@ func main() {
 }
 
 func probe(x, y int) bool {
-	input := make(chan int64)
-	output := make(chan int64)
-	halt := make(chan bool, 1)
+	probeInput[0], probeInput[1] = int64(x), int64(y)
+	probeOutput = beam.Run(probeInput, probeOutput[:0])
+	return probeOutput[0] == 1
+}
 
-	go emulate(program, input, output, halt)
+// Machine runs the program without goroutines or channels, so that one
+// machine can answer many queries: every run starts by restoring the memory
+// from the pristine program, reusing the memory of the previous run.
+type Machine struct {
+	program          []int64
+	memory           []int64
+	ip, relativeBase int64
+}
 
-	input <- int64(x)
-	input <- int64(y)
+func makeMachine(program []int64) *Machine {
+	machine := &Machine{program: program, memory: make([]int64, 0, 3000)}
+	machine.Reset()
+	return machine
+}
 
-	return <-output == 1
+// Reset restores the machine to its state before the program started.
+func (machine *Machine) Reset() {
+	machine.memory = append(machine.memory[:0], machine.program...)
+	machine.ip, machine.relativeBase = 0, 0
 }
 
-func emulate(program []int64, input <-chan int64, output chan<- int64, halt chan<- bool) {
-	// We will get pointers as for write operators we might get relative mode
-	// so z=memory(ip+3) is not correct anymore
-	memory := make([]int64, 3000)
-	copy(memory, program)
+// Pool runs the program for many independent inputs concurrently.
+type Pool struct {
+	machines []*Machine
+}
 
-	var ip, relativeBase int64
+func makePool(program []int64, size int) *Pool {
+	pool := &Pool{}
+	for i := 0; i < size; i++ {
+		pool.machines = append(pool.machines, makeMachine(program))
+	}
+	return pool
+}
+
+// Batch runs the program once for every input and returns the outputs, in
+// the order of the inputs.
+func (pool *Pool) Batch(inputs [][]int64) [][]int64 {
+	outputs := make([][]int64, len(inputs))
+	jobs := make(chan int, len(inputs))
+	for i := range inputs {
+		jobs <- i
+	}
+	close(jobs)
+
+	var wait sync.WaitGroup
+	for _, machine := range pool.machines {
+		wait.Add(1)
+		go func(machine *Machine) {
+			defer wait.Done()
+			// The outputs of the runs of the machine, one after another, so
+			// that they do not each allocate their own.
+			var buffer []int64
+			for i := range jobs {
+				start := len(buffer)
+				buffer = machine.Run(inputs[i], buffer)
+				outputs[i] = buffer[start:len(buffer):len(buffer)]
+			}
+		}(machine)
+	}
+	wait.Wait()
+
+	return outputs
+}
+
+// Run resets the machine and runs the program with the given input until it
+// halts. The output is appended to output, so that passing a buffer from the
+// previous run avoids allocating.
+func (machine *Machine) Run(input []int64, output []int64) []int64 {
+	machine.Reset()
+	memory := &machine.memory
+	ip, relativeBase := machine.ip, machine.relativeBase
 
 	for {
-		instruction := memory[ip]
+		instruction := (*memory)[ip]
 		a := instruction / 10000
 		b := (instruction - a*10000) / 1000
 		c := (instruction - a*10000 - b*1000) / 100
@@ -84,32 +153,36 @@ func emulate(program []int64, input <-chan int64, output chan<- int64, halt chan
 
 		switch opcode {
 		case 1: // ADD
-			x := fetchPointerToMemory(c, &memory, ip+1, relativeBase)
-			y := fetchPointerToMemory(b, &memory, ip+2, relativeBase)
-			z := fetchPointerToMemory(a, &memory, ip+3, relativeBase)
+			x := fetchPointerToMemory(c, memory, ip+1, relativeBase)
+			y := fetchPointerToMemory(b, memory, ip+2, relativeBase)
+			z := fetchPointerToMemory(a, memory, ip+3, relativeBase)
 			*z = *x + *y
 			ip += 4
 
 		case 2: // MULTIPLY
-			x := fetchPointerToMemory(c, &memory, ip+1, relativeBase)
-			y := fetchPointerToMemory(b, &memory, ip+2, relativeBase)
-			z := fetchPointerToMemory(a, &memory, ip+3, relativeBase)
+			x := fetchPointerToMemory(c, memory, ip+1, relativeBase)
+			y := fetchPointerToMemory(b, memory, ip+2, relativeBase)
+			z := fetchPointerToMemory(a, memory, ip+3, relativeBase)
 			*z = *x * *y
 			ip += 4
 
 		case 3: // INPUT
-			x := fetchPointerToMemory(c, &memory, ip+1, relativeBase)
-			*x = <-input
+			if len(input) == 0 {
+				panic(fmt.Sprintf("error: out of input: ip=%d", ip))
+			}
+			x := fetchPointerToMemory(c, memory, ip+1, relativeBase)
+			*x = input[0]
+			input = input[1:]
 			ip += 2
 
 		case 4: // OUTPUT
-			x := fetchPointerToMemory(c, &memory, ip+1, relativeBase)
-			output <- *x
+			x := fetchPointerToMemory(c, memory, ip+1, relativeBase)
+			output = append(output, *x)
 			ip += 2
 
 		case 5: // JUMP IF TRUE
-			x := fetchPointerToMemory(c, &memory, ip+1, relativeBase)
-			y := fetchPointerToMemory(b, &memory, ip+2, relativeBase)
+			x := fetchPointerToMemory(c, memory, ip+1, relativeBase)
+			y := fetchPointerToMemory(b, memory, ip+2, relativeBase)
 			if *x != 0 {
 				ip = *y
 			} else {
@@ -117,8 +190,8 @@ func emulate(program []int64, input <-chan int64, output chan<- int64, halt chan
 			}
 
 		case 6: // JUMP IF FALSE
-			x := fetchPointerToMemory(c, &memory, ip+1, relativeBase)
-			y := fetchPointerToMemory(b, &memory, ip+2, relativeBase)
+			x := fetchPointerToMemory(c, memory, ip+1, relativeBase)
+			y := fetchPointerToMemory(b, memory, ip+2, relativeBase)
 			if *x == 0 {
 				ip = *y
 			} else {
@@ -126,9 +199,9 @@ func emulate(program []int64, input <-chan int64, output chan<- int64, halt chan
 			}
 
 		case 7: // LESS THAN
-			x := fetchPointerToMemory(c, &memory, ip+1, relativeBase)
-			y := fetchPointerToMemory(b, &memory, ip+2, relativeBase)
-			z := fetchPointerToMemory(a, &memory, ip+3, relativeBase)
+			x := fetchPointerToMemory(c, memory, ip+1, relativeBase)
+			y := fetchPointerToMemory(b, memory, ip+2, relativeBase)
+			z := fetchPointerToMemory(a, memory, ip+3, relativeBase)
 
 			if *x < *y {
 				*z = 1
@@ -139,9 +212,9 @@ func emulate(program []int64, input <-chan int64, output chan<- int64, halt chan
 			ip += 4
 
 		case 8: // EQUAL
-			x := fetchPointerToMemory(c, &memory, ip+1, relativeBase)
-			y := fetchPointerToMemory(b, &memory, ip+2, relativeBase)
-			z := fetchPointerToMemory(a, &memory, ip+3, relativeBase)
+			x := fetchPointerToMemory(c, memory, ip+1, relativeBase)
+			y := fetchPointerToMemory(b, memory, ip+2, relativeBase)
+			z := fetchPointerToMemory(a, memory, ip+3, relativeBase)
 			if *x == *y {
 				*z = 1
 			} else {
@@ -151,13 +224,13 @@ func emulate(program []int64, input <-chan int64, output chan<- int64, halt chan
 			ip += 4
 
 		case 9: // ADJUST RELATIVE BASE
-			x := fetchPointerToMemory(c, &memory, ip+1, relativeBase)
+			x := fetchPointerToMemory(c, memory, ip+1, relativeBase)
 			relativeBase += *x
 			ip += 2
 
 		case 99: // HALT
-			halt <- true
-			return
+			machine.ip, machine.relativeBase = ip, relativeBase
+			return output
 		default:
 			panic(fmt.Sprintf("error: invalid opcode: ip=%d, instruction=%d, opcode=%d", ip, instruction, opcode))
 		}
@@ -24,14 +24,23 @@ func main() {
 		in[i] <- -1
 	}
 
+	framers := make([]*Framer, 50)
+	for i := range framers {
+		framers[i] = makeFramer(packetShape)
+	}
+
 	idle := 0
 	var old, nat [2]int64
 
 	for i := 0; ; i = (i + 1) % 50 {
 		select {
 		case addr := <-out[i]:
+			framers[i].Push(addr)
+			packet, _, err := framers[i].Receive(out[i], nil)
+			check(err)
+
 			if addr == 255 {
-				new := [2]int64{<-out[i], <-out[i]}
+				new := [2]int64{packet.Field("x"), packet.Field("y")}
 				if nat == [2]int64{} {
 					fmt.Println("--- Part One ---")
 					fmt.Println(new[1])
@@ -39,8 +48,8 @@ func main() {
 
 				nat = new
 			} else {
-				in[addr] <- <-out[i]
-				in[addr] <- <-out[i]
+				in[addr] <- packet.Field("x")
+				in[addr] <- packet.Field("y")
 			}
 
 			idle = 0
@@ -63,6 +72,125 @@ func main() {
 	}
 }
 
+// Computers send packets of a destination address, X and Y.
+var packetShape = &RecordShape{Name: "packet", Fields: []string{"address", "x", "y"}}
+
+// Output is framed into records by a copy of the framer in
+// intcode/framing.go.
+
+// RecordShape declares the records a device writes.
+type RecordShape struct {
+	Name      string
+	Fields    []string
+	Sentinels []Sentinel
+}
+
+// Sentinel is a record with fixed values in some of its fields, which means
+// something else, like the score of day 13.
+type Sentinel struct {
+	Name  string
+	Match map[string]int64
+}
+
+type Record struct {
+	Kind   string // the name of the shape, or of the sentinel it matched
+	Values []int64
+	shape  *RecordShape
+}
+
+// Field returns the value of the field with the given name.
+func (record Record) Field(name string) int64 {
+	return record.Values[record.shape.index(name)]
+}
+
+func (shape *RecordShape) index(field string) int {
+	for i, name := range shape.Fields {
+		if name == field {
+			return i
+		}
+	}
+	panic(fmt.Sprintf("%s records have no field %q", shape.Name, field))
+}
+
+// FramingError is a record cut short.
+type FramingError struct {
+	Shape   *RecordShape
+	Partial []int64
+	Event   string // what happened instead of the next value
+}
+
+func (err *FramingError) Error() string {
+	var got []string
+	for i, value := range err.Partial {
+		got = append(got, fmt.Sprintf("%s=%d", err.Shape.Fields[i], value))
+	}
+	return fmt.Sprintf("incomplete %s record: program %s after %s, before %s",
+		err.Shape.Name, err.Event, strings.Join(got, ", "), strings.Join(err.Shape.Fields[len(err.Partial):], ", "))
+}
+
+type Framer struct {
+	shape   *RecordShape
+	partial []int64
+}
+
+func makeFramer(shape *RecordShape) *Framer {
+	for _, sentinel := range shape.Sentinels {
+		for field := range sentinel.Match {
+			shape.index(field)
+		}
+	}
+	return &Framer{shape: shape}
+}
+
+// Push adds an output value, and returns the record if it is complete.
+func (framer *Framer) Push(value int64) (Record, bool) {
+	framer.partial = append(framer.partial, value)
+	if len(framer.partial) < len(framer.shape.Fields) {
+		return Record{}, false
+	}
+
+	record := Record{Kind: framer.shape.Name, Values: framer.partial, shape: framer.shape}
+	framer.partial = nil
+	for _, sentinel := range framer.shape.Sentinels {
+		matches := true
+		for field, value := range sentinel.Match {
+			matches = matches && record.Field(field) == value
+		}
+		if matches {
+			record.Kind = sentinel.Name
+			break
+		}
+	}
+	return record, true
+}
+
+// End reports an error if the program stopped writing in the middle of a
+// record, because of the event (e.g. "halted").
+func (framer *Framer) End(event string) error {
+	if len(framer.partial) == 0 {
+		return nil
+	}
+	err := &FramingError{Shape: framer.shape, Partial: framer.partial, Event: event}
+	framer.partial = nil
+	return err
+}
+
+// Receive reads a record from the output channel of an emulator running in a
+// goroutine. It reports false if the emulator halted instead; halt may be
+// nil for emulators that do not signal it.
+func (framer *Framer) Receive(output <-chan int64, halt <-chan bool) (Record, bool, error) {
+	for {
+		select {
+		case value := <-output:
+			if record, ok := framer.Push(value); ok {
+				return record, true, nil
+			}
+		case <-halt:
+			return Record{}, false, framer.End("halted")
+		}
+	}
+}
+
 func emulate(program []int64, input <-chan int64, output chan<- int64) {
 	memory := make([]int64, 5000)
 	copy(memory, program)
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"strconv"
+)
+
+// This file finds the code of a program and splits it into functions, based
+// on the calling convention of the puzzle programs (see compiler.go): a call
+// writes the return address to [rb+0] and jumps, a function reserves its frame
+// with ARB n and returns by jumping to the address at the bottom of its frame.
+
+type analysis struct {
+	Memory    []int64
+	Code      map[int64]Line
+	Functions map[int64]*function
+
+	// Address of the word mirroring the relative base in compiled programs
+	// with local arrays, or -1.
+	FramePointer int64
+}
+
+type callSite struct {
+	Address int64   // address of the instruction writing the return address
+	Jump    int64   // address of the jump to the function
+	Return  int64   // return address
+	Target  Operand // jump target; immediate for direct calls
+}
+
+type function struct {
+	Entry int64
+	Name  string
+	Frame int64 // size of the frame reserved by the prologue
+	Body  int64 // first address after the prologue
+
+	// Instructions maps the addresses of the function's instructions to the
+	// difference between the relative base there and at the entry point.
+	Instructions map[int64]int64
+	Calls        map[int64]*callSite
+	Returns      map[int64]bool // addresses of jumps returning from the function
+	Invalid      map[int64]bool // addresses reached that hold no valid instruction
+
+	Params       int
+	ReturnsValue bool
+}
+
+// analyze finds the functions reachable from address 0.
+func analyze(memory []int64) *analysis {
+	a := &analysis{
+		Memory:       memory,
+		Code:         make(map[int64]Line),
+		Functions:    make(map[int64]*function),
+		FramePointer: -1,
+	}
+
+	queue := []int64{0}
+	for len(queue) != 0 {
+		entry := queue[0]
+		queue = queue[1:]
+		if a.Functions[entry] != nil {
+			continue
+		}
+
+		f, candidates := a.traceFunction(entry)
+		a.Functions[entry] = f
+
+		for _, call := range f.Calls {
+			if call.Target.Mode == ModeImmediate {
+				queue = append(queue, call.Target.Value)
+			}
+		}
+		queue = append(queue, candidates...)
+	}
+
+	for entry, f := range a.Functions {
+		if entry == 0 {
+			f.Name = "main"
+		} else {
+			f.Name = "f" + strconv.FormatInt(entry, 10)
+		}
+	}
+
+	return a
+}
+
+// isPrologue reports whether line reserves a stack frame.
+func isPrologue(line Line) bool {
+	return line.Opcode == OpAdjustBase && line.Operands[0].Mode == ModeImmediate && line.Operands[0].Value > 0
+}
+
+// isFramePointerUpdate reports whether line adds delta to a word in memory,
+// as compiled programs do to mirror the relative base.
+func isFramePointerUpdate(line Line, delta int64) bool {
+	if line.Opcode != OpAdd {
+		return false
+	}
+	x, y, z := line.Operands[0], line.Operands[1], line.Operands[2]
+	return x.Mode == ModePosition && z.Mode == ModePosition && x.Value == z.Value &&
+		y.Mode == ModeImmediate && y.Value == delta
+}
+
+// traceFunction follows the control flow of the function at entry without
+// entering the functions it calls. It also returns the constants written by
+// the function that look like addresses of functions, as some programs pass
+// functions as arguments.
+func (a *analysis) traceFunction(entry int64) (f *function, candidates []int64) {
+	f = &function{
+		Entry:        entry,
+		Body:         entry,
+		Instructions: make(map[int64]int64),
+		Calls:        make(map[int64]*callSite),
+		Returns:      make(map[int64]bool),
+		Invalid:      make(map[int64]bool),
+	}
+
+	if line, ok := decode(a.Memory, entry); ok && isPrologue(line) {
+		a.Code[entry] = line
+		f.Frame = line.Operands[0].Value
+		f.Body = entry + line.Length()
+
+		if line, ok := decode(a.Memory, f.Body); ok && isFramePointerUpdate(line, f.Frame) {
+			a.FramePointer = line.Operands[0].Value
+		}
+	}
+
+	type item struct {
+		Address, Delta int64
+	}
+	stack := []item{{f.Body, f.Frame}}
+
+	for len(stack) != 0 {
+		address, delta := stack[len(stack)-1].Address, stack[len(stack)-1].Delta
+		stack = stack[:len(stack)-1]
+
+		if _, ok := f.Instructions[address]; ok {
+			continue
+		}
+
+		line, ok := decode(a.Memory, address)
+		if !ok {
+			f.Invalid[address] = true
+			continue
+		}
+		f.Instructions[address] = delta
+		a.Code[address] = line
+		next := address + line.Length()
+
+		if value, ok := constantResult(line); ok {
+			if target, ok := decode(a.Memory, value); ok && value != entry && isPrologue(target) {
+				candidates = append(candidates, value)
+			}
+
+			// A call writes the return address to [rb+0] and jumps.
+			if line.Operands[2] == Relative(0) {
+				if jump, ok := decode(a.Memory, next); ok {
+					if target, ok := unconditionalJump(jump); ok {
+						f.Calls[address] = &callSite{Address: address, Jump: next, Return: value, Target: target}
+						f.Instructions[next] = delta
+						a.Code[next] = jump
+						stack = append(stack, item{value, delta})
+						continue
+					}
+				}
+			}
+		}
+
+		switch line.Opcode {
+		case OpHalt:
+
+		case OpAdjustBase:
+			if line.Operands[0].Mode == ModeImmediate {
+				delta += line.Operands[0].Value
+			}
+			stack = append(stack, item{next, delta})
+
+		case OpJumpIfTrue, OpJumpIfFalse:
+			target := line.Operands[1]
+			if _, ok := unconditionalJump(line); ok {
+				switch {
+				case target.Mode == ModeImmediate:
+					stack = append(stack, item{target.Value, delta})
+				case target.Mode == ModeRelative && target.Value+delta == 0:
+					f.Returns[address] = true
+				}
+				continue
+			}
+
+			stack = append(stack, item{next, delta})
+			if line.Operands[0].Mode != ModeImmediate && target.Mode == ModeImmediate {
+				stack = append(stack, item{target.Value, delta})
+			}
+
+		default:
+			stack = append(stack, item{next, delta})
+		}
+	}
+
+	return f, candidates
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Parameter modes, as encoded in the hundreds, thousands and ten thousands
+// digits of an instruction.
+const (
+	ModePosition  = 0
+	ModeImmediate = 1
+	ModeRelative  = 2
+)
+
+// Opcode describes an Intcode instruction.
+type Opcode struct {
+	Name       string
+	Parameters int64
+}
+
+var opcodes = map[int64]Opcode{
+	1:  {"ADD", 3},
+	2:  {"MUL", 3},
+	3:  {"IN", 1},
+	4:  {"OUT", 1},
+	5:  {"JNZ", 2},
+	6:  {"JZ", 2},
+	7:  {"LT", 3},
+	8:  {"EQ", 3},
+	9:  {"ARB", 1},
+	99: {"HALT", 0},
+}
+
+const (
+	OpAdd         = 1
+	OpMultiply    = 2
+	OpInput       = 3
+	OpOutput      = 4
+	OpJumpIfTrue  = 5
+	OpJumpIfFalse = 6
+	OpLessThan    = 7
+	OpEqual       = 8
+	OpAdjustBase  = 9
+	OpHalt        = 99
+)
+
+// Operand is an instruction parameter or a data word. If Symbol is set, the
+// address of that label is added to Value when the program is assembled.
+type Operand struct {
+	Mode   int64
+	Value  int64
+	Symbol string
+}
+
+func Immediate(value int64) Operand {
+	return Operand{Mode: ModeImmediate, Value: value}
+}
+
+func Absolute(address int64) Operand {
+	return Operand{Mode: ModePosition, Value: address}
+}
+
+func Relative(offset int64) Operand {
+	return Operand{Mode: ModeRelative, Value: offset}
+}
+
+func (o Operand) String() string {
+	var value string
+	switch {
+	case o.Symbol == "":
+		value = strconv.FormatInt(o.Value, 10)
+	case o.Value == 0:
+		value = o.Symbol
+	default:
+		value = fmt.Sprintf("%s%+d", o.Symbol, o.Value)
+	}
+
+	switch o.Mode {
+	case ModePosition:
+		return "[" + value + "]"
+	case ModeRelative:
+		return fmt.Sprintf("[rb%+d]", o.Value)
+	default:
+		return value
+	}
+}
+
+type LineKind int
+
+const (
+	LineLabel LineKind = iota
+	LineInstruction
+	LineData
+	LineSpace
+	LineSection
+)
+
+// Line is one line of assembly: a label definition, an instruction, a run of
+// data words, a number of reserved zero words, or in object modules the start
+// of a segment (see object.go).
+type Line struct {
+	Kind     LineKind
+	Label    string
+	Opcode   int64
+	Operands []Operand
+	Size     int64
+	Segment  int
+}
+
+func Label(name string) Line {
+	return Line{Kind: LineLabel, Label: name}
+}
+
+func Instruction(opcode int64, operands ...Operand) Line {
+	return Line{Kind: LineInstruction, Opcode: opcode, Operands: operands}
+}
+
+func Data(words ...Operand) Line {
+	return Line{Kind: LineData, Operands: words}
+}
+
+func Space(size int64) Line {
+	return Line{Kind: LineSpace, Size: size}
+}
+
+func Section(segment int) Line {
+	return Line{Kind: LineSection, Segment: segment}
+}
+
+func (line Line) Length() int64 {
+	switch line.Kind {
+	case LineInstruction:
+		return 1 + int64(len(line.Operands))
+	case LineData:
+		return int64(len(line.Operands))
+	case LineSpace:
+		return line.Size
+	default:
+		return 0
+	}
+}
+
+func (line Line) String() string {
+	var operands []string
+	for _, operand := range line.Operands {
+		operands = append(operands, operand.String())
+	}
+
+	switch line.Kind {
+	case LineLabel:
+		return line.Label + ":"
+	case LineInstruction:
+		return strings.TrimSpace(opcodes[line.Opcode].Name + " " + strings.Join(operands, ", "))
+	case LineData:
+		return "DATA " + strings.Join(operands, ", ")
+	case LineSection:
+		return "SECTION " + segmentNames[line.Segment]
+	default:
+		return fmt.Sprintf("SPACE %d", line.Size)
+	}
+}
+
+func formatAssembly(lines []Line) string {
+	var builder strings.Builder
+	for _, line := range lines {
+		if line.Kind != LineLabel {
+			builder.WriteString("\t")
+		}
+		builder.WriteString(line.String())
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// assemble assigns addresses to the lines and encodes them as Intcode. The
+// returned symbol table maps labels to their addresses. Reserved space at the
+// end of the program is not part of the image, as Intcode memory beyond the
+// program is zero anyway.
+func assemble(lines []Line) (program []int64, symbols map[string]int64, err error) {
+	symbols = make(map[string]int64)
+
+	var address int64
+	for _, line := range lines {
+		if line.Kind == LineLabel {
+			if _, ok := symbols[line.Label]; ok {
+				return nil, nil, fmt.Errorf("duplicate label %q", line.Label)
+			}
+			symbols[line.Label] = address
+		}
+		address += line.Length()
+	}
+
+	resolve := func(operand Operand) (int64, error) {
+		if operand.Symbol == "" {
+			return operand.Value, nil
+		}
+		address, ok := symbols[operand.Symbol]
+		if !ok {
+			return 0, fmt.Errorf("undefined label %q", operand.Symbol)
+		}
+		return address + operand.Value, nil
+	}
+
+	for _, line := range lines {
+		switch line.Kind {
+		case LineInstruction:
+			instruction := line.Opcode
+			for i, operand := range line.Operands {
+				instruction += operand.Mode * pow(10, int64(i)+2)
+			}
+			program = append(program, instruction)
+			fallthrough
+
+		case LineData:
+			for _, operand := range line.Operands {
+				value, err := resolve(operand)
+				if err != nil {
+					return nil, nil, err
+				}
+				program = append(program, value)
+			}
+
+		case LineSpace:
+			for i := int64(0); i < line.Size; i++ {
+				program = append(program, 0)
+			}
+		}
+	}
+
+	// Trim the trailing reserved space.
+	end := int64(len(program))
+	for i := len(lines) - 1; i >= 0 && (lines[i].Kind == LineSpace || lines[i].Kind == LineLabel); i-- {
+		end -= lines[i].Length()
+	}
+
+	return program[:end], symbols, nil
+}
+
+// parseAssembly parses assembly in the syntax formatAssembly writes, so that
+// modules can be written by hand. Comments start with a semicolon, a label
+// may precede an instruction on its line, values may be character literals,
+// and DATA also takes strings, as their characters.
+func parseAssembly(filename, text string) ([]Line, error) {
+	var lines []Line
+	for number, text := range strings.Split(text, "\n") {
+		parsed, err := parseAssemblyLine(stripComment(text))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, number+1, err)
+		}
+		lines = append(lines, parsed...)
+	}
+	return lines, nil
+}
+
+func parseAssemblyLine(text string) (lines []Line, err error) {
+	text = strings.TrimSpace(text)
+	if colon := strings.Index(text, ":"); colon > 0 && isIdentifier(text[:colon]) {
+		lines = append(lines, Label(text[:colon]))
+		text = strings.TrimSpace(text[colon+1:])
+	}
+	if text == "" {
+		return lines, nil
+	}
+
+	mnemonic, rest := text, ""
+	if space := strings.IndexAny(text, " \t"); space >= 0 {
+		mnemonic, rest = text[:space], strings.TrimSpace(text[space+1:])
+	}
+	mnemonic = strings.ToUpper(mnemonic)
+
+	switch mnemonic {
+	case "SECTION":
+		for segment, name := range segmentNames {
+			if name == rest {
+				return append(lines, Section(segment)), nil
+			}
+		}
+		return nil, fmt.Errorf("unknown section %q", rest)
+
+	case "SPACE":
+		size, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid size %q", rest)
+		}
+		return append(lines, Space(size)), nil
+
+	case "DATA":
+		var words []Operand
+		for _, field := range splitOperands(rest) {
+			if strings.HasPrefix(field, "\"") {
+				s, err := strconv.Unquote(field)
+				if err != nil {
+					return nil, fmt.Errorf("invalid string %s", field)
+				}
+				for _, r := range s {
+					words = append(words, Immediate(int64(r)))
+				}
+				continue
+			}
+			operand, err := parseOperand(field)
+			if err != nil {
+				return nil, err
+			}
+			words = append(words, operand)
+		}
+		return append(lines, Data(words...)), nil
+	}
+
+	for opcode, info := range opcodes {
+		if info.Name != mnemonic {
+			continue
+		}
+		var operands []Operand
+		for _, field := range splitOperands(rest) {
+			operand, err := parseOperand(field)
+			if err != nil {
+				return nil, err
+			}
+			operands = append(operands, operand)
+		}
+		if int64(len(operands)) != info.Parameters {
+			return nil, fmt.Errorf("%s takes %d operands, but has %d", info.Name, info.Parameters, len(operands))
+		}
+		return append(lines, Instruction(opcode, operands...)), nil
+	}
+	return nil, fmt.Errorf("unknown instruction %q", mnemonic)
+}
+
+// parseOperand parses an operand as Operand.String writes it: a value, which
+// is a number, a character literal or a label with an optional offset, in
+// brackets for position mode, or [rb+n] for relative mode.
+func parseOperand(text string) (Operand, error) {
+	mode := int64(ModeImmediate)
+	if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+		mode = ModePosition
+		text = strings.TrimSpace(text[1 : len(text)-1])
+		if text == "rb" || strings.HasPrefix(text, "rb+") || strings.HasPrefix(text, "rb-") {
+			mode = ModeRelative
+			text = strings.TrimPrefix(text[2:], "+")
+			if text == "" {
+				text = "0"
+			}
+		}
+	}
+
+	if value, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return Operand{Mode: mode, Value: value}, nil
+	}
+	if strings.HasPrefix(text, "'") {
+		if s, err := strconv.Unquote(text); err == nil && len([]rune(s)) == 1 {
+			return Operand{Mode: mode, Value: int64([]rune(s)[0])}, nil
+		}
+	}
+	if mode != ModeRelative {
+		symbol, offset := text, int64(0)
+		if sign := strings.IndexAny(text, "+-"); sign > 0 {
+			var err error
+			symbol = text[:sign]
+			if offset, err = strconv.ParseInt(text[sign:], 10, 64); err != nil {
+				return Operand{}, fmt.Errorf("invalid offset in %q", text)
+			}
+		}
+		if isIdentifier(symbol) {
+			return Operand{Mode: mode, Value: offset, Symbol: symbol}, nil
+		}
+	}
+	return Operand{}, fmt.Errorf("invalid operand %q", text)
+}
+
+// splitOperands splits at the commas outside of quotes.
+func splitOperands(text string) []string {
+	var fields []string
+	var quote rune
+	start := 0
+	for i, r := range text {
+		switch {
+		case quote != 0 && r == quote && !escaped(text[:i]):
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == ',':
+			fields = append(fields, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(text[start:]); last != "" || len(fields) > 0 {
+		fields = append(fields, last)
+	}
+	return fields
+}
+
+// stripComment removes a comment, which starts with a semicolon outside of
+// quotes.
+func stripComment(text string) string {
+	var quote rune
+	for i, r := range text {
+		switch {
+		case quote != 0 && r == quote && !escaped(text[:i]):
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == ';':
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// escaped reports whether the text ends in an odd number of backslashes,
+// which escape the character after it.
+func escaped(text string) bool {
+	backslashes := len(text) - len(strings.TrimRight(text, "\\"))
+	return backslashes%2 == 1
+}
+
+func isIdentifier(text string) bool {
+	for i, r := range text {
+		if !(r == '_' || r == '.' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return text != ""
+}
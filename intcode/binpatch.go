@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Binary patches record the difference between two programs, so that tweaks
+// made by editing or patching a program can be handed around as files and
+// applied to, or reverted from, another copy of it. Like an image, a binary
+// patch is laid out as follows, with all numbers as varints:
+//
+//	magic     "INTP"
+//	version   1 byte
+//	metadata  count, then count pairs of length-prefixed key and value
+//	base      word count and checksum of the program the patch applies to
+//	target    word count and checksum of the program it makes
+//	changes   count, then for every changed word the distance from the
+//	          previous address (from 0 for the first), and the old and new
+//	          values as zigzag varints
+//	checksum  CRC-32 (IEEE) of everything before it, 4 bytes big endian
+//
+// Words past the end of the shorter program count as 0, as the memory would
+// read at run time, so a change may grow or shrink the program. Programs are
+// checksummed as coverage profiles checksum them, by their text.
+
+const (
+	binaryPatchMagic   = "INTP"
+	binaryPatchVersion = 1
+)
+
+type BinaryPatch struct {
+	Metadata map[string]string
+
+	BaseLength, TargetLength     int64
+	BaseChecksum, TargetChecksum uint32
+
+	Changes []WordChange // by address
+}
+
+type WordChange struct {
+	Address  int64
+	Old, New int64
+}
+
+// diffPrograms returns the patch turning base into target.
+func diffPrograms(base, target []int64) BinaryPatch {
+	patch := BinaryPatch{
+		Metadata:       make(map[string]string),
+		BaseLength:     int64(len(base)),
+		TargetLength:   int64(len(target)),
+		BaseChecksum:   programChecksum(base),
+		TargetChecksum: programChecksum(target),
+	}
+
+	word := func(program []int64, address int) int64 {
+		if address < len(program) {
+			return program[address]
+		}
+		return 0
+	}
+	for address := 0; address < len(base) || address < len(target); address++ {
+		if old, new := word(base, address), word(target, address); old != new {
+			patch.Changes = append(patch.Changes, WordChange{Address: int64(address), Old: old, New: new})
+		}
+	}
+	return patch
+}
+
+// reversed returns the patch undoing patch.
+func (patch BinaryPatch) reversed() BinaryPatch {
+	reversed := BinaryPatch{
+		Metadata:       patch.Metadata,
+		BaseLength:     patch.TargetLength,
+		TargetLength:   patch.BaseLength,
+		BaseChecksum:   patch.TargetChecksum,
+		TargetChecksum: patch.BaseChecksum,
+	}
+	for _, change := range patch.Changes {
+		reversed.Changes = append(reversed.Changes, WordChange{Address: change.Address, Old: change.New, New: change.Old})
+	}
+	return reversed
+}
+
+// apply applies the patch to a copy of the program and returns it. The
+// program must be the one the patch was made from, unless force is set, and
+// every word changed must hold its old value either way.
+func (patch BinaryPatch) apply(program []int64, force bool) ([]int64, error) {
+	if !force {
+		if int64(len(program)) != patch.BaseLength {
+			return nil, fmt.Errorf("program has %d words, the patch expects %d", len(program), patch.BaseLength)
+		}
+		if checksum := programChecksum(program); checksum != patch.BaseChecksum {
+			return nil, fmt.Errorf("program checksum is %08x, the patch expects %08x", checksum, patch.BaseChecksum)
+		}
+	}
+
+	length := int64(len(program))
+	if patch.TargetLength > length {
+		length = patch.TargetLength
+	}
+	if n := len(patch.Changes); n > 0 && patch.Changes[n-1].Address >= length {
+		length = patch.Changes[n-1].Address + 1
+	}
+	patched := make([]int64, length)
+	copy(patched, program)
+
+	for _, change := range patch.Changes {
+		if patched[change.Address] != change.Old {
+			return nil, fmt.Errorf("address %d is %d, the patch expects %d", change.Address, patched[change.Address], change.Old)
+		}
+		patched[change.Address] = change.New
+	}
+
+	// The program patched is the one the patch was made for, or if forced
+	// onto another one, keeps its length unless the patch writes past it.
+	if int64(len(program)) == patch.BaseLength {
+		return patched[:patch.TargetLength], nil
+	}
+	end := len(program)
+	for _, change := range patch.Changes {
+		if change.New != 0 && change.Address >= int64(end) {
+			end = int(change.Address) + 1
+		}
+	}
+	patched = patched[:end]
+	return patched, nil
+}
+
+// isBinaryPatch reports whether data starts like a binary patch.
+func isBinaryPatch(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(binaryPatchMagic))
+}
+
+func encodeBinaryPatch(patch BinaryPatch) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString(binaryPatchMagic)
+	buffer.WriteByte(binaryPatchVersion)
+
+	var scratch [binary.MaxVarintLen64]byte
+	writeUvarint := func(value uint64) {
+		buffer.Write(scratch[:binary.PutUvarint(scratch[:], value)])
+	}
+	writeVarint := func(value int64) {
+		buffer.Write(scratch[:binary.PutVarint(scratch[:], value)])
+	}
+	writeString := func(s string) {
+		writeUvarint(uint64(len(s)))
+		buffer.WriteString(s)
+	}
+
+	// Keys are sorted, so that the same patch always encodes the same.
+	var keys []string
+	for key := range patch.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	writeUvarint(uint64(len(keys)))
+	for _, key := range keys {
+		writeString(key)
+		writeString(patch.Metadata[key])
+	}
+
+	writeUvarint(uint64(patch.BaseLength))
+	writeUvarint(uint64(patch.BaseChecksum))
+	writeUvarint(uint64(patch.TargetLength))
+	writeUvarint(uint64(patch.TargetChecksum))
+
+	writeUvarint(uint64(len(patch.Changes)))
+	previous := int64(0)
+	for _, change := range patch.Changes {
+		writeUvarint(uint64(change.Address - previous))
+		writeVarint(change.Old)
+		writeVarint(change.New)
+		previous = change.Address
+	}
+
+	var checksum [4]byte
+	binary.BigEndian.PutUint32(checksum[:], crc32.ChecksumIEEE(buffer.Bytes()))
+	buffer.Write(checksum[:])
+
+	return buffer.Bytes()
+}
+
+var errTruncatedBinaryPatch = errors.New("patch is truncated")
+
+func decodeBinaryPatch(data []byte) (BinaryPatch, error) {
+	if !isBinaryPatch(data) {
+		return BinaryPatch{}, errors.New("not an Intcode patch")
+	}
+	if len(data) < len(binaryPatchMagic)+1+4 {
+		return BinaryPatch{}, errTruncatedBinaryPatch
+	}
+
+	body, checksum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != checksum {
+		return BinaryPatch{}, errors.New("patch checksum mismatch")
+	}
+	if version := body[len(binaryPatchMagic)]; version != binaryPatchVersion {
+		return BinaryPatch{}, fmt.Errorf("unsupported patch version %d", version)
+	}
+	patch := BinaryPatch{Metadata: make(map[string]string)}
+
+	reader := bytes.NewReader(body[len(binaryPatchMagic)+1:])
+	readUvarint := func() uint64 {
+		value, err := binary.ReadUvarint(reader)
+		if err != nil {
+			panic(errTruncatedBinaryPatch)
+		}
+		return value
+	}
+	readVarint := func() int64 {
+		value, err := binary.ReadVarint(reader)
+		if err != nil {
+			panic(errTruncatedBinaryPatch)
+		}
+		return value
+	}
+	readString := func() string {
+		length := readUvarint()
+		if length > uint64(reader.Len()) {
+			panic(errTruncatedBinaryPatch)
+		}
+		value := make([]byte, length)
+		reader.Read(value)
+		return string(value)
+	}
+
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = r.(error)
+			}
+		}()
+
+		for count := readUvarint(); count > 0; count-- {
+			key := readString()
+			patch.Metadata[key] = readString()
+		}
+
+		patch.BaseLength, patch.BaseChecksum = int64(readUvarint()), uint32(readUvarint())
+		patch.TargetLength, patch.TargetChecksum = int64(readUvarint()), uint32(readUvarint())
+
+		count := readUvarint()
+		if count > uint64(reader.Len()) {
+			panic(errTruncatedBinaryPatch)
+		}
+		address := int64(0)
+		for i := uint64(0); i < count; i++ {
+			distance := readUvarint()
+			if i > 0 && distance == 0 || distance > 1<<40 {
+				panic(errors.New("patch addresses out of order"))
+			}
+			address += int64(distance)
+			patch.Changes = append(patch.Changes, WordChange{Address: address, Old: readVarint(), New: readVarint()})
+		}
+	}()
+
+	return patch, err
+}
+
+// loadBinaryPatch reads a binary patch file.
+func loadBinaryPatch(filename string) BinaryPatch {
+	data, err := ioutil.ReadFile(filename)
+	check(err)
+	patch, err := decodeBinaryPatch(data)
+	if err != nil {
+		panic(fmt.Errorf("%s: %v", filename, err))
+	}
+	return patch
+}
+
+// patchSet converts the changes of a binary patch to a patch set, as the diff
+// command prints them. Words past the end of the base program are written
+// without verifying them, as patch sets only verify words of the program.
+func (patch BinaryPatch) patchSet(name string) PatchSet {
+	set := PatchSet{Name: name}
+	for _, change := range patch.Changes {
+		verified := change.Address < patch.BaseLength
+		if n := len(set.Patches); n > 0 {
+			last := &set.Patches[n-1]
+			if last.Address+int64(len(last.After)) == change.Address && (last.Before != nil) == verified {
+				if verified {
+					last.Before = append(last.Before, change.Old)
+				}
+				last.After = append(last.After, change.New)
+				continue
+			}
+		}
+		p := Patch{Address: change.Address, After: []int64{change.New}}
+		if verified {
+			p.Before = []int64{change.Old}
+		}
+		set.Patches = append(set.Patches, p)
+	}
+	return set
+}
+
+// formatPatch formats a patch as a line of a patch file.
+func formatPatch(patch Patch) string {
+	if patch.Before == nil {
+		return fmt.Sprintf("%d = %s", patch.Address, formatProgram(patch.After))
+	}
+	return fmt.Sprintf("%d: %s -> %s", patch.Address, formatProgram(patch.Before), formatProgram(patch.After))
+}
+
+func diffCommand(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	outputFlag := flags.String("o", "", "write a binary patch to this file instead of listing the changes")
+	nameFlag := flags.String("name", "", "name of the patch")
+	notesFlag := flags.String("notes", "", "notes, e.g. what the patch does")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: intcode diff [-o file] [-name s] [-notes s] old new")
+		os.Exit(2)
+	}
+
+	patch := diffPrograms(loadProgram(flags.Arg(0)), loadProgram(flags.Arg(1)))
+	for key, value := range map[string]string{"name": *nameFlag, "notes": *notesFlag} {
+		if value != "" {
+			patch.Metadata[key] = value
+		}
+	}
+
+	if *outputFlag != "" {
+		check(ioutil.WriteFile(*outputFlag, encodeBinaryPatch(patch), 0644))
+		return
+	}
+
+	// Without -o, the changes are printed as a patch set for a patch file.
+	name := patch.Metadata["name"]
+	if name == "" {
+		name = "diff"
+	}
+	if *notesFlag != "" {
+		fmt.Printf("# %s\n", *notesFlag)
+	}
+	fmt.Printf("patch %s\n", name)
+	for _, p := range patch.patchSet(name).Patches {
+		fmt.Println(formatPatch(p))
+	}
+}
+
+func applyCommand(args []string) {
+	binaryPatchCommand("apply", args)
+}
+
+func revertCommand(args []string) {
+	binaryPatchCommand("revert", args)
+}
+
+// binaryPatchCommand applies a binary patch to a program, or reverts it.
+func binaryPatchCommand(name string, args []string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	outputFlag := flags.String("o", "", "write the program to this file instead of stdout")
+	forceFlag := flags.Bool("f", false, "apply to a program other than the one the patch was made for, if the words it changes match")
+	infoFlag := flags.Bool("info", false, "show the patch instead of applying it")
+	flags.Parse(args)
+
+	if *infoFlag && flags.NArg() == 1 {
+		showBinaryPatch(loadBinaryPatch(flags.Arg(0)))
+		return
+	}
+	if flags.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "usage: intcode %s [-o file] [-f] patch program\n       intcode %s -info patch\n", name, name)
+		os.Exit(2)
+	}
+
+	patch := loadBinaryPatch(flags.Arg(0))
+	if name == "revert" {
+		patch = patch.reversed()
+	}
+	program, err := patch.apply(loadProgram(flags.Arg(1)), *forceFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "intcode: %s: %v\n", flags.Arg(0), err)
+		os.Exit(1)
+	}
+
+	text := formatProgram(program) + "\n"
+	if *outputFlag != "" {
+		check(ioutil.WriteFile(*outputFlag, []byte(text), 0644))
+	} else {
+		fmt.Print(text)
+	}
+}
+
+func showBinaryPatch(patch BinaryPatch) {
+	var keys []string
+	for key := range patch.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("%s: %s\n", key, patch.Metadata[key])
+	}
+	fmt.Printf("base: %d words, checksum %08x\n", patch.BaseLength, patch.BaseChecksum)
+	fmt.Printf("target: %d words, checksum %08x\n", patch.TargetLength, patch.TargetChecksum)
+	fmt.Printf("changes: %d\n", len(patch.Changes))
+	for _, p := range patch.patchSet("").Patches {
+		fmt.Println(formatPatch(p))
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A shadow call stack follows the calls and returns of a running program, to
+// print backtraces. Intcode has no call instruction, so they are inferred
+// from the calling convention of the puzzle programs (see compiler.go): a
+// jump is a call if the word at [rb+0] holds the address after it, the
+// return address, and a jump to the return address of a frame on the stack,
+// with the relative base back at its value at the call, returns from it and
+// from any frames above it.
+
+type CallFrame struct {
+	Entry        int64 // the function called
+	Call         int64 // address of the jump calling it
+	Return       int64
+	RelativeBase int64 // at the call
+}
+
+type CallStack struct {
+	Frames []CallFrame
+
+	// If trace is set, calls and returns are written to it as they happen.
+	trace io.Writer
+
+	// events records the calls and returns of the steps in the emulator's
+	// history, so that rewind can undo them along with the steps.
+	events []callEvent
+}
+
+type callEvent struct {
+	Step   int
+	Popped []CallFrame // nil for a call
+}
+
+// jump is called before a jump taken from ip to target.
+func (calls *CallStack) jump(emulator *Emulator, target int64) {
+	ip, rb := emulator.ip, emulator.relativeBase
+	step := -1
+	if emulator.history != nil {
+		step = len(emulator.history.Steps) - 1
+	}
+
+	if rb >= 0 && rb < int64(len(emulator.memory)) && emulator.memory[rb] == ip+3 && target != ip+3 {
+		calls.Frames = append(calls.Frames, CallFrame{Entry: target, Call: ip, Return: ip + 3, RelativeBase: rb})
+		if step >= 0 {
+			calls.events = append(calls.events, callEvent{Step: step})
+		}
+		if calls.trace != nil {
+			fmt.Fprintf(calls.trace, "%s%s called from %d\n", strings.Repeat("  ", len(calls.Frames)-1), functionName(target), ip)
+		}
+		return
+	}
+
+	for i := len(calls.Frames) - 1; i >= 0; i-- {
+		frame := calls.Frames[i]
+		if frame.Return != target || frame.RelativeBase != rb {
+			continue
+		}
+		popped := append([]CallFrame(nil), calls.Frames[i:]...)
+		calls.Frames = calls.Frames[:i]
+		if step >= 0 {
+			calls.events = append(calls.events, callEvent{Step: step, Popped: popped})
+		}
+		if calls.trace != nil {
+			fmt.Fprintf(calls.trace, "%s%s returned to %d\n", strings.Repeat("  ", i), functionName(frame.Entry), target)
+		}
+		return
+	}
+}
+
+// rewind undoes the calls and returns of the steps from step on.
+func (calls *CallStack) rewind(step int) {
+	for len(calls.events) > 0 && calls.events[len(calls.events)-1].Step >= step {
+		event := calls.events[len(calls.events)-1]
+		calls.events = calls.events[:len(calls.events)-1]
+		if event.Popped == nil {
+			calls.Frames = calls.Frames[:len(calls.Frames)-1]
+		} else {
+			calls.Frames = append(calls.Frames, event.Popped...)
+		}
+	}
+}
+
+// functionName names the function at entry as the disassembler does.
+func functionName(entry int64) string {
+	if entry == 0 {
+		return "main"
+	}
+	return fmt.Sprintf("f%d", entry)
+}
+
+// A CallLocation is where a frame of the stack is: the function, the address
+// it is at and its relative base.
+type CallLocation struct {
+	Entry, IP, RelativeBase int64
+}
+
+// locations returns the location of every frame on the stack, innermost
+// first, given the registers of the emulator.
+func (calls *CallStack) locations(ip, rb int64) []CallLocation {
+	var locations []CallLocation
+	for i := len(calls.Frames); i >= 0; i-- {
+		location := CallLocation{IP: ip, RelativeBase: rb}
+		if i > 0 {
+			location.Entry = calls.Frames[i-1].Entry
+		}
+		if i < len(calls.Frames) {
+			location.IP, location.RelativeBase = calls.Frames[i].Call, calls.Frames[i].RelativeBase
+		}
+		locations = append(locations, location)
+	}
+	return locations
+}
+
+// backtrace lists the locations of the frames on the stack.
+func (calls *CallStack) backtrace(ip, rb int64) string {
+	var builder strings.Builder
+	for i, location := range calls.locations(ip, rb) {
+		fmt.Fprintf(&builder, "#%-3d %-10s ip=%d rb=%d\n", i, functionName(location.Entry), location.IP, location.RelativeBase)
+	}
+	return builder.String()
+}
@@ -0,0 +1,977 @@
+package main
+
+import (
+	"fmt"
+)
+
+// The compiler targets the calling convention of the Intcode programs from
+// the puzzles, so that their functions look alike in disassembly:
+//
+//   - the caller writes the return address to [rb+0] and the arguments to
+//     [rb+1], [rb+2], ..., then jumps to the function,
+//   - the function reserves its frame with ARB n, so that the return address
+//     is at [rb-n] and the arguments follow it, and keeps its locals and
+//     temporaries in the rest of the frame,
+//   - the function stores its result in the first argument slot, releases its
+//     frame with ARB -n and returns with JNZ 1, [rb+0].
+//
+// Intcode has no indirect addressing, so array accesses with a computed
+// address patch the address into the next instruction. Taking the address of
+// a local array needs the value of the relative base, which is mirrored in
+// the global __fp if the program has local arrays.
+
+// Internal operand modes, only used while a function is compiled. They refer
+// to frame slots, which become relative offsets once the frame size is known.
+const (
+	modeFrame       = 10 // relative operand for a frame slot
+	modeFrameOffset = 11 // immediate offset of a frame slot from the relative base
+	modeFrameSize   = 12 // immediate frame size, multiplied by Value
+)
+
+const prelude = `
+func __div(a, b) {
+	var negative = 0;
+	if (a < 0) {
+		a = -a;
+		negative = !negative;
+	}
+	if (b < 0) {
+		b = -b;
+		negative = !negative;
+	}
+	if (b == 0) {
+		return 0;
+	}
+	var q = __udiv(a, b);
+	if (negative) {
+		return -q;
+	}
+	return q;
+}
+
+// __udiv divides two non-negative numbers by halving the quotient of a and 2b.
+func __udiv(a, b) {
+	if (a < b) {
+		return 0;
+	}
+	if (a - b < b) {
+		return 1;
+	}
+	var q = __udiv(a, b + b) * 2;
+	if (a - q * b >= b) {
+		q = q + 1;
+	}
+	return q;
+}
+
+func __mod(a, b) {
+	return a - __div(a, b) * b;
+}
+
+func __printnum(n) {
+	if (n < 0) {
+		output('-');
+		n = -n;
+	}
+	if (n >= 10) {
+		__printnum(n / 10);
+	}
+	output('0' + n % 10);
+}
+`
+
+type SymbolKind int
+
+const (
+	SymbolGlobal SymbolKind = iota
+	SymbolGlobalArray
+	SymbolLocal
+	SymbolLocalArray
+)
+
+type symbol struct {
+	Kind  SymbolKind
+	Label string // globals
+	Slot  int64  // locals
+}
+
+type loop struct {
+	Break, Continue string
+}
+
+type compiler struct {
+	code, data, bss []Line
+
+	functions map[string]*Function
+	globals   map[string]*symbol
+	strings   map[string]string
+	compiled  map[string]bool
+	queue     []*Function
+	labels    int
+
+	usesFramePointer bool
+
+	// If object is set, the source is compiled to an object module for the
+	// linker: see compileObject.
+	object  bool
+	prelude map[string]bool
+
+	// State of the function being compiled.
+	function *Function
+	lines    []Line
+	scopes   []map[string]*symbol
+	top      int64 // first free frame slot
+	frame    int64 // frame size
+	loops    []loop
+	epilogue string
+}
+
+// compile compiles a source file to assembly.
+func compile(filename, text string) (lines []Line, err error) {
+	return compileModule(filename, text, false)
+}
+
+// compileObject compiles a source file to the assembly of an object module
+// (see object.go), which is linked with others into a program. Unlike a
+// program, it needs no main function, and its functions may call functions
+// that are only declared. All its functions are compiled, and exported with
+// its globals; the functions of the prelude it uses are local to it. The
+// module with main also contains the start-up code, labeled __start, and
+// __fp, which every module keeps up to date; the linker defines __stack.
+func compileObject(filename, text string) (lines []Line, err error) {
+	return compileModule(filename, text, true)
+}
+
+func compileModule(filename, text string, object bool) (lines []Line, err error) {
+	source, err := parse(filename, text)
+	if err != nil {
+		return nil, err
+	}
+
+	runtime, err := parse("<prelude>", prelude)
+	check(err)
+	source.Functions = append(source.Functions, runtime.Functions...)
+
+	defer func() {
+		if r := recover(); r != nil {
+			compileError, ok := r.(*CompileError)
+			if !ok {
+				panic(r)
+			}
+			err = compileError
+		}
+	}()
+
+	c := &compiler{
+		functions: make(map[string]*Function),
+		globals:   make(map[string]*symbol),
+		strings:   make(map[string]string),
+		compiled:  make(map[string]bool),
+		object:    object,
+		prelude:   make(map[string]bool),
+	}
+	for _, function := range runtime.Functions {
+		c.prelude[function.Name] = true
+	}
+	return c.compileSource(filename, source), nil
+}
+
+func (c *compiler) newLabel() string {
+	c.labels++
+	return fmt.Sprintf(".L%d", c.labels)
+}
+
+func (c *compiler) emit(opcode int64, operands ...Operand) {
+	c.lines = append(c.lines, Instruction(opcode, operands...))
+}
+
+func (c *compiler) label(name string) {
+	c.lines = append(c.lines, Label(name))
+}
+
+// functionLabel returns the label of a function. In object modules, the
+// functions of the prelude are local, so that every module can have them.
+func (c *compiler) functionLabel(name string) string {
+	if c.object && c.prelude[name] {
+		return "." + name
+	}
+	return name
+}
+
+func (c *compiler) compileSource(filename string, source *Source) []Line {
+	for _, function := range source.Functions {
+		// A function may be declared as well as defined.
+		if previous := c.functions[function.Name]; previous != nil && (previous.Body == nil || function.Body == nil) && len(previous.Params) == len(function.Params) {
+			if function.Body != nil {
+				c.functions[function.Name] = function
+			}
+			continue
+		}
+		if builtins[function.Name] || c.functions[function.Name] != nil {
+			errorf(function.At, "function %s redeclared", function.Name)
+		}
+		c.functions[function.Name] = function
+	}
+
+	for _, global := range source.Globals {
+		if c.globals[global.Name] != nil || c.functions[global.Name] != nil {
+			errorf(global.At, "%s redeclared", global.Name)
+		}
+
+		if global.Size != 0 {
+			c.globals[global.Name] = &symbol{Kind: SymbolGlobalArray, Label: global.Name}
+			c.bss = append(c.bss, Label(global.Name), Space(global.Size))
+			continue
+		}
+
+		value := Immediate(0)
+		if global.Init != nil {
+			value = c.compileExpr(global.Init, nil)
+			if value.Mode != ModeImmediate {
+				errorf(global.Init.Pos(), "initializer of global %s is not constant", global.Name)
+			}
+		}
+		c.globals[global.Name] = &symbol{Kind: SymbolGlobal, Label: global.Name}
+		c.data = append(c.data, Label(global.Name), Data(value))
+	}
+
+	for _, function := range source.Functions {
+		if function.Body != nil && hasLocalArrays(function.Body) {
+			c.usesFramePointer = true
+		}
+	}
+	// Functions of other modules may have local arrays.
+	if c.object {
+		c.usesFramePointer = true
+	}
+
+	main := c.functions["main"]
+	if main != nil && main.Body == nil {
+		errorf(main.At, "function main is only declared")
+	}
+	if main == nil && !c.object {
+		errorf(Position{Filename: filename, Line: 1, Column: 1}, "function main is undeclared")
+	}
+	if main != nil && len(main.Params) != 0 {
+		errorf(main.At, "function main must have no parameters")
+	}
+
+	// Only functions reachable from main are compiled, or in an object module
+	// all of its own; compiling a call queues the callee.
+	if main != nil {
+		c.queue = append(c.queue, main)
+		c.compiled[main.Name] = true
+	}
+	if c.object {
+		for _, function := range source.Functions {
+			if function.Body != nil && !c.prelude[function.Name] && !c.compiled[function.Name] {
+				c.queue = append(c.queue, function)
+				c.compiled[function.Name] = true
+			}
+		}
+	}
+	for len(c.queue) != 0 {
+		function := c.queue[0]
+		c.queue = c.queue[1:]
+		c.compileFunction(function)
+	}
+
+	if c.object {
+		return c.objectLines(main != nil)
+	}
+
+	var start []Line
+	start = append(start, Instruction(OpAdjustBase, Operand{Mode: ModeImmediate, Symbol: "__stack"}))
+	if c.usesFramePointer {
+		start = append(start, Instruction(OpAdd, Operand{Mode: ModeImmediate, Symbol: "__stack"}, Immediate(0), Operand{Symbol: "__fp"}))
+		c.data = append(c.data, Label("__fp"), Data(Immediate(0)))
+	}
+	start = append(start,
+		Instruction(OpAdd, Operand{Mode: ModeImmediate, Symbol: "__halt"}, Immediate(0), Relative(0)),
+		Instruction(OpJumpIfTrue, Immediate(1), Operand{Mode: ModeImmediate, Symbol: "main"}),
+		Label("__halt"),
+		Instruction(OpHalt),
+	)
+
+	var lines []Line
+	lines = append(lines, start...)
+	lines = append(lines, c.code...)
+	lines = append(lines, c.data...)
+	lines = append(lines, c.bss...)
+	lines = append(lines, Label("__stack"))
+	return lines
+}
+
+// objectLines lays out an object module in sections, with the start-up code
+// if it has main.
+func (c *compiler) objectLines(hasMain bool) []Line {
+	var lines []Line
+	if hasMain {
+		lines = append(lines,
+			Label("__start"),
+			Instruction(OpAdjustBase, Operand{Mode: ModeImmediate, Symbol: "__stack"}),
+			Instruction(OpAdd, Operand{Mode: ModeImmediate, Symbol: "__stack"}, Immediate(0), Operand{Symbol: "__fp"}),
+			Instruction(OpAdd, Operand{Mode: ModeImmediate, Symbol: ".halt"}, Immediate(0), Relative(0)),
+			Instruction(OpJumpIfTrue, Immediate(1), Operand{Mode: ModeImmediate, Symbol: "main"}),
+			Label(".halt"),
+			Instruction(OpHalt),
+		)
+		c.data = append(c.data, Label("__fp"), Data(Immediate(0)))
+	}
+
+	lines = append(lines, c.code...)
+	lines = append(lines, Section(SegmentData))
+	lines = append(lines, c.data...)
+	lines = append(lines, Section(SegmentBSS))
+	lines = append(lines, c.bss...)
+	return lines
+}
+
+func (c *compiler) compileFunction(function *Function) {
+	c.function = function
+	c.lines = nil
+	c.loops = nil
+	c.epilogue = c.newLabel()
+
+	// Slot 0 holds the return address, the parameters follow. Slot 1 also
+	// receives the result, so every frame has at least two slots.
+	scope := make(map[string]*symbol)
+	for i, param := range function.Params {
+		if scope[param] != nil {
+			errorf(function.At, "duplicate parameter %s", param)
+		}
+		scope[param] = &symbol{Kind: SymbolLocal, Slot: int64(i) + 1}
+	}
+	c.scopes = []map[string]*symbol{scope}
+	c.top = int64(len(function.Params)) + 1
+	c.frame = max64(c.top, 2)
+
+	c.label(c.functionLabel(function.Name))
+	c.emit(OpAdjustBase, Operand{Mode: modeFrameSize, Value: 1})
+	if c.usesFramePointer {
+		c.emit(OpAdd, Operand{Symbol: "__fp"}, Operand{Mode: modeFrameSize, Value: 1}, Operand{Symbol: "__fp"})
+	}
+
+	c.compileStmts(function.Body.Stmts)
+
+	// A return at the end of the function does not need to jump.
+	if last := c.lines[len(c.lines)-1]; last.Opcode == OpJumpIfFalse && last.Operands[1].Symbol == c.epilogue {
+		c.lines = c.lines[:len(c.lines)-1]
+	}
+
+	c.label(c.epilogue)
+	c.emit(OpAdjustBase, Operand{Mode: modeFrameSize, Value: -1})
+	if c.usesFramePointer {
+		c.emit(OpAdd, Operand{Symbol: "__fp"}, Operand{Mode: modeFrameSize, Value: -1}, Operand{Symbol: "__fp"})
+	}
+	c.emit(OpJumpIfTrue, Immediate(1), Relative(0))
+
+	// Now that the frame size is known, turn frame slots into offsets.
+	for i := range c.lines {
+		for j, operand := range c.lines[i].Operands {
+			switch operand.Mode {
+			case modeFrame:
+				c.lines[i].Operands[j] = Relative(operand.Value - c.frame)
+			case modeFrameOffset:
+				c.lines[i].Operands[j] = Immediate(operand.Value - c.frame)
+			case modeFrameSize:
+				c.lines[i].Operands[j] = Immediate(operand.Value * c.frame)
+			}
+		}
+	}
+
+	c.code = append(c.code, c.lines...)
+}
+
+func (c *compiler) lookup(name string, pos Position) *symbol {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if s := c.scopes[i][name]; s != nil {
+			return s
+		}
+	}
+	if s := c.globals[name]; s != nil {
+		return s
+	}
+	if c.functions[name] != nil || builtins[name] {
+		errorf(pos, "function %s used as value", name)
+	}
+	errorf(pos, "undeclared name %s", name)
+	return nil
+}
+
+// alloc reserves size frame slots.
+func (c *compiler) alloc(size int64) int64 {
+	slot := c.top
+	c.top += size
+	c.frame = max64(c.frame, c.top)
+	return slot
+}
+
+func (c *compiler) temp() Operand {
+	return Operand{Mode: modeFrame, Value: c.alloc(1)}
+}
+
+func (c *compiler) compileStmts(stmts []Stmt) {
+	c.scopes = append(c.scopes, make(map[string]*symbol))
+	top := c.top
+	for _, stmt := range stmts {
+		c.compileStmt(stmt)
+	}
+	c.top = top
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+func (c *compiler) compileStmt(stmt Stmt) {
+	// Temporaries only live during a statement.
+	top := c.top
+	defer func() {
+		if _, ok := stmt.(*VarStmt); !ok {
+			c.top = top
+		}
+	}()
+
+	switch stmt := stmt.(type) {
+	case *BlockStmt:
+		c.compileStmts(stmt.Stmts)
+
+	case *VarStmt:
+		scope := c.scopes[len(c.scopes)-1]
+		if scope[stmt.Name] != nil {
+			errorf(stmt.At, "%s redeclared in this block", stmt.Name)
+		}
+
+		if stmt.Size != 0 {
+			scope[stmt.Name] = &symbol{Kind: SymbolLocalArray, Slot: c.alloc(stmt.Size)}
+			return
+		}
+
+		slot := c.alloc(1)
+		dest := Operand{Mode: modeFrame, Value: slot}
+		if stmt.Init != nil {
+			mark := c.top
+			c.move(c.compileExpr(stmt.Init, &dest), dest)
+			c.top = mark
+		} else {
+			c.move(Immediate(0), dest)
+		}
+		scope[stmt.Name] = &symbol{Kind: SymbolLocal, Slot: slot}
+
+	case *AssignStmt:
+		c.compileAssign(stmt)
+
+	case *ExprStmt:
+		if _, ok := stmt.X.(*CallExpr); !ok {
+			errorf(stmt.At, "expression is not used")
+		}
+		c.compileExpr(stmt.X, discard)
+
+	case *IfStmt:
+		elseLabel, endLabel := c.newLabel(), c.newLabel()
+		c.compileCondition(stmt.Cond, elseLabel)
+		c.top = top
+		c.compileStmt(stmt.Then)
+		if stmt.Else != nil {
+			c.jump(endLabel)
+		}
+		c.label(elseLabel)
+		if stmt.Else != nil {
+			c.compileStmt(stmt.Else)
+		}
+		c.label(endLabel)
+
+	case *WhileStmt:
+		topLabel, endLabel := c.newLabel(), c.newLabel()
+		c.label(topLabel)
+		c.compileCondition(stmt.Cond, endLabel)
+		c.top = top
+		c.loops = append(c.loops, loop{Break: endLabel, Continue: topLabel})
+		c.compileStmt(stmt.Body)
+		c.loops = c.loops[:len(c.loops)-1]
+		c.jump(topLabel)
+		c.label(endLabel)
+
+	case *ReturnStmt:
+		if stmt.Value != nil {
+			result := Operand{Mode: modeFrame, Value: 1}
+			c.move(c.compileExpr(stmt.Value, &result), result)
+		}
+		c.jump(c.epilogue)
+
+	case *BreakStmt:
+		if len(c.loops) == 0 {
+			errorf(stmt.At, "break is not in a loop")
+		}
+		c.jump(c.loops[len(c.loops)-1].Break)
+
+	case *ContinueStmt:
+		if len(c.loops) == 0 {
+			errorf(stmt.At, "continue is not in a loop")
+		}
+		c.jump(c.loops[len(c.loops)-1].Continue)
+
+	default:
+		panic(fmt.Sprintf("unexpected statement %T", stmt))
+	}
+}
+
+func (c *compiler) jump(label string) {
+	c.emit(OpJumpIfFalse, Immediate(0), Operand{Mode: ModeImmediate, Symbol: label})
+}
+
+// move copies value to dest, unless it is already there.
+func (c *compiler) move(value, dest Operand) {
+	if value != dest {
+		c.emit(OpAdd, value, Immediate(0), dest)
+	}
+}
+
+var assignOperators = map[string]string{"+=": "+", "-=": "-", "*=": "*", "/=": "/", "%=": "%"}
+
+func (c *compiler) compileAssign(stmt *AssignStmt) {
+	switch target := stmt.Target.(type) {
+	case *NameExpr:
+		dest := c.compileName(target, true)
+		value := stmt.Value
+		if op, ok := assignOperators[stmt.Op]; ok {
+			value = &BinaryExpr{At: stmt.At, Op: op, X: target, Y: stmt.Value}
+		}
+		c.move(c.compileExpr(value, &dest), dest)
+
+	case *IndexExpr:
+		// The address is computed once, also for compound assignments.
+		direct, address := c.compileAddress(target)
+		var current Operand
+		if _, ok := assignOperators[stmt.Op]; ok {
+			if address == nil {
+				current = direct
+			} else {
+				current = c.load(*address, nil)
+			}
+		}
+
+		value := c.compileExpr(stmt.Value, nil)
+		if op, ok := assignOperators[stmt.Op]; ok {
+			value = c.compileOperator(op, current, value, nil, stmt.At)
+		}
+
+		if address == nil {
+			c.move(value, direct)
+		} else {
+			c.store(*address, value)
+		}
+	}
+}
+
+// compileAddress compiles the address of an array element. If the address is
+// known at compile time, it returns the element as an operand. Otherwise it
+// returns an operand holding the address.
+func (c *compiler) compileAddress(e *IndexExpr) (Operand, *Operand) {
+	index := c.compileExpr(e.Index, nil)
+
+	if name, ok := e.Array.(*NameExpr); ok && isConstant(index) {
+		switch s := c.lookup(name.Name, name.At); s.Kind {
+		case SymbolGlobalArray:
+			return Operand{Mode: ModePosition, Symbol: s.Label, Value: index.Value}, nil
+		case SymbolLocalArray:
+			return Operand{Mode: modeFrame, Value: s.Slot + index.Value}, nil
+		}
+	}
+
+	base := c.compileExpr(e.Array, nil)
+	address := c.compileOperator("+", base, index, nil, e.At)
+	return Operand{}, &address
+}
+
+// load reads the word at a computed address by patching the first operand of
+// the following instruction.
+func (c *compiler) load(address Operand, dest *Operand) Operand {
+	result := c.destination(dest)
+	patch := c.newLabel()
+	c.emit(OpAdd, address, Immediate(0), Operand{Symbol: patch, Value: 1})
+	c.label(patch)
+	c.emit(OpAdd, Absolute(0), Immediate(0), result)
+	return result
+}
+
+// store writes value to a computed address by patching the last operand of
+// the following instruction.
+func (c *compiler) store(address, value Operand) {
+	patch := c.newLabel()
+	c.emit(OpAdd, address, Immediate(0), Operand{Symbol: patch, Value: 3})
+	c.label(patch)
+	c.emit(OpAdd, value, Immediate(0), Absolute(0))
+}
+
+// destination returns dest if it is given, otherwise a new temporary.
+func (c *compiler) destination(dest *Operand) Operand {
+	if dest != nil {
+		return *dest
+	}
+	return c.temp()
+}
+
+func isConstant(o Operand) bool {
+	return o.Mode == ModeImmediate && o.Symbol == ""
+}
+
+// compileName returns the operand of a variable. Arrays evaluate to their
+// address; they cannot be assigned to.
+func (c *compiler) compileName(e *NameExpr, assign bool) Operand {
+	s := c.lookup(e.Name, e.At)
+	if assign && (s.Kind == SymbolGlobalArray || s.Kind == SymbolLocalArray) {
+		errorf(e.At, "cannot assign to array %s", e.Name)
+	}
+
+	switch s.Kind {
+	case SymbolGlobal:
+		return Operand{Mode: ModePosition, Symbol: s.Label}
+	case SymbolGlobalArray:
+		return Operand{Mode: ModeImmediate, Symbol: s.Label}
+	case SymbolLocal:
+		return Operand{Mode: modeFrame, Value: s.Slot}
+	default:
+		address := c.temp()
+		c.emit(OpAdd, Operand{Symbol: "__fp"}, Operand{Mode: modeFrameOffset, Value: s.Slot}, address)
+		return address
+	}
+}
+
+// compileExpr compiles an expression and returns the operand holding its
+// value. If dest is given, the last instruction may write the value to it
+// instead of a temporary; the caller must check the returned operand.
+func (c *compiler) compileExpr(e Expr, dest *Operand) Operand {
+	switch e := e.(type) {
+	case *NumberExpr:
+		return Immediate(e.Value)
+
+	case *StringExpr:
+		return Operand{Mode: ModeImmediate, Symbol: c.stringLabel(e.Value)}
+
+	case *NameExpr:
+		return c.compileName(e, false)
+
+	case *IndexExpr:
+		direct, address := c.compileAddress(e)
+		if address == nil {
+			return direct
+		}
+		return c.load(*address, dest)
+
+	case *UnaryExpr:
+		x := c.compileExpr(e.X, nil)
+		if e.Op == "-" {
+			return c.compileOperator("*", x, Immediate(-1), dest, e.At)
+		}
+		return c.compileOperator("==", x, Immediate(0), dest, e.At)
+
+	case *BinaryExpr:
+		if e.Op == "&&" || e.Op == "||" {
+			return c.compileLogical(e)
+		}
+		x := c.compileExpr(e.X, nil)
+		y := c.compileExpr(e.Y, nil)
+		return c.compileOperator(e.Op, x, y, dest, e.At)
+
+	case *CallExpr:
+		return c.compileCall(e, dest)
+
+	default:
+		panic(fmt.Sprintf("unexpected expression %T", e))
+	}
+}
+
+func (c *compiler) stringLabel(s string) string {
+	if label, ok := c.strings[s]; ok {
+		return label
+	}
+
+	label := c.newLabel()
+	var words []Operand
+	for _, r := range s {
+		words = append(words, Immediate(int64(r)))
+	}
+	words = append(words, Immediate(0))
+	c.data = append(c.data, Label(label), Data(words...))
+	c.strings[s] = label
+	return label
+}
+
+// compileLogical compiles && and || with short-circuit evaluation.
+func (c *compiler) compileLogical(e *BinaryExpr) Operand {
+	result := c.temp()
+	end := c.newLabel()
+
+	var shortCircuit, other int64 = 0, 1
+	jump := int64(OpJumpIfFalse)
+	if e.Op == "||" {
+		shortCircuit, other = 1, 0
+		jump = OpJumpIfTrue
+	}
+
+	c.move(Immediate(shortCircuit), result)
+	x := c.compileExpr(e.X, nil)
+	c.emit(jump, x, Operand{Mode: ModeImmediate, Symbol: end})
+	y := c.compileExpr(e.Y, nil)
+	c.emit(jump, y, Operand{Mode: ModeImmediate, Symbol: end})
+	c.move(Immediate(other), result)
+	c.label(end)
+	return result
+}
+
+func (c *compiler) compileOperator(op string, x, y Operand, dest *Operand, pos Position) Operand {
+	if isConstant(x) && isConstant(y) {
+		if value, ok := fold(op, x.Value, y.Value); ok {
+			return Immediate(value)
+		}
+	}
+
+	switch op {
+	case "+":
+		result := c.destination(dest)
+		c.emit(OpAdd, x, y, result)
+		return result
+
+	case "-":
+		if isConstant(y) {
+			result := c.destination(dest)
+			c.emit(OpAdd, x, Immediate(-y.Value), result)
+			return result
+		}
+		negated := c.temp()
+		c.emit(OpMultiply, y, Immediate(-1), negated)
+		result := c.destination(dest)
+		c.emit(OpAdd, x, negated, result)
+		return result
+
+	case "*":
+		result := c.destination(dest)
+		c.emit(OpMultiply, x, y, result)
+		return result
+
+	case "/", "%":
+		name := "__div"
+		if op == "%" {
+			name = "__mod"
+		}
+		return c.call(name, []Operand{x, y}, dest)
+
+	case "<", ">":
+		if op == ">" {
+			x, y = y, x
+		}
+		result := c.destination(dest)
+		c.emit(OpLessThan, x, y, result)
+		return result
+
+	case "<=", ">=", "!=":
+		// Negations of >, < and ==.
+		negated := c.temp()
+		switch op {
+		case "<=":
+			c.emit(OpLessThan, y, x, negated)
+		case ">=":
+			c.emit(OpLessThan, x, y, negated)
+		default:
+			c.emit(OpEqual, x, y, negated)
+		}
+		result := c.destination(dest)
+		c.emit(OpEqual, negated, Immediate(0), result)
+		return result
+
+	case "==":
+		result := c.destination(dest)
+		c.emit(OpEqual, x, y, result)
+		return result
+
+	default:
+		errorf(pos, "unknown operator %s", op)
+		return Operand{}
+	}
+}
+
+func fold(op string, x, y int64) (int64, bool) {
+	boolean := func(b bool) int64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	switch op {
+	case "+":
+		return x + y, true
+	case "-":
+		return x - y, true
+	case "*":
+		return x * y, true
+	case "/", "%":
+		// Division by zero is left to __div, which makes x / 0 zero, and
+		// so x % 0 x.
+		if y == 0 {
+			return 0, false
+		}
+		if op == "/" {
+			return x / y, true
+		}
+		return x % y, true
+	case "<":
+		return boolean(x < y), true
+	case ">":
+		return boolean(x > y), true
+	case "<=":
+		return boolean(x <= y), true
+	case ">=":
+		return boolean(x >= y), true
+	case "==":
+		return boolean(x == y), true
+	case "!=":
+		return boolean(x != y), true
+	}
+	return 0, false
+}
+
+// compileCondition jumps to falseLabel if cond is zero.
+func (c *compiler) compileCondition(cond Expr, falseLabel string) {
+	jump := int64(OpJumpIfFalse)
+
+	// Conditions that compile to a negation are tested with the opposite jump.
+	if e, ok := cond.(*UnaryExpr); ok && e.Op == "!" {
+		cond, jump = e.X, OpJumpIfTrue
+	} else if e, ok := cond.(*BinaryExpr); ok && (e.Op == "!=" || e.Op == "<=" || e.Op == ">=") {
+		x, y := c.compileExpr(e.X, nil), c.compileExpr(e.Y, nil)
+		if !isConstant(x) || !isConstant(y) {
+			value := map[string]string{"!=": "==", "<=": ">", ">=": "<"}[e.Op]
+			c.emit(OpJumpIfTrue, c.compileOperator(value, x, y, nil, e.At), Operand{Mode: ModeImmediate, Symbol: falseLabel})
+			return
+		}
+		cond = &NumberExpr{At: e.At, Value: c.compileOperator(e.Op, x, y, nil, e.At).Value}
+	}
+
+	value := c.compileExpr(cond, nil)
+	if isConstant(value) {
+		if (value.Value == 0) == (jump == OpJumpIfFalse) {
+			c.jump(falseLabel)
+		}
+		return
+	}
+	c.emit(jump, value, Operand{Mode: ModeImmediate, Symbol: falseLabel})
+}
+
+var builtins = map[string]bool{"input": true, "output": true, "print": true}
+
+func (c *compiler) compileCall(e *CallExpr, dest *Operand) Operand {
+	switch e.Name {
+	case "input":
+		if len(e.Args) != 0 {
+			errorf(e.At, "input takes no arguments")
+		}
+		if dest == discard {
+			dest = nil
+		}
+		result := c.destination(dest)
+		c.emit(OpInput, result)
+		return result
+
+	case "output":
+		if len(e.Args) == 0 {
+			errorf(e.At, "output takes at least one argument")
+		}
+		for _, arg := range e.Args {
+			c.emit(OpOutput, c.compileExpr(arg, nil))
+		}
+		return Immediate(0)
+
+	case "print":
+		if len(e.Args) == 0 {
+			errorf(e.At, "print takes at least one argument")
+		}
+		for _, arg := range e.Args {
+			if s, ok := arg.(*StringExpr); ok {
+				for _, r := range s.Value {
+					c.emit(OpOutput, Immediate(int64(r)))
+				}
+				continue
+			}
+			c.call("__printnum", []Operand{c.compileExpr(arg, nil)}, nil)
+		}
+		return Immediate(0)
+	}
+
+	function := c.functions[e.Name]
+	if function == nil {
+		errorf(e.At, "undeclared function %s", e.Name)
+	}
+	if len(e.Args) != len(function.Params) {
+		errorf(e.At, "function %s takes %d arguments, but %d were given", e.Name, len(function.Params), len(e.Args))
+	}
+	if function.Body == nil && !c.object {
+		errorf(e.At, "function %s is only declared; compile with -c and link it with its definition", e.Name)
+	}
+
+	// All arguments are evaluated before any is written to the outgoing slots,
+	// since evaluating an argument may call another function.
+	var args []Operand
+	for _, arg := range e.Args {
+		value := c.compileExpr(arg, nil)
+		if value.Mode == ModePosition {
+			// Globals may change during the calls of later arguments.
+			copied := c.temp()
+			c.move(value, copied)
+			value = copied
+		}
+		args = append(args, value)
+	}
+	return c.call(e.Name, args, dest)
+}
+
+// discard is passed as destination of calls whose result is not used.
+var discard = &Operand{Mode: -1}
+
+// call calls a function with already evaluated arguments.
+func (c *compiler) call(name string, args []Operand, dest *Operand) Operand {
+	if !c.compiled[name] && c.functions[name].Body != nil {
+		c.compiled[name] = true
+		c.queue = append(c.queue, c.functions[name])
+	}
+
+	for i, arg := range args {
+		c.move(arg, Relative(int64(i)+1))
+	}
+
+	ret := c.newLabel()
+	c.emit(OpAdd, Operand{Mode: ModeImmediate, Symbol: ret}, Immediate(0), Relative(0))
+	c.emit(OpJumpIfTrue, Immediate(1), Operand{Mode: ModeImmediate, Symbol: c.functionLabel(name)})
+	c.label(ret)
+
+	if dest == discard {
+		return Immediate(0)
+	}
+	result := c.destination(dest)
+	c.move(Relative(1), result)
+	return result
+}
+
+func hasLocalArrays(stmt Stmt) bool {
+	switch stmt := stmt.(type) {
+	case *VarStmt:
+		return stmt.Size != 0
+	case *BlockStmt:
+		for _, s := range stmt.Stmts {
+			if hasLocalArrays(s) {
+				return true
+			}
+		}
+	case *IfStmt:
+		return hasLocalArrays(stmt.Then) || (stmt.Else != nil && hasLocalArrays(stmt.Else))
+	case *WhileStmt:
+		return hasLocalArrays(stmt.Body)
+	}
+	return false
+}
+
+func max64(x, y int64) int64 {
+	if y > x {
+		return y
+	}
+	return x
+}
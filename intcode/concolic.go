@@ -0,0 +1,600 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Concolic execution runs a program on concrete input, while also tracking
+// which values depend on the input, as terms over the input values x0, x1,
+// ... Every comparison and conditional jump on such a value adds a
+// constraint to the path the run took. Negating one of the constraints and
+// solving for new input explores the other side of the branch; constraining
+// an output instead finds input producing it. For example, starting just
+// left of the tractor beam of day 19 at y=100,
+//
+//	intcode concolic -input 0,100 -keep 1 -range 0,1000 -output 1 -closest ../day19/input.txt
+//
+// finds the x where the beam starts on that row.
+//
+// The solver is a local search (the alternating variable method) minimizing
+// how far the input is from satisfying each constraint, which is fast on the
+// small integer arithmetic Intcode programs do. Values the program uses as
+// addresses, opcodes or for the relative base are taken concretely, so a
+// solution may take a different path than intended; every input found is
+// run again to check.
+
+// A term is a value computed from the input.
+type term struct {
+	Op          int64 // OpAdd, OpMultiply, OpLessThan, OpEqual, or 0 for an input
+	Input       int   // for inputs
+	Left, Right *term // nil for constants
+	Value       int64 // for constant operands
+
+	// The value of the term is cached during an evaluation.
+	generation int
+	cached     int64
+}
+
+func (t *term) String() string {
+	if t == nil {
+		return "?"
+	}
+	if t.Op == 0 {
+		return "x" + strconv.Itoa(t.Input)
+	}
+	operand := func(side *term) string {
+		if side == nil {
+			return strconv.FormatInt(t.Value, 10)
+		}
+		return side.String()
+	}
+	operator := map[int64]string{OpAdd: "+", OpMultiply: "*", OpLessThan: "<", OpEqual: "=="}[t.Op]
+	return "(" + operand(t.Left) + " " + operator + " " + operand(t.Right) + ")"
+}
+
+// makeTerm combines two operands, either of which may be constant (nil). It
+// returns nil if both are or the result is constant anyway, and simplifies
+// additions of 0 and multiplications by 1.
+func makeTerm(op int64, left *term, leftValue int64, right *term, rightValue int64) *term {
+	switch {
+	case left == nil && right == nil:
+		return nil
+	case left == nil && op != OpLessThan:
+		// Keep the constant on the right, except where order matters.
+		left, leftValue, right, rightValue = right, rightValue, left, leftValue
+	}
+	if right == nil && (op == OpAdd && rightValue == 0 || op == OpMultiply && rightValue == 1) {
+		return left
+	}
+	if right == nil && op == OpMultiply && rightValue == 0 {
+		return nil
+	}
+
+	t := &term{Op: op, Left: left, Right: right}
+	if left == nil {
+		t.Value = leftValue
+	} else if right == nil {
+		t.Value = rightValue
+	}
+	return t
+}
+
+// evaluate computes the term for the input. generation must differ from the
+// previous evaluation.
+func (t *term) evaluate(input []int64, generation int) int64 {
+	if t.generation == generation {
+		return t.cached
+	}
+	var value int64
+	if t.Op == 0 {
+		value = input[t.Input]
+	} else {
+		left, right := t.Value, t.Value
+		if t.Left != nil {
+			left = t.Left.evaluate(input, generation)
+		}
+		if t.Right != nil {
+			right = t.Right.evaluate(input, generation)
+		}
+		switch t.Op {
+		case OpAdd:
+			value = left + right
+		case OpMultiply:
+			value = left * right
+		case OpLessThan:
+			if left < right {
+				value = 1
+			}
+		case OpEqual:
+			if left == right {
+				value = 1
+			}
+		}
+	}
+	t.generation, t.cached = generation, value
+	return value
+}
+
+// A pathConstraint requires Term to be nonzero, or zero if Want is false.
+type pathConstraint struct {
+	Address int64 // of the instruction adding the constraint
+	Term    *term
+	Want    bool
+}
+
+func makePathConstraint(address int64, t *term, want bool) pathConstraint {
+	// Comparisons with zero are negations, as in "x == 0" or "(a < b) == 0".
+	for t.Op == OpEqual && t.Right == nil && t.Value == 0 {
+		t, want = t.Left, !want
+	}
+	return pathConstraint{Address: address, Term: t, Want: want}
+}
+
+func (c pathConstraint) String() string {
+	if c.Want {
+		return c.Term.String()
+	}
+	return "!" + c.Term.String()
+}
+
+// distance is how far the input is from satisfying the constraint: 0 if it
+// does, and otherwise larger the more the input would have to change.
+func (c pathConstraint) distance(input []int64, generation int) float64 {
+	t := c.Term
+	side := func(side *term) float64 {
+		if side == nil {
+			return float64(t.Value)
+		}
+		return float64(side.evaluate(input, generation))
+	}
+
+	switch t.Op {
+	case OpLessThan:
+		left, right := side(t.Left), side(t.Right)
+		if c.Want && left >= right {
+			return left - right + 1
+		}
+		if !c.Want && left < right {
+			return right - left
+		}
+		return 0
+
+	case OpEqual:
+		left, right := side(t.Left), side(t.Right)
+		if c.Want {
+			return math.Abs(left - right)
+		}
+		if left == right {
+			return 1
+		}
+		return 0
+
+	default:
+		value := float64(t.evaluate(input, generation))
+		if c.Want && value == 0 {
+			return 1
+		}
+		if !c.Want {
+			return math.Abs(value)
+		}
+		return 0
+	}
+}
+
+// concolicRun is the result of running a program on one input.
+type concolicRun struct {
+	Input       []int64 // including input the program asked for beyond the seed
+	Constraints []pathConstraint
+	Outputs     []int64
+	OutputTerms []*term
+	OutputAfter []int // number of constraints before each output
+	Reached     int   // number of constraints before the target address, or -1
+	Status      string
+}
+
+// path is a key for the branches the run took.
+func (run *concolicRun) path() string {
+	var builder strings.Builder
+	for _, c := range run.Constraints {
+		fmt.Fprintf(&builder, "%d:%v ", c.Address, c.Want)
+	}
+	return builder.String()
+}
+
+// concolicMemoryLimit is the largest memory a run may use, as addresses
+// computed from the inputs the solver picks are easily huge.
+const concolicMemoryLimit = 1 << 20
+
+// concolic runs the program on the input, or zeros if the program asks for
+// more, up to maxInputs values.
+func concolic(program []int64, input []int64, maxInputs int, maxSteps int, target int64) (run *concolicRun) {
+	run = &concolicRun{Input: append([]int64(nil), input...), Reached: -1}
+
+	memory := append([]int64(nil), program...)
+	terms := make(map[int64]*term)
+	recorded := make(map[*term]bool)
+	var ip, relativeBase int64
+	consumed := 0
+
+	defer func() {
+		if r := recover(); r != nil {
+			run.Status = fmt.Sprint(r)
+		}
+	}()
+
+	grow := func(address int64) {
+		if address < 0 {
+			panic(fmt.Sprintf("fault: negative address %d at ip=%d", address, ip))
+		}
+		if address >= concolicMemoryLimit {
+			panic(fmt.Sprintf("fault: address beyond memory limit: ip=%d address=%d", ip, address))
+		}
+		for int64(len(memory)) <= address {
+			memory = append(memory, 0)
+		}
+	}
+	addConstraint := func(t *term, want bool) {
+		if t != nil && !recorded[t] {
+			recorded[t] = true
+			run.Constraints = append(run.Constraints, makePathConstraint(ip, t, want))
+		}
+	}
+
+	for steps := 0; ; steps++ {
+		if ip == target && run.Reached < 0 {
+			run.Reached = len(run.Constraints)
+		}
+		if steps == maxSteps {
+			run.Status = fmt.Sprintf("stopped after %d steps", steps)
+			return run
+		}
+
+		grow(ip)
+		line, ok := decode(memory, ip)
+		if !ok {
+			panic(fmt.Sprintf("fault: invalid instruction: ip=%d instruction=%d", ip, memory[ip]))
+		}
+
+		// Operands are read as a value and the term it was computed
+		// from, if any.
+		address := func(i int) int64 {
+			operand := line.Operands[i]
+			if operand.Mode == ModeRelative {
+				return relativeBase + operand.Value
+			}
+			return operand.Value
+		}
+		read := func(i int) (int64, *term) {
+			if line.Operands[i].Mode == ModeImmediate {
+				return line.Operands[i].Value, terms[ip+1+int64(i)]
+			}
+			a := address(i)
+			grow(a)
+			return memory[a], terms[a]
+		}
+		write := func(i int, value int64, t *term) {
+			a := address(i)
+			grow(a)
+			memory[a] = value
+			if t != nil {
+				terms[a] = t
+			} else {
+				delete(terms, a)
+			}
+		}
+
+		next := ip + line.Length()
+		switch line.Opcode {
+		case OpAdd, OpMultiply, OpLessThan, OpEqual:
+			a, aTerm := read(0)
+			b, bTerm := read(1)
+			var value int64
+			switch line.Opcode {
+			case OpAdd:
+				value = a + b
+			case OpMultiply:
+				value = a * b
+			case OpLessThan:
+				if a < b {
+					value = 1
+				}
+			case OpEqual:
+				if a == b {
+					value = 1
+				}
+			}
+			t := makeTerm(line.Opcode, aTerm, a, bTerm, b)
+			if line.Opcode == OpLessThan || line.Opcode == OpEqual {
+				addConstraint(t, value != 0)
+			}
+			write(2, value, t)
+
+		case OpInput:
+			if consumed == len(run.Input) {
+				if consumed == maxInputs {
+					run.Status = "waiting for input"
+					return run
+				}
+				run.Input = append(run.Input, 0)
+			}
+			write(0, run.Input[consumed], &term{Input: consumed})
+			consumed++
+
+		case OpOutput:
+			value, t := read(0)
+			run.Outputs = append(run.Outputs, value)
+			run.OutputTerms = append(run.OutputTerms, t)
+			run.OutputAfter = append(run.OutputAfter, len(run.Constraints))
+
+		case OpJumpIfTrue, OpJumpIfFalse:
+			condition, t := read(0)
+			destination, _ := read(1)
+			if (condition != 0) == (line.Opcode == OpJumpIfTrue) {
+				next = destination
+			}
+			addConstraint(t, condition != 0)
+
+		case OpAdjustBase:
+			value, _ := read(0)
+			relativeBase += value
+
+		case OpHalt:
+			run.Status = "halted"
+			return run
+		}
+		ip = next
+	}
+}
+
+// concolicSolver searches for input satisfying path constraints.
+type concolicSolver struct {
+	lo, hi     int64
+	keep       map[int]bool // inputs not to change
+	random     *rand.Rand
+	generation int
+}
+
+// solve returns input satisfying the constraints, as close to the seed as it
+// could find.
+func (solver *concolicSolver) solve(constraints []pathConstraint, seed []int64) ([]int64, bool) {
+	fitness := func(input []int64) float64 {
+		solver.generation++
+		var sum float64
+		for _, c := range constraints {
+			sum += c.distance(input, solver.generation)
+		}
+		return sum
+	}
+
+	input := append([]int64(nil), seed...)
+	for i := range input {
+		input[i] = solver.clamp(input[i])
+	}
+	best := fitness(input)
+
+	// Alternating variable method: move each input in turn, by steps
+	// doubling for as long as that improves the fitness, until no move
+	// does. Local optima are escaped by restarting from random input.
+	for restart := 0; best > 0 && restart < 20; restart++ {
+		if restart > 0 {
+			for i := range input {
+				if !solver.keep[i] {
+					input[i] = solver.randomValue()
+				}
+			}
+			best = fitness(input)
+		}
+
+		for improved := true; improved && best > 0; {
+			improved = false
+			for i := range input {
+				if solver.keep[i] {
+					continue
+				}
+				for _, direction := range []int64{-1, 1} {
+					for step := direction; ; step *= 2 {
+						original := input[i]
+						input[i] = solver.clamp(original + step)
+						if input[i] == original {
+							break
+						}
+						value := fitness(input)
+						if value >= best {
+							input[i] = original
+							break
+						}
+						best, improved = value, true
+					}
+				}
+			}
+		}
+	}
+	if best > 0 {
+		return nil, false
+	}
+
+	// The search may overshoot; move each input back towards the seed for
+	// as long as the constraints still hold, to land on the boundary.
+	for i := range input {
+		good, bad := input[i], solver.clamp(seed[i])
+		input[i] = bad
+		if solver.keep[i] || fitness(input) == 0 {
+			continue
+		}
+		for good-bad > 1 || bad-good > 1 {
+			middle := good + (bad-good)/2
+			input[i] = middle
+			if fitness(input) == 0 {
+				good = middle
+			} else {
+				bad = middle
+			}
+		}
+		input[i] = good
+	}
+	return input, true
+}
+
+// randomValue returns a value in the range. The width of the range is
+// counted in uint64, as it does not fit in an int64 for extreme ranges.
+func (solver *concolicSolver) randomValue() int64 {
+	width := uint64(solver.hi-solver.lo) + 1
+	if width == 0 {
+		return int64(solver.random.Uint64())
+	}
+	return solver.lo + int64(solver.random.Uint64()%width)
+}
+
+func (solver *concolicSolver) clamp(value int64) int64 {
+	if value < solver.lo {
+		return solver.lo
+	}
+	if value > solver.hi {
+		return solver.hi
+	}
+	return value
+}
+
+func concolicCommand(args []string) {
+	flags := flag.NewFlagSet("concolic", flag.ExitOnError)
+	inputFlag := flags.String("input", "", "comma-separated input to start from (default: zeros)")
+	outputFlag := flags.String("output", "", "find input making the program output this value")
+	reachFlag := flags.Int64("reach", -1, "find input making the program execute this address")
+	keepFlag := flags.String("keep", "", "comma-separated indices of inputs not to change")
+	rangeFlag := flags.String("range", "-1000000,1000000", "smallest and largest input `values` to try")
+	inputsFlag := flags.Int("inputs", 16, "maximum number of input values")
+	runsFlag := flags.Int("runs", 1000, "maximum number of runs")
+	stepsFlag := flags.Int("steps", 1000000, "maximum number of instructions per run")
+	closestFlag := flags.Bool("closest", false, "keep searching for the input closest to the one started from")
+	verboseFlag := flags.Bool("v", false, "print the constraints of every path")
+	patches := &patchFlags{}
+	patches.register(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode concolic [-input values] [-output value | -reach address] [-closest] [-keep indices] [-range lo,hi] [-inputs n] [-runs n] [-steps n] [-v] [-poke address=value] [-patch file[:name]] program")
+		os.Exit(2)
+	}
+	program := loadPatchedProgram(flags.Arg(0), patches)
+
+	bounds := parseProgram(*rangeFlag)
+	if len(bounds) != 2 || bounds[0] > bounds[1] {
+		fmt.Fprintln(os.Stderr, "intcode: -range expects lo,hi")
+		os.Exit(2)
+	}
+	solver := &concolicSolver{lo: bounds[0], hi: bounds[1], keep: make(map[int]bool), random: rand.New(rand.NewSource(1))}
+	for _, index := range parseProgram(*keepFlag) {
+		solver.keep[int(index)] = true
+	}
+
+	var seed []int64
+	if *inputFlag != "" {
+		seed = parseProgram(*inputFlag)
+	}
+	var want int64
+	wantOutput := *outputFlag != ""
+	if wantOutput {
+		want = toInt64(*outputFlag)
+	}
+	goal := wantOutput || *reachFlag >= 0
+
+	// Generational search: every run is a starting point for solving the
+	// negation of each constraint it added after the one it was solved for.
+	type candidate struct {
+		input []int64
+		bound int
+	}
+	queue := []candidate{{seed, 0}}
+	paths := make(map[string]bool)
+	tried := make(map[string]bool)
+	runs := 0
+
+	// distance is how far input is from the seed.
+	distance := func(input []int64) (sum float64) {
+		for i, value := range input {
+			if i < len(seed) {
+				sum += math.Abs(float64(value) - float64(seed[i]))
+			}
+		}
+		return sum
+	}
+	var closest *concolicRun
+
+	for len(queue) > 0 && runs < *runsFlag {
+		current := queue[0]
+		queue = queue[1:]
+		key := formatProgram(current.input)
+		if tried[key] {
+			continue
+		}
+		tried[key] = true
+
+		run := concolic(program, current.input, *inputsFlag, *stepsFlag, *reachFlag)
+		runs++
+
+		path := run.path()
+		if !paths[path] {
+			paths[path] = true
+			if !goal || *verboseFlag {
+				fmt.Printf("input %s: output %s, %s, %d constraints\n", formatProgram(run.Input), formatProgram(run.Outputs), run.Status, len(run.Constraints))
+			}
+			if *verboseFlag {
+				for _, c := range run.Constraints {
+					fmt.Printf("  %6d: %v\n", c.Address, c)
+				}
+			}
+		}
+
+		reached := *reachFlag >= 0 && run.Reached >= 0
+		for _, output := range run.Outputs {
+			reached = reached || wantOutput && output == want
+		}
+		if reached {
+			if closest == nil || distance(run.Input) < distance(closest.Input) {
+				closest = run
+			}
+			if !*closestFlag {
+				break
+			}
+		}
+
+		var found, flipped []candidate
+		if wantOutput {
+			for i, t := range run.OutputTerms {
+				if t == nil {
+					continue
+				}
+				constraints := append(append([]pathConstraint(nil), run.Constraints[:run.OutputAfter[i]]...), makePathConstraint(-1, makeTerm(OpEqual, t, 0, nil, want), true))
+				if input, ok := solver.solve(constraints, run.Input); ok {
+					found = append(found, candidate{input, len(run.Constraints)})
+				}
+			}
+		}
+		for i := current.bound; i < len(run.Constraints); i++ {
+			constraints := append([]pathConstraint(nil), run.Constraints[:i+1]...)
+			constraints[i].Want = !constraints[i].Want
+			if input, ok := solver.solve(constraints, run.Input); ok {
+				flipped = append(flipped, candidate{input, i + 1})
+			}
+		}
+
+		// Input solved for the goal is tried first.
+		queue = append(append(found, queue...), flipped...)
+	}
+
+	if closest != nil {
+		fmt.Printf("found input %s: output %s, %s\n", formatProgram(closest.Input), formatProgram(closest.Outputs), closest.Status)
+		return
+	}
+	fmt.Printf("%d runs, %d paths\n", runs, len(paths))
+	if goal {
+		fmt.Fprintln(os.Stderr, "intcode: no input found")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The conformance suite runs programs with known results on every
+// Implementation (see variants.go), to show that the copies of the emulator
+// in the puzzles, or a replacement for them, behave the same.
+
+type conformanceCase struct {
+	Name     string
+	Program  string
+	Input    []int64
+	Output   []int64 // expected output, or nil if not checked
+	Memory   []int64 // expected start of memory at the end, or nil if not checked
+	Relative bool    // uses relative mode
+	Words    int64   // memory needed, if more than the program
+}
+
+var conformanceCases = []conformanceCase{
+	// Day 2: addition, multiplication and self-modification.
+	{Name: "day02 example", Program: "1,9,10,3,2,3,11,0,99,30,40,50", Memory: []int64{3500, 9, 10, 70, 2, 3, 11, 0, 99, 30, 40, 50}},
+	{Name: "day02 add", Program: "1,0,0,0,99", Memory: []int64{2, 0, 0, 0, 99}},
+	{Name: "day02 multiply", Program: "2,3,0,3,99", Memory: []int64{2, 3, 0, 6, 99}},
+	{Name: "day02 multiply past halt", Program: "2,4,4,5,99,0", Memory: []int64{2, 4, 4, 5, 99, 9801}},
+	{Name: "day02 overwrite halt", Program: "1,1,1,4,99,5,6,0,99", Memory: []int64{30, 1, 1, 4, 2, 5, 6, 0, 99}},
+
+	// Day 5: input and output, parameter modes, comparisons and jumps.
+	{Name: "day05 echo", Program: "3,0,4,0,99", Input: []int64{42}, Output: []int64{42}},
+	{Name: "day05 immediate mode", Program: "1002,4,3,4,33", Memory: []int64{1002, 4, 3, 4, 99}},
+	{Name: "day05 negative values", Program: "1101,100,-1,4,0", Memory: []int64{1101, 100, -1, 4, 99}},
+	{Name: "day05 equal, position", Program: "3,9,8,9,10,9,4,9,99,-1,8", Input: []int64{8}, Output: []int64{1}},
+	{Name: "day05 not equal, position", Program: "3,9,8,9,10,9,4,9,99,-1,8", Input: []int64{7}, Output: []int64{0}},
+	{Name: "day05 less than, position", Program: "3,9,7,9,10,9,4,9,99,-1,8", Input: []int64{5}, Output: []int64{1}},
+	{Name: "day05 not less than, position", Program: "3,9,7,9,10,9,4,9,99,-1,8", Input: []int64{8}, Output: []int64{0}},
+	{Name: "day05 equal, immediate", Program: "3,3,1108,-1,8,3,4,3,99", Input: []int64{8}, Output: []int64{1}},
+	{Name: "day05 not equal, immediate", Program: "3,3,1108,-1,8,3,4,3,99", Input: []int64{9}, Output: []int64{0}},
+	{Name: "day05 less than, immediate", Program: "3,3,1107,-1,8,3,4,3,99", Input: []int64{7}, Output: []int64{1}},
+	{Name: "day05 not less than, immediate", Program: "3,3,1107,-1,8,3,4,3,99", Input: []int64{8}, Output: []int64{0}},
+	{Name: "day05 jump, position, zero", Program: "3,12,6,12,15,1,13,14,13,4,13,99,-1,0,1,9", Input: []int64{0}, Output: []int64{0}},
+	{Name: "day05 jump, position, nonzero", Program: "3,12,6,12,15,1,13,14,13,4,13,99,-1,0,1,9", Input: []int64{5}, Output: []int64{1}},
+	{Name: "day05 jump, immediate, zero", Program: "3,3,1105,-1,9,1101,0,0,12,4,12,99,1", Input: []int64{0}, Output: []int64{0}},
+	{Name: "day05 jump, immediate, nonzero", Program: "3,3,1105,-1,9,1101,0,0,12,4,12,99,1", Input: []int64{3}, Output: []int64{1}},
+	{Name: "day05 compare to 8, below", Program: day05Compare, Input: []int64{7}, Output: []int64{999}},
+	{Name: "day05 compare to 8, equal", Program: day05Compare, Input: []int64{8}, Output: []int64{1000}},
+	{Name: "day05 compare to 8, above", Program: day05Compare, Input: []int64{9}, Output: []int64{1001}},
+
+	// Day 9: relative mode, memory beyond the program and large numbers.
+	{Name: "day09 quine", Program: day09Quine, Output: parseProgram(day09Quine), Relative: true, Words: 102},
+	{Name: "day09 16-digit number", Program: "1102,34915192,34915192,7,4,7,99,0", Output: []int64{1219070632396864}},
+	{Name: "day09 large number", Program: "104,1125899906842624,99", Output: []int64{1125899906842624}},
+
+	// Our own: the corners the puzzles do not test directly.
+	{Name: "relative base, negative offset", Program: "109,6,109,-2,204,3,99,42", Output: []int64{42}, Relative: true},
+	{Name: "relative write", Program: "109,10,21101,3,4,0,204,0,99", Output: []int64{7}, Relative: true, Words: 11},
+	{Name: "relative input", Program: "109,100,203,0,204,0,99", Input: []int64{17}, Output: []int64{17}, Relative: true, Words: 101},
+	{Name: "write past the program", Program: "1101,1,2,1000,4,1000,99", Output: []int64{3}, Words: 1001},
+	{Name: "read past the program", Program: "4,1000,99", Output: []int64{0}, Words: 1001},
+	{Name: "negative numbers", Program: "1101,-5,3,7,4,7,99,0", Output: []int64{-2}},
+	{Name: "multiplication overflows", Program: "1102,9223372036854775807,2,7,4,7,99,0", Output: []int64{-2}},
+	{Name: "jump over output", Program: "1105,1,7,104,0,99,0,104,1,99", Output: []int64{1}},
+	{Name: "jump to computed address", Program: "1101,5,6,20,106,0,20,104,1,99,0,104,2,99,0,0,0,0,0,0,0", Output: []int64{2}},
+	{Name: "several inputs and outputs", Program: "3,11,3,12,1,11,12,13,4,13,99,0,0,0", Input: []int64{20, 22}, Output: []int64{42}},
+	{Name: "output immediate and position", Program: "104,-1,4,0,99", Output: []int64{-1, 104}},
+}
+
+const day05Compare = "3,21,1008,21,8,20,1005,20,22,107,8,21,20,1006,20,31,1106,0,36,98,0,0,1002,21,125,20,4,20,1105,1,46,104,999,1105,1,46,1101,1000,1,20,4,20,1105,1,46,98,99"
+
+const day09Quine = "109,1,204,-1,1001,100,1,100,1008,100,16,101,1006,101,0,99"
+
+// check runs the case on the implementation. It returns "ok", "FAIL" with
+// what went wrong, or "-" if the case does not apply.
+func (c conformanceCase) check(implementation Implementation, steps int) (result, problem string) {
+	program := parseProgram(c.Program)
+	words := c.Words
+	if words < int64(len(program)) {
+		words = int64(len(program))
+	}
+	if !implementation.Supports(program, c.Relative, words) {
+		return "-", ""
+	}
+
+	output, memory, err := implementation.Run(program, c.Input, steps)
+	switch {
+	case err != nil:
+		return "FAIL", err.Error()
+	case c.Output != nil && formatProgram(output) != formatProgram(c.Output):
+		return "FAIL", fmt.Sprintf("output %s, expected %s", formatProgram(output), formatProgram(c.Output))
+	case c.Memory == nil:
+		return "ok", ""
+	case memory == nil:
+		// The implementation does not show its memory.
+		if c.Output == nil {
+			return "-", ""
+		}
+		return "ok", ""
+	}
+
+	if len(memory) > len(c.Memory) {
+		memory = memory[:len(c.Memory)]
+	}
+	if formatProgram(memory) != formatProgram(c.Memory) {
+		return "FAIL", fmt.Sprintf("memory %s, expected %s", formatProgram(memory), formatProgram(c.Memory))
+	}
+	return "ok", ""
+}
+
+func conformanceCommand(args []string) {
+	flags := flag.NewFlagSet("conformance", flag.ExitOnError)
+	onlyFlag := flags.String("only", "", "comma-separated names of the implementations to check (default: all)")
+	stepsFlag := flags.Int("steps", 100000, "instructions after which a case fails")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: intcode conformance [-only names] [-steps n]")
+		os.Exit(2)
+	}
+
+	checked := implementations
+	if *onlyFlag != "" {
+		checked = nil
+		for _, name := range strings.Split(*onlyFlag, ",") {
+			var found bool
+			for _, implementation := range implementations {
+				if implementation.Name() == name {
+					checked = append(checked, implementation)
+					found = true
+				}
+			}
+			if !found {
+				fmt.Fprintf(os.Stderr, "intcode: no implementation %q\n", name)
+				os.Exit(2)
+			}
+		}
+	}
+
+	// The matrix has a row per case, and a column per implementation: ok,
+	// FAIL, or - if the case does not apply.
+	width := 0
+	for _, c := range conformanceCases {
+		if len(c.Name) > width {
+			width = len(c.Name)
+		}
+	}
+	header := fmt.Sprintf("%-*s", width, "")
+	for _, implementation := range checked {
+		header += fmt.Sprintf("  %-7s", implementation.Name())
+	}
+	fmt.Println(strings.TrimRight(header, " "))
+
+	var failures []string
+	for _, c := range conformanceCases {
+		row := fmt.Sprintf("%-*s", width, c.Name)
+		for _, implementation := range checked {
+			result, problem := c.check(implementation, *stepsFlag)
+			if result == "FAIL" {
+				failures = append(failures, fmt.Sprintf("%s, %s: %s", c.Name, implementation.Name(), problem))
+			}
+			row += fmt.Sprintf("  %-7s", result)
+		}
+		fmt.Println(strings.TrimRight(row, " "))
+	}
+
+	if len(failures) > 0 {
+		fmt.Println()
+		for _, failure := range failures {
+			fmt.Println(failure)
+		}
+		os.Exit(1)
+	}
+}
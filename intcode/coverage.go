@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Coverage counts how often each instruction was executed, and which way each
+// conditional jump went, over one or more runs of a program. Profiles can be
+// saved and merged, so that coverage adds up over many inputs:
+//
+//	intcode cover -profile day25.cov -ascii ../day25/input.txt walk1.txt
+//	intcode cover -profile day25.cov -ascii -html day25.html ../day25/input.txt walk2.txt
+//
+// Profiles are text; after a header with a checksum of the program and the
+// number of runs, every line is "address count", or "address count taken
+// not-taken" for conditional jumps.
+
+type Coverage struct {
+	Checksum uint32 // of the program the profile is for
+	Runs     int
+	Executed map[int64]int64
+	Branches map[int64]*Branch
+}
+
+// Branch counts the directions a conditional jump went.
+type Branch struct {
+	Taken, NotTaken int64
+}
+
+func makeCoverage(program []int64) *Coverage {
+	return &Coverage{
+		Checksum: programChecksum(program),
+		Executed: make(map[int64]int64),
+		Branches: make(map[int64]*Branch),
+	}
+}
+
+func programChecksum(program []int64) uint32 {
+	return crc32.ChecksumIEEE([]byte(formatProgram(program)))
+}
+
+func (coverage *Coverage) executed(address int64) {
+	coverage.Executed[address]++
+}
+
+func (coverage *Coverage) branch(address int64, taken bool) {
+	branch := coverage.Branches[address]
+	if branch == nil {
+		branch = &Branch{}
+		coverage.Branches[address] = branch
+	}
+	if taken {
+		branch.Taken++
+	} else {
+		branch.NotTaken++
+	}
+}
+
+// Merge adds the counts of another profile of the same program.
+func (coverage *Coverage) Merge(other *Coverage) error {
+	if other.Checksum != coverage.Checksum {
+		return fmt.Errorf("coverage profile is for a different program")
+	}
+	coverage.Runs += other.Runs
+	for address, count := range other.Executed {
+		coverage.Executed[address] += count
+	}
+	for address, branch := range other.Branches {
+		if coverage.Branches[address] == nil {
+			coverage.Branches[address] = &Branch{}
+		}
+		coverage.Branches[address].Taken += branch.Taken
+		coverage.Branches[address].NotTaken += branch.NotTaken
+	}
+	return nil
+}
+
+func (coverage *Coverage) format() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "intcode coverage %08x\n", coverage.Checksum)
+	fmt.Fprintf(&builder, "runs %d\n", coverage.Runs)
+	for _, address := range sortedAddresses(coverage.Executed) {
+		fmt.Fprintf(&builder, "%d %d", address, coverage.Executed[address])
+		if branch := coverage.Branches[address]; branch != nil {
+			fmt.Fprintf(&builder, " %d %d", branch.Taken, branch.NotTaken)
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+func parseCoverage(filename, text string) (*Coverage, error) {
+	coverage := &Coverage{Executed: make(map[int64]int64), Branches: make(map[int64]*Branch)}
+
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("%s: not a coverage profile", filename)
+	}
+	if _, err := fmt.Sscanf(lines[0], "intcode coverage %x", &coverage.Checksum); err != nil {
+		return nil, fmt.Errorf("%s: not a coverage profile", filename)
+	}
+	if _, err := fmt.Sscanf(lines[1], "runs %d", &coverage.Runs); err != nil {
+		return nil, fmt.Errorf("%s:2: expected runs <count>", filename)
+	}
+
+	for i, line := range lines[2:] {
+		var values []int64
+		for _, field := range strings.Fields(line) {
+			value, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid value %q", filename, i+3, field)
+			}
+			values = append(values, value)
+		}
+		switch len(values) {
+		case 2:
+			coverage.Executed[values[0]] = values[1]
+		case 4:
+			coverage.Executed[values[0]] = values[1]
+			coverage.Branches[values[0]] = &Branch{Taken: values[2], NotTaken: values[3]}
+		default:
+			return nil, fmt.Errorf("%s:%d: expected address count [taken not-taken]", filename, i+3)
+		}
+	}
+	return coverage, nil
+}
+
+// coverageLine is a line of the annotated disassembly.
+type coverageLine struct {
+	listingLine
+	Count  int64
+	Branch string // e.g. "taken 3 of 5", for conditional jumps
+	Class  string // "data", "missed", "partial" or "covered"
+}
+
+// coverageReport is a disassembly of the program annotated with the coverage,
+// and a summary.
+type coverageReport struct {
+	Lines   []coverageLine
+	Summary []string
+}
+
+func makeCoverageReport(program []int64, coverage *Coverage) coverageReport {
+	// Instructions only reached through computed jumps are not found by
+	// the analysis, but were seen executing.
+	code := analyze(program).Code
+	for address := range coverage.Executed {
+		if _, ok := code[address]; !ok {
+			if line, ok := decode(program, address); ok {
+				code[address] = line
+			}
+		}
+	}
+
+	var report coverageReport
+	var instructions, executed, directions, taken int
+	for _, line := range listing(program, code) {
+		annotated := coverageLine{listingLine: line, Class: "data"}
+		if !line.Code {
+			report.Lines = append(report.Lines, annotated)
+			continue
+		}
+
+		annotated.Count = coverage.Executed[line.Address]
+		annotated.Class = "missed"
+		instructions++
+		if annotated.Count > 0 {
+			annotated.Class = "covered"
+			executed++
+		}
+
+		if instruction := code[line.Address]; instruction.Opcode == OpJumpIfTrue || instruction.Opcode == OpJumpIfFalse {
+			branch := coverage.Branches[line.Address]
+			if branch == nil {
+				branch = &Branch{}
+			}
+
+			// A jump on a constant only ever goes one way.
+			possible := 2
+			if instruction.Operands[0].Mode == ModeImmediate {
+				possible = 1
+			}
+			went := 0
+			if branch.Taken > 0 {
+				went++
+			}
+			if branch.NotTaken > 0 {
+				went++
+			}
+			directions += possible
+			taken += went
+
+			if annotated.Count > 0 {
+				annotated.Branch = fmt.Sprintf("taken %d of %d", branch.Taken, branch.Taken+branch.NotTaken)
+				if went < possible {
+					annotated.Class = "partial"
+				}
+			}
+		}
+		report.Lines = append(report.Lines, annotated)
+	}
+
+	percent := func(n, of int) float64 {
+		if of == 0 {
+			return 100
+		}
+		return float64(n) * 100 / float64(of)
+	}
+	report.Summary = []string{
+		fmt.Sprintf("runs: %d", coverage.Runs),
+		fmt.Sprintf("instructions: %d of %d executed (%.1f%%)", executed, instructions, percent(executed, instructions)),
+		fmt.Sprintf("branches: %d of %d directions taken (%.1f%%)", taken, directions, percent(taken, directions)),
+	}
+	return report
+}
+
+// Text formats the report as a disassembly with execution counts, marking
+// instructions never executed with ##### and partly taken branches with *.
+func (report coverageReport) Text() string {
+	var builder strings.Builder
+	for _, line := range report.Lines {
+		count := ""
+		switch line.Class {
+		case "missed":
+			count = "#####"
+		case "covered", "partial":
+			count = strconv.FormatInt(line.Count, 10)
+		}
+		marker := " "
+		if line.Class == "partial" {
+			marker = "*"
+		}
+		text := line.Text
+		if line.Branch != "" {
+			text = fmt.Sprintf("%-56s ; %s", text, line.Branch)
+		}
+		fmt.Fprintf(&builder, "%10s %s %s\n", count, marker, text)
+	}
+	builder.WriteString("\n")
+	for _, line := range report.Summary {
+		builder.WriteString(line + "\n")
+	}
+	return builder.String()
+}
+
+const coverageStyle = `body { font-family: sans-serif; }
+pre { font-size: 13px; line-height: 1.3; }
+.count { display: inline-block; width: 7em; text-align: right; margin-right: 1em; color: #666; }
+.covered { background: #dfd; }
+.partial { background: #ffc; }
+.missed { background: #fdd; }
+.data { color: #999; }
+.branch { color: #666; }
+`
+
+// HTML formats the report as a standalone page.
+func (report coverageReport) HTML(title string) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n", html.EscapeString(title))
+	fmt.Fprintf(&builder, "<style>\n%s</style>\n</head>\n<body>\n", coverageStyle)
+	fmt.Fprintf(&builder, "<h1>%s</h1>\n<ul>\n", html.EscapeString(title))
+	for _, line := range report.Summary {
+		fmt.Fprintf(&builder, "<li>%s</li>\n", html.EscapeString(line))
+	}
+	builder.WriteString("</ul>\n<pre>\n")
+	for _, line := range report.Lines {
+		count := ""
+		if line.Class != "data" {
+			count = strconv.FormatInt(line.Count, 10)
+		}
+		fmt.Fprintf(&builder, "<div class=\"%s\" id=\"a%d\"><span class=\"count\">%s</span>%s", line.Class, line.Address, count, html.EscapeString(line.Text))
+		if line.Branch != "" {
+			fmt.Fprintf(&builder, "  <span class=\"branch\">; %s</span>", html.EscapeString(line.Branch))
+		}
+		builder.WriteString("</div>")
+	}
+	builder.WriteString("</pre>\n</body>\n</html>\n")
+	return builder.String()
+}
+
+// runCoverage runs the program until it halts, waits for more input than it
+// was given or has executed steps instructions, and records its coverage. It
+// returns how the run ended, "limit" for the last.
+func runCoverage(program []int64, coverage *Coverage, input []int64, steps int) (result string) {
+	emulator := makeEmulator(program, input...)
+	emulator.singleStep = true
+	emulator.coverage = coverage
+	coverage.Runs++
+
+	defer func() {
+		if r := recover(); r != nil {
+			result = fmt.Sprint(r)
+		}
+	}()
+
+	for step := 0; ; step++ {
+		if step == steps {
+			return "limit"
+		}
+		_, status := emulate(emulator)
+		switch status {
+		case EmulatorStatusHalted:
+			return "halted"
+		case EmulatorStatusWaitingForInput:
+			return "waiting for input"
+		}
+	}
+}
+
+func coverCommand(args []string) {
+	flags := flag.NewFlagSet("cover", flag.ExitOnError)
+	asciiFlag := flags.Bool("ascii", false, "send the input files as ASCII text")
+	inputFlag := flags.String("input", "", "comma-separated input values, sent at the start of every run")
+	profileFlag := flags.String("profile", "", "merge the coverage into this profile, creating it if needed")
+	htmlFlag := flags.String("html", "", "write an HTML report to this file")
+	quietFlag := flags.Bool("q", false, "do not print the annotated disassembly")
+	stepsFlag := flags.Int("steps", 10000000, "stop a run after this many instructions")
+	patches := &patchFlags{}
+	patches.register(flags)
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode cover [-ascii] [-input values] [-profile file] [-html file] [-q] [-steps n] [-poke address=value] [-patch file[:name]] program [input files]")
+		os.Exit(2)
+	}
+
+	filename := flags.Arg(0)
+	program := loadPatchedProgram(filename, patches)
+	coverage := makeCoverage(program)
+
+	var input []int64
+	if *inputFlag != "" {
+		input = parseProgram(*inputFlag)
+	}
+
+	// Every input file is a run; without any, there is a single run with
+	// the -input values, or the lines of stdin if it is not a terminal.
+	runs := flags.Args()[1:]
+	if len(runs) == 0 {
+		runs = []string{""}
+	}
+	for _, run := range runs {
+		runInput := append([]int64(nil), input...)
+		name := "run"
+		if run != "" {
+			name = run
+			if *asciiFlag {
+				for _, char := range readFile(run) + "\n" {
+					runInput = append(runInput, int64(char))
+				}
+			} else {
+				runInput = append(runInput, parseProgram(readFile(run))...)
+			}
+		} else if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice == 0 {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				if *asciiFlag {
+					for _, char := range scanner.Text() + "\n" {
+						runInput = append(runInput, int64(char))
+					}
+				} else {
+					runInput = append(runInput, parseProgram(scanner.Text())...)
+				}
+			}
+			check(scanner.Err())
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, runCoverage(program, coverage, runInput, *stepsFlag))
+	}
+
+	if *profileFlag != "" {
+		if data, err := ioutil.ReadFile(*profileFlag); err == nil {
+			previous, err := parseCoverage(*profileFlag, string(data))
+			if err == nil {
+				err = coverage.Merge(previous)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "intcode:", err)
+				os.Exit(1)
+			}
+		} else if !os.IsNotExist(err) {
+			check(err)
+		}
+		check(ioutil.WriteFile(*profileFlag, []byte(coverage.format()), 0644))
+	}
+
+	report := makeCoverageReport(program, coverage)
+	if *htmlFlag != "" {
+		check(ioutil.WriteFile(*htmlFlag, []byte(report.HTML("Coverage of "+filepath.Base(filename))), 0644))
+	}
+	if *quietFlag {
+		for _, line := range report.Summary {
+			fmt.Println(line)
+		}
+	} else {
+		fmt.Print(report.Text())
+	}
+}
+
+func sortedAddresses(counts map[int64]int64) []int64 {
+	var addresses []int64
+	for address := range counts {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+	return addresses
+}
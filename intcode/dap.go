@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// This file implements a Debug Adapter Protocol server, so that editors can
+// debug Intcode programs. The program is shown as its disassembly, which the
+// editor fetches as a source with a source reference; breakpoints are set on
+// lines of the disassembly or on instruction addresses. The registers and
+// memory are shown as variables, and input is fed through the debug console,
+// that is evaluate requests: "text <line>" sends a line of ASCII text, "[n]"
+// shows a memory word, and anything else is read as comma-separated input
+// values.
+
+const (
+	dapThread         = 1
+	dapSource         = 1
+	dapRegisters      = 1
+	dapMemory         = 2
+	dapMemoryRange    = 1000 // variables references of memory ranges start here
+	dapMemoryPageSize = 100
+)
+
+type dapMessage struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapLaunchArguments struct {
+	Program     string `json:"program"`
+	ASCII       bool   `json:"ascii"`
+	Input       string `json:"input"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+}
+
+type dapServer struct {
+	reader *bufio.Reader
+	writer io.Writer
+
+	mutex sync.Mutex // guards everything below, and writes
+	seq   int
+
+	name        string
+	emulator    *Emulator
+	history     *History
+	ascii       bool
+	listing     []int64 // address of each line of the disassembly
+	source      string
+	breakpoints map[int64]bool // set by address if true, by line if false
+	stopOnEntry bool
+	running     bool
+	pause       bool
+	halted      bool
+	closed      bool
+}
+
+func dapCommand(args []string) {
+	flags := flag.NewFlagSet("dap", flag.ExitOnError)
+	portFlag := flags.Int("port", 0, "listen on this local TCP port instead of using stdin and stdout")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: intcode dap [-port n]")
+		os.Exit(2)
+	}
+
+	if *portFlag == 0 {
+		serveDAP(os.Stdin, os.Stdout)
+		return
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", *portFlag))
+	check(err)
+	fmt.Fprintf(os.Stderr, "intcode: listening on %s\n", listener.Addr())
+	for {
+		connection, err := listener.Accept()
+		check(err)
+		serveDAP(connection, connection)
+		connection.Close()
+	}
+}
+
+// serveDAP serves one debug session.
+func serveDAP(r io.Reader, w io.Writer) {
+	server := &dapServer{
+		reader:      bufio.NewReader(r),
+		writer:      w,
+		breakpoints: make(map[int64]bool),
+	}
+
+	// Stop a run in the background once the client is gone.
+	defer func() {
+		server.mutex.Lock()
+		server.closed = true
+		server.mutex.Unlock()
+	}()
+
+	for {
+		message, err := server.read()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "intcode:", err)
+			return
+		}
+		if message.Type != "request" {
+			continue
+		}
+		if !server.handle(message) {
+			return
+		}
+	}
+}
+
+// read reads a message with its Content-Length header.
+func (server *dapServer) read() (*dapMessage, error) {
+	length := -1
+	for {
+		line, err := server.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if value := strings.TrimPrefix(line, "Content-Length:"); value != line {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid header %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(server.reader, body); err != nil {
+		return nil, err
+	}
+	message := &dapMessage{}
+	if err := json.Unmarshal(body, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// send writes a message. The caller holds the mutex.
+func (server *dapServer) send(message map[string]interface{}) {
+	server.seq++
+	message["seq"] = server.seq
+	body, err := json.Marshal(message)
+	check(err)
+	fmt.Fprintf(server.writer, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (server *dapServer) respond(request *dapMessage, body interface{}) {
+	server.send(map[string]interface{}{
+		"type":        "response",
+		"request_seq": request.Seq,
+		"success":     true,
+		"command":     request.Command,
+		"body":        body,
+	})
+}
+
+func (server *dapServer) fail(request *dapMessage, text string) {
+	server.send(map[string]interface{}{
+		"type":        "response",
+		"request_seq": request.Seq,
+		"success":     false,
+		"command":     request.Command,
+		"message":     text,
+	})
+}
+
+func (server *dapServer) event(name string, body interface{}) {
+	message := map[string]interface{}{"type": "event", "event": name}
+	if body != nil {
+		message["body"] = body
+	}
+	server.send(message)
+}
+
+func (server *dapServer) stopped(reason, description string) {
+	server.event("stopped", map[string]interface{}{
+		"reason":            reason,
+		"description":       description,
+		"threadId":          dapThread,
+		"allThreadsStopped": true,
+	})
+}
+
+func (server *dapServer) console(text string) {
+	server.event("output", map[string]interface{}{"category": "console", "output": text})
+}
+
+// handle handles a request. It reports false when the session ends.
+func (server *dapServer) handle(request *dapMessage) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.emulator == nil && request.Command != "initialize" && request.Command != "launch" && request.Command != "disconnect" {
+		server.fail(request, "no program launched")
+		return true
+	}
+	if server.running && request.Command != "pause" && request.Command != "disconnect" && request.Command != "threads" {
+		server.fail(request, "program is running")
+		return true
+	}
+
+	switch request.Command {
+	case "initialize":
+		server.respond(request, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsStepBack":                 true,
+			"supportsInstructionBreakpoints":   true,
+			"supportsDisassembleRequest":       true,
+			"supportsSteppingGranularity":      true,
+		})
+
+	case "launch":
+		var arguments dapLaunchArguments
+		if err := json.Unmarshal(request.Arguments, &arguments); err != nil || arguments.Program == "" {
+			server.fail(request, "launch needs a program")
+			return true
+		}
+		if err := server.launch(arguments); err != nil {
+			server.fail(request, err.Error())
+			return true
+		}
+		server.stopOnEntry = arguments.StopOnEntry
+		server.respond(request, nil)
+		server.event("initialized", nil)
+
+	case "configurationDone":
+		// The editor has set the breakpoints, the program can start.
+		server.respond(request, nil)
+		if _, ok := server.breakpoints[0]; ok {
+			server.stopped("breakpoint", "")
+		} else if server.stopOnEntry {
+			server.stopped("entry", "")
+		} else {
+			server.start(nil)
+		}
+
+	case "setBreakpoints":
+		var arguments struct {
+			Breakpoints []struct {
+				Line int `json:"line"`
+			} `json:"breakpoints"`
+		}
+		json.Unmarshal(request.Arguments, &arguments)
+		server.clearBreakpoints(false)
+
+		breakpoints := []interface{}{}
+		for _, breakpoint := range arguments.Breakpoints {
+			if breakpoint.Line < 1 || breakpoint.Line > len(server.listing) {
+				breakpoints = append(breakpoints, map[string]interface{}{"verified": false, "message": "no such line"})
+				continue
+			}
+			address := server.listing[breakpoint.Line-1]
+			server.breakpoints[address] = false
+			breakpoints = append(breakpoints, map[string]interface{}{"verified": true, "line": breakpoint.Line})
+		}
+		server.respond(request, map[string]interface{}{"breakpoints": breakpoints})
+
+	case "setInstructionBreakpoints":
+		var arguments struct {
+			Breakpoints []struct {
+				InstructionReference string `json:"instructionReference"`
+				Offset               int64  `json:"offset"`
+			} `json:"breakpoints"`
+		}
+		json.Unmarshal(request.Arguments, &arguments)
+		server.clearBreakpoints(true)
+
+		breakpoints := []interface{}{}
+		for _, breakpoint := range arguments.Breakpoints {
+			address, err := strconv.ParseInt(breakpoint.InstructionReference, 0, 64)
+			if err != nil {
+				breakpoints = append(breakpoints, map[string]interface{}{"verified": false, "message": "invalid address"})
+				continue
+			}
+			address += breakpoint.Offset
+			server.breakpoints[address] = true
+			breakpoints = append(breakpoints, map[string]interface{}{"verified": true, "instructionReference": strconv.FormatInt(address, 10)})
+		}
+		server.respond(request, map[string]interface{}{"breakpoints": breakpoints})
+
+	case "setExceptionBreakpoints":
+		server.respond(request, map[string]interface{}{})
+
+	case "threads":
+		server.respond(request, map[string]interface{}{
+			"threads": []interface{}{map[string]interface{}{"id": dapThread, "name": server.name}},
+		})
+
+	case "stackTrace":
+		// The frames are those of the shadow call stack, innermost first.
+		var frames []interface{}
+		for i, location := range server.emulator.calls.locations(server.emulator.ip, server.emulator.relativeBase) {
+			frames = append(frames, map[string]interface{}{
+				"id":                          i + 1,
+				"name":                        fmt.Sprintf("%s ip=%d", functionName(location.Entry), location.IP),
+				"source":                      server.sourceInfo(),
+				"line":                        server.line(location.IP),
+				"column":                      1,
+				"instructionPointerReference": strconv.FormatInt(location.IP, 10),
+			})
+		}
+		server.respond(request, map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)})
+
+	case "source":
+		server.respond(request, map[string]interface{}{"content": server.source, "mimeType": "text/x-intcode"})
+
+	case "scopes":
+		server.respond(request, map[string]interface{}{
+			"scopes": []interface{}{
+				map[string]interface{}{"name": "Registers", "presentationHint": "registers", "variablesReference": dapRegisters},
+				map[string]interface{}{"name": "Memory", "variablesReference": dapMemory, "expensive": true},
+			},
+		})
+
+	case "variables":
+		var arguments struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		json.Unmarshal(request.Arguments, &arguments)
+		server.respond(request, map[string]interface{}{"variables": server.variables(arguments.VariablesReference)})
+
+	case "evaluate":
+		var arguments struct {
+			Expression string `json:"expression"`
+		}
+		json.Unmarshal(request.Arguments, &arguments)
+		result, err := server.evaluate(arguments.Expression)
+		if err != nil {
+			server.fail(request, err.Error())
+			return true
+		}
+		server.respond(request, map[string]interface{}{"result": result, "variablesReference": 0})
+
+	case "disassemble":
+		var arguments struct {
+			MemoryReference   string `json:"memoryReference"`
+			Offset            int64  `json:"offset"`
+			InstructionOffset int    `json:"instructionOffset"`
+			InstructionCount  int    `json:"instructionCount"`
+		}
+		json.Unmarshal(request.Arguments, &arguments)
+		address, err := strconv.ParseInt(arguments.MemoryReference, 0, 64)
+		if err != nil {
+			server.fail(request, "invalid memory reference")
+			return true
+		}
+		server.respond(request, map[string]interface{}{
+			"instructions": server.disassemble(address+arguments.Offset, arguments.InstructionOffset, arguments.InstructionCount),
+		})
+
+	case "continue":
+		server.respond(request, map[string]interface{}{"allThreadsContinued": true})
+		server.start(nil)
+
+	case "next":
+		// Step over calls, running until they return.
+		server.respond(request, nil)
+		depth := len(server.emulator.calls.Frames)
+		server.start(func() bool { return len(server.emulator.calls.Frames) <= depth })
+
+	case "stepIn":
+		server.respond(request, nil)
+		server.start(func() bool { return true })
+
+	case "stepOut":
+		// Run until the current call returns, by the shadow call stack.
+		server.respond(request, nil)
+		depth := len(server.emulator.calls.Frames)
+		server.start(func() bool { return len(server.emulator.calls.Frames) < depth })
+
+	case "stepBack":
+		server.respond(request, nil)
+		if _, ok := server.undo(); !ok {
+			server.stopped("entry", "at the start of the program")
+		} else {
+			server.stopped("step", "")
+		}
+
+	case "reverseContinue":
+		server.respond(request, nil)
+		for {
+			if _, ok := server.undo(); !ok {
+				server.stopped("entry", "at the start of the program")
+				break
+			}
+			if _, ok := server.breakpoints[server.emulator.ip]; ok {
+				server.stopped("breakpoint", "")
+				break
+			}
+		}
+
+	case "pause":
+		server.respond(request, nil)
+		server.pause = true
+
+	case "disconnect", "terminate":
+		server.closed = true
+		server.respond(request, nil)
+		return false
+
+	default:
+		server.fail(request, fmt.Sprintf("unsupported request %q", request.Command))
+	}
+
+	return true
+}
+
+func (server *dapServer) launch(arguments dapLaunchArguments) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	program := loadProgram(arguments.Program)
+	var input []int64
+	if arguments.Input != "" {
+		input = parseProgram(arguments.Input)
+	}
+
+	server.name = filepath.Base(arguments.Program)
+	server.emulator = makeEmulator(program, input...)
+	server.history = &History{}
+	server.emulator.singleStep = true
+	server.emulator.history = server.history
+	server.emulator.calls = &CallStack{}
+	server.ascii = arguments.ASCII
+
+	// Lines of the disassembly start with their address.
+	server.source = disassemble(program)
+	server.listing = nil
+	for _, line := range strings.Split(strings.TrimRight(server.source, "\n"), "\n") {
+		address, _ := strconv.ParseInt(strings.TrimSpace(strings.SplitN(line, ":", 2)[0]), 10, 64)
+		server.listing = append(server.listing, address)
+	}
+	return nil
+}
+
+// clearBreakpoints removes the breakpoints set by address, or by line.
+func (server *dapServer) clearBreakpoints(byAddress bool) {
+	for address, set := range server.breakpoints {
+		if set == byAddress {
+			delete(server.breakpoints, address)
+		}
+	}
+}
+
+func (server *dapServer) sourceInfo() map[string]interface{} {
+	return map[string]interface{}{"name": server.name + ".dis", "sourceReference": dapSource}
+}
+
+// line returns the line of the disassembly showing address.
+func (server *dapServer) line(address int64) int {
+	line := 1
+	for i, start := range server.listing {
+		if start <= address {
+			line = i + 1
+		}
+	}
+	return line
+}
+
+// start runs the program in the background until it stops, or if done is
+// set, until done reports true after an instruction. The caller holds the
+// mutex.
+func (server *dapServer) start(done func() bool) {
+	server.running = true
+	server.pause = false
+
+	go func() {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		defer func() { server.running = false }()
+
+		for steps := 0; !server.closed; steps++ {
+			reason, description := server.step()
+			if reason == "" {
+				if _, ok := server.breakpoints[server.emulator.ip]; ok {
+					reason = "breakpoint"
+				} else if done != nil && done() {
+					reason = "step"
+				} else if server.pause {
+					reason = "pause"
+				}
+			}
+			if reason == "exited" {
+				server.event("exited", map[string]interface{}{"exitCode": 0})
+				server.event("terminated", nil)
+				return
+			}
+			if reason != "" {
+				server.stopped(reason, description)
+				return
+			}
+
+			// Let other requests, like pause, in now and then.
+			if steps%10000 == 9999 {
+				server.mutex.Unlock()
+				server.mutex.Lock()
+			}
+		}
+	}()
+}
+
+// step executes one instruction. It returns the reason to stop, if any.
+func (server *dapServer) step() (reason, description string) {
+	if server.halted {
+		return "exited", ""
+	}
+
+	executed := len(server.history.Steps)
+	defer func() {
+		if r := recover(); r != nil {
+			if len(server.history.Steps) > executed {
+				server.history.Undo(server.emulator)
+			}
+			reason, description = "exception", fmt.Sprint(r)
+			server.console(description + "\n")
+		}
+	}()
+
+	value, status := emulate(server.emulator)
+	switch status {
+	case EmulatorStatusHalted:
+		server.halted = true
+		return "exited", ""
+
+	case EmulatorStatusWaitingForInput:
+		server.console("program is waiting for input\n")
+		return "pause", "waiting for input"
+
+	case EmulatorStatusOutput:
+		var text string
+		if server.ascii && value >= 0 && value < 128 {
+			text = string(rune(value))
+		} else {
+			text = strconv.FormatInt(value, 10) + "\n"
+		}
+		server.event("output", map[string]interface{}{"category": "stdout", "output": text})
+	}
+	return "", ""
+}
+
+func (server *dapServer) undo() (Step, bool) {
+	step, ok := server.history.Undo(server.emulator)
+	if ok {
+		server.halted = false
+	}
+	return step, ok
+}
+
+func (server *dapServer) variables(reference int) []interface{} {
+	variable := func(name string, value int64, children int) map[string]interface{} {
+		return map[string]interface{}{
+			"name":               name,
+			"value":              strconv.FormatInt(value, 10),
+			"variablesReference": children,
+		}
+	}
+
+	memory := server.emulator.memory
+	var variables []interface{}
+	switch {
+	case reference == dapRegisters:
+		variables = append(variables,
+			variable("ip", server.emulator.ip, 0),
+			variable("rb", server.emulator.relativeBase, 0),
+			variable("step", int64(len(server.history.Steps)), 0),
+			variable("input", int64(len(server.emulator.input)), 0))
+
+	case reference == dapMemory:
+		for start := 0; start < len(memory); start += dapMemoryPageSize {
+			end := start + dapMemoryPageSize
+			if end > len(memory) {
+				end = len(memory)
+			}
+			variables = append(variables, map[string]interface{}{
+				"name":               fmt.Sprintf("[%d..%d]", start, end-1),
+				"value":              "",
+				"variablesReference": dapMemoryRange + start/dapMemoryPageSize,
+			})
+		}
+
+	case reference >= dapMemoryRange:
+		start := (reference - dapMemoryRange) * dapMemoryPageSize
+		for address := start; address < start+dapMemoryPageSize && address < len(memory); address++ {
+			variables = append(variables, variable(fmt.Sprintf("[%d]", address), memory[address], 0))
+		}
+	}
+	return variables
+}
+
+// evaluate handles an expression typed into the debug console.
+func (server *dapServer) evaluate(expression string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	expression = strings.TrimSpace(expression)
+	switch {
+	case strings.HasPrefix(expression, "text "):
+		text := strings.TrimPrefix(expression, "text ")
+		server.emulator.WriteString(text + "\n")
+		return fmt.Sprintf("sent %d characters", len(text)+1), nil
+
+	case strings.HasPrefix(expression, "[") && strings.HasSuffix(expression, "]"):
+		address := toInt64(strings.TrimSpace(expression[1 : len(expression)-1]))
+		if address < 0 {
+			return "", fmt.Errorf("invalid address %d", address)
+		}
+		if address >= int64(len(server.emulator.memory)) {
+			return "0", nil
+		}
+		return strconv.FormatInt(server.emulator.memory[address], 10), nil
+
+	default:
+		values := parseProgram(expression)
+		server.emulator.input = append(server.emulator.input, values...)
+		return fmt.Sprintf("sent %d values", len(values)), nil
+	}
+}
+
+// disassemble decodes count instructions around address, starting offset
+// instructions away from it. Words that are not valid instructions are shown
+// as data.
+func (server *dapServer) disassemble(address int64, offset, count int) []interface{} {
+	memory := server.emulator.memory
+
+	// Walking backwards is ambiguous; step back one word at a time.
+	for ; offset < 0; offset++ {
+		address--
+	}
+	for ; offset > 0; offset-- {
+		if line, ok := decode(memory, address); ok {
+			address += line.Length()
+		} else {
+			address++
+		}
+	}
+
+	var instructions []interface{}
+	for i := 0; i < count; i++ {
+		text, length := "DATA", int64(1)
+		if line, ok := decode(memory, address); ok {
+			text, length = line.String(), line.Length()
+		} else if address < 0 || address >= int64(len(memory)) {
+			text = "??"
+		} else {
+			text = fmt.Sprintf("DATA %d", memory[address])
+		}
+
+		var words []string
+		for a := address; a < address+length; a++ {
+			if a >= 0 && a < int64(len(memory)) {
+				words = append(words, strconv.FormatInt(memory[a], 10))
+			}
+		}
+		instructions = append(instructions, map[string]interface{}{
+			"address":          strconv.FormatInt(address, 10),
+			"instruction":      strings.TrimSpace(text),
+			"instructionBytes": strings.Join(words, ","),
+			"location":         server.sourceInfo(),
+			"line":             server.line(address),
+		})
+		address += length
+	}
+	return instructions
+}
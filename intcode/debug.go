@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A debug session runs a program one instruction at a time and records its
+// history, so that it can also be stepped backwards: back to the previous
+// instruction, to the previous write of an address, or to any earlier step.
+
+const debugHelp = `commands:
+  s [n]           step n instructions (default 1)
+  c               continue until a breakpoint, input or halt
+  bs [n]          step n instructions backwards
+  bc              continue backwards until a breakpoint or the start
+  bw address      run backwards to the previous write of address
+  goto step       go back to an earlier step, or run forward to a later one
+  b [address]     set a breakpoint, or list them
+  d address       delete a breakpoint
+  r               show the registers and the current instruction
+  bt              show the calls leading to the current instruction
+  x address [n]   show n words of memory (default 1)
+  in values       append comma-separated values to the input
+  text string     append a line of ASCII text to the input
+  out             show the output so far
+  save file       save a snapshot of the machine, for intcode dump and memdiff
+  q               quit
+`
+
+type debugSession struct {
+	emulator    *Emulator
+	history     *History
+	calls       *CallStack
+	ascii       bool
+	breakpoints map[int64]bool
+	output      []int64 // output so far, in step order
+	printed     int     // length of the output shown so far
+	halted      bool
+	out         io.Writer
+}
+
+func debugCommand(args []string) {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	asciiFlag := flags.Bool("ascii", false, "show output as text")
+	inputFlag := flags.String("input", "", "comma-separated input values")
+	textFlag := flags.String("text", "", "send the contents of this file as ASCII input")
+	patches := &patchFlags{}
+	patches.register(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode debug [-ascii] [-input values] [-text file] [-poke address=value] [-patch file[:name]] program")
+		os.Exit(2)
+	}
+
+	var input []int64
+	if *inputFlag != "" {
+		input = parseProgram(*inputFlag)
+	}
+
+	session := &debugSession{
+		emulator:    makeEmulator(loadPatchedProgram(flags.Arg(0), patches), input...),
+		history:     &History{},
+		calls:       &CallStack{},
+		ascii:       *asciiFlag,
+		breakpoints: make(map[int64]bool),
+		out:         os.Stdout,
+	}
+	session.emulator.singleStep = true
+	session.emulator.history = session.history
+	session.emulator.calls = session.calls
+
+	if *textFlag != "" {
+		session.emulator.WriteString(readFile(*textFlag) + "\n")
+	}
+
+	session.showRegisters()
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprint(session.out, "(intcode) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(session.out)
+			return
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "q" {
+			return
+		}
+		session.execute(fields[0], fields[1:], strings.TrimSpace(strings.TrimPrefix(scanner.Text(), fields[0])))
+	}
+}
+
+// execute runs a debugger command. rest is the text after the command, for
+// commands taking a string.
+func (session *debugSession) execute(command string, args []string, rest string) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintln(session.out, r)
+			fmt.Fprint(session.out, session.calls.backtrace(session.emulator.ip, session.emulator.relativeBase))
+		}
+	}()
+
+	// count parses an optional count argument.
+	count := func() int {
+		if len(args) == 0 {
+			return 1
+		}
+		return int(toInt64(args[0]))
+	}
+	address := func() int64 {
+		if len(args) == 0 {
+			panic("missing address")
+		}
+		return toInt64(args[0])
+	}
+
+	switch command {
+	case "s":
+		for i := count(); i > 0 && session.step(); i-- {
+		}
+		session.showRegisters()
+
+	case "c":
+		for session.step() && !session.breakpoints[session.emulator.ip] {
+		}
+		session.showRegisters()
+
+	case "bs":
+		for i := count(); i > 0 && session.undo(); i-- {
+		}
+		session.showRegisters()
+
+	case "bc":
+		for session.undo() && !session.breakpoints[session.emulator.ip] {
+		}
+		session.showRegisters()
+
+	case "bw":
+		target := address()
+		for {
+			if len(session.history.Steps) == 0 {
+				fmt.Fprintf(session.out, "no earlier write to %d\n", target)
+				break
+			}
+			step := session.lastStep()
+			session.undo()
+			if step.Wrote && step.Address == target {
+				fmt.Fprintf(session.out, "%d was %d before this instruction\n", target, step.Old)
+				break
+			}
+		}
+		session.showRegisters()
+
+	case "goto":
+		target := count()
+		for len(session.history.Steps) > target && session.undo() {
+		}
+		for len(session.history.Steps) < target && session.step() {
+		}
+		session.showRegisters()
+
+	case "b":
+		if len(args) == 0 {
+			for _, address := range session.breakpointList() {
+				fmt.Fprintln(session.out, address)
+			}
+			break
+		}
+		session.breakpoints[address()] = true
+
+	case "d":
+		delete(session.breakpoints, address())
+
+	case "r":
+		session.showRegisters()
+
+	case "bt":
+		fmt.Fprint(session.out, session.calls.backtrace(session.emulator.ip, session.emulator.relativeBase))
+
+	case "x":
+		start := address()
+		n := int64(1)
+		if len(args) > 1 {
+			n = toInt64(args[1])
+		}
+		for a := start; a < start+n; a++ {
+			var value int64
+			if a >= 0 && a < int64(len(session.emulator.memory)) {
+				value = session.emulator.memory[a]
+			}
+			fmt.Fprintf(session.out, "%6d: %d\n", a, value)
+		}
+
+	case "in":
+		session.emulator.input = append(session.emulator.input, parseProgram(rest)...)
+
+	case "text":
+		session.emulator.WriteString(rest + "\n")
+
+	case "out":
+		fmt.Fprintln(session.out, session.formatOutput(session.output))
+
+	case "save":
+		if len(args) != 1 {
+			panic("missing file")
+		}
+		check(ioutil.WriteFile(args[0], []byte(formatSnapshot(takeSnapshot(session.emulator))), 0644))
+
+	case "help":
+		fmt.Fprint(session.out, debugHelp)
+
+	default:
+		fmt.Fprintf(session.out, "unknown command %q\n", command)
+		fmt.Fprint(session.out, debugHelp)
+	}
+}
+
+// step executes one instruction. It reports false if the program cannot
+// continue, because it halted or is waiting for input.
+func (session *debugSession) step() bool {
+	if session.halted {
+		fmt.Fprintln(session.out, "program halted")
+		return false
+	}
+
+	executed := len(session.history.Steps)
+	defer func() {
+		if r := recover(); r != nil {
+			// The faulting instruction did not complete.
+			if len(session.history.Steps) > executed {
+				session.history.Undo(session.emulator)
+			}
+			panic(r)
+		}
+	}()
+
+	value, status := emulate(session.emulator)
+	switch status {
+	case EmulatorStatusHalted:
+		session.halted = true
+		fmt.Fprintln(session.out, "program halted")
+		return false
+
+	case EmulatorStatusWaitingForInput:
+		fmt.Fprintln(session.out, "program is waiting for input")
+		return false
+
+	case EmulatorStatusOutput:
+		session.output = append(session.output, value)
+	}
+
+	return true
+}
+
+// undo reverts the last instruction. It reports false at the start of the
+// program.
+func (session *debugSession) undo() bool {
+	step, ok := session.history.Undo(session.emulator)
+	if !ok {
+		fmt.Fprintln(session.out, "at the start of the program")
+		return false
+	}
+	session.halted = false
+	if step.Output {
+		session.output = session.output[:len(session.output)-1]
+		if session.printed > len(session.output) {
+			session.printed = len(session.output)
+		}
+	}
+	return true
+}
+
+func (session *debugSession) lastStep() Step {
+	if len(session.history.Steps) == 0 {
+		return Step{}
+	}
+	return session.history.Steps[len(session.history.Steps)-1]
+}
+
+func (session *debugSession) showRegisters() {
+	// Output produced since the last time is shown first.
+	if session.printed < len(session.output) {
+		fmt.Fprintf(session.out, "output: %s\n", session.formatOutput(session.output[session.printed:]))
+		session.printed = len(session.output)
+	}
+
+	emulator := session.emulator
+	fmt.Fprintf(session.out, "step %d: ip=%d rb=%d", len(session.history.Steps), emulator.ip, emulator.relativeBase)
+	if line, ok := decode(emulator.memory, emulator.ip); ok {
+		fmt.Fprintf(session.out, "  %v", line)
+	} else if emulator.ip >= 0 && emulator.ip < int64(len(emulator.memory)) {
+		fmt.Fprintf(session.out, "  DATA %d", emulator.memory[emulator.ip])
+	}
+	if session.breakpoints[emulator.ip] {
+		fmt.Fprint(session.out, "  (breakpoint)")
+	}
+	fmt.Fprintln(session.out)
+	if session.breakpoints[emulator.ip] {
+		fmt.Fprint(session.out, session.calls.backtrace(emulator.ip, emulator.relativeBase))
+	}
+}
+
+func (session *debugSession) formatOutput(values []int64) string {
+	if !session.ascii {
+		parts := make([]string, len(values))
+		for i, value := range values {
+			parts[i] = strconv.FormatInt(value, 10)
+		}
+		return strings.Join(parts, ",")
+	}
+
+	var builder strings.Builder
+	for _, value := range values {
+		if value >= 0 && value < 128 {
+			builder.WriteRune(rune(value))
+		} else {
+			fmt.Fprintf(&builder, "[%d]", value)
+		}
+	}
+	return strconv.Quote(builder.String())
+}
+
+// breakpointList returns the breakpoints in order.
+func (session *debugSession) breakpointList() []int64 {
+	var addresses []int64
+	for address := range session.breakpoints {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+	return addresses
+}
@@ -0,0 +1,1742 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The decompiler turns the functions found by analyze into pseudo-code:
+// instructions become assignments, temporaries used once are folded into
+// expressions, and the control flow graph is turned back into if/else and
+// while statements, falling back to goto where it is not structured.
+//
+// Memory cells are named after their role: g<address> for globals, arg<n>,
+// local<n> and out<n> for the arguments, locals and outgoing arguments in a
+// function's frame, stack[n] for relative words in code without a frame, and
+// mem[...] for words accessed through computed addresses. An array-like access base[index] is shown for computed
+// addresses of the form base+index.
+
+// location is a memory cell. Relative locations are offsets from the relative
+// base at the entry of the function, so that they name the same frame slot
+// throughout it.
+type location struct {
+	Relative bool
+	Address  int64
+}
+
+// node is an expression.
+type node struct {
+	Op     string // "const", "var", "addr", "mem", "call", "input", "neg", "!" or a binary operator
+	Value  int64
+	Loc    location
+	Name   string  // function called
+	Target *node   // target of an indirect call
+	Args   []*node // operands or arguments
+}
+
+func constNode(value int64) *node {
+	return &node{Op: "const", Value: value}
+}
+
+func varNode(loc location) *node {
+	return &node{Op: "var", Loc: loc}
+}
+
+var negatedComparisons = map[string]string{"<": ">=", ">=": "<", ">": "<=", "<=": ">", "==": "!=", "!=": "=="}
+
+// not returns the logical negation of x as a 0 or 1 value.
+func not(x *node) *node {
+	if op, ok := negatedComparisons[x.Op]; ok {
+		return &node{Op: op, Args: x.Args}
+	}
+	if x.Op == "const" {
+		if x.Value == 0 {
+			return constNode(1)
+		}
+		return constNode(0)
+	}
+	return &node{Op: "!", Args: []*node{x}}
+}
+
+// negate returns a condition that holds if x is zero.
+func negate(x *node) *node {
+	if x.Op == "!" {
+		return x.Args[0]
+	}
+	return not(x)
+}
+
+func binaryOp(op string, x, y *node) *node {
+	switch op {
+	case "+":
+		if x.Op == "const" && y.Op == "const" {
+			return constNode(x.Value + y.Value)
+		}
+		if x.Op == "const" {
+			x, y = y, x
+		}
+		if y.Op == "const" && y.Value == 0 {
+			return x
+		}
+		if y.Op == "const" && y.Value < 0 {
+			return &node{Op: "-", Args: []*node{x, constNode(-y.Value)}}
+		}
+		if y.Op == "neg" {
+			return &node{Op: "-", Args: []*node{x, y.Args[0]}}
+		}
+
+	case "*":
+		if x.Op == "const" && y.Op == "const" {
+			return constNode(x.Value * y.Value)
+		}
+		if x.Op == "const" {
+			x, y = y, x
+		}
+		if y.Op == "const" && y.Value == 1 {
+			return x
+		}
+		if y.Op == "const" && y.Value == -1 {
+			if x.Op == "neg" {
+				return x.Args[0]
+			}
+			return &node{Op: "neg", Args: []*node{x}}
+		}
+
+	case "<":
+		if x.Op == "const" && y.Op == "const" {
+			if x.Value < y.Value {
+				return constNode(1)
+			}
+			return constNode(0)
+		}
+		if x.Op == "const" {
+			return &node{Op: ">", Args: []*node{y, x}}
+		}
+
+	case "==":
+		if x.Op == "const" && y.Op == "const" {
+			if x.Value == y.Value {
+				return constNode(1)
+			}
+			return constNode(0)
+		}
+		if x.Op == "const" {
+			x, y = y, x
+		}
+		if y.Op == "const" && y.Value == 0 {
+			return not(x)
+		}
+	}
+
+	return &node{Op: op, Args: []*node{x, y}}
+}
+
+var precedences = map[string]int{
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*":   6,
+	"neg": 7, "!": 7,
+}
+
+func (n *node) precedence() int {
+	if p, ok := precedences[n.Op]; ok {
+		return p
+	}
+	return 8
+}
+
+// hasSideEffects reports whether evaluating the expression has side effects.
+func (n *node) hasSideEffects() bool {
+	if n.Op == "call" || n.Op == "input" {
+		return true
+	}
+	if n.Target != nil && n.Target.hasSideEffects() {
+		return true
+	}
+	for _, arg := range n.Args {
+		if arg.hasSideEffects() {
+			return true
+		}
+	}
+	return false
+}
+
+// readsMemory reports whether the expression reads globals or words at
+// computed addresses, which calls and stores may change.
+func (n *node) readsMemory() bool {
+	if (n.Op == "var" && !n.Loc.Relative) || n.Op == "mem" {
+		return true
+	}
+	if n.Target != nil && n.Target.readsMemory() {
+		return true
+	}
+	for _, arg := range n.Args {
+		if arg.readsMemory() {
+			return true
+		}
+	}
+	return false
+}
+
+// reads counts the reads of loc in the expression.
+func (n *node) reads(loc location) int {
+	count := 0
+	if n.Op == "var" && n.Loc == loc {
+		count++
+	}
+	if n.Target != nil {
+		count += n.Target.reads(loc)
+	}
+	for _, arg := range n.Args {
+		count += arg.reads(loc)
+	}
+	return count
+}
+
+// variables calls fn for every location read by the expression.
+func (n *node) variables(fn func(location)) {
+	if n.Op == "var" {
+		fn(n.Loc)
+	}
+	if n.Target != nil {
+		n.Target.variables(fn)
+	}
+	for _, arg := range n.Args {
+		arg.variables(fn)
+	}
+}
+
+// replace returns the expression with reads of loc replaced by x.
+func (n *node) replace(loc location, x *node) *node {
+	if n.Op == "var" && n.Loc == loc {
+		return x
+	}
+	copied := *n
+	if n.Target != nil {
+		copied.Target = n.Target.replace(loc, x)
+	}
+	copied.Args = make([]*node, len(n.Args))
+	for i, arg := range n.Args {
+		copied.Args[i] = arg.replace(loc, x)
+	}
+
+	// Simplify again, e.g. when a constant or comparison was substituted.
+	switch copied.Op {
+	case "+", "*", "<", "==":
+		return binaryOp(copied.Op, copied.Args[0], copied.Args[1])
+	case "!":
+		return not(copied.Args[0])
+	}
+	return &copied
+}
+
+type stmtKind int
+
+const (
+	stmtAssign stmtKind = iota
+	stmtStore           // mem[Addr] = X
+	stmtOutput
+	stmtPrint // run of ASCII outputs
+	stmtExpr  // call whose result is not used
+	stmtReturn
+	stmtHalt
+	stmtAdjust // ARB outside of prologue and epilogue
+	stmtComment
+)
+
+type stmt struct {
+	Kind stmtKind
+	Dest location
+	Addr *node
+	X    *node
+	Text string
+}
+
+// reads counts the reads of loc by the statement.
+func (s *stmt) reads(loc location) int {
+	count := 0
+	for _, n := range []*node{s.Addr, s.X} {
+		if n != nil {
+			count += n.reads(loc)
+		}
+	}
+	return count
+}
+
+func (s *stmt) variables(fn func(location)) {
+	for _, n := range []*node{s.Addr, s.X} {
+		if n != nil {
+			n.variables(fn)
+		}
+	}
+}
+
+// hasSideEffects reports whether the statement does anything but assign a
+// frame slot.
+func (s *stmt) hasSideEffects() bool {
+	if s.Kind != stmtAssign || !s.Dest.Relative {
+		return true
+	}
+	return s.X.hasSideEffects()
+}
+
+type blockKind int
+
+const (
+	blockNext   blockKind = iota // continues with Next
+	blockBranch                  // continues with Taken if Cond is not zero, otherwise with Next
+	blockExit                    // returns, halts or jumps to an unknown address
+)
+
+type block struct {
+	Start int64
+	Stmts []*stmt
+	Kind  blockKind
+	Cond  *node
+	Taken *block
+	Next  *block
+
+	Succs, Preds []*block
+	Index        int // reverse postorder index
+	LiveIn       map[location]bool
+	LiveOut      map[location]bool
+}
+
+type decompiler struct {
+	a       *analysis
+	f       *function
+	blocks  map[int64]*block
+	order   []*block
+	globals map[int64]bool
+	patched map[int64]bool
+	lines   []string
+	emitted map[*block]bool
+	labels  map[*block]bool
+	firstAt map[*block]int
+	loops   map[*block]*naturalLoop
+	active  []*naturalLoop
+	idom    []int
+	ipdom   []int
+	pending []pendingLink
+}
+
+type naturalLoop struct {
+	Header *block
+	Body   map[*block]bool
+	Exit   *block
+}
+
+// decompile returns pseudo-code for the program.
+func decompile(memory []int64) string {
+	a := analyze(memory)
+
+	var entries []int64
+	for entry := range a.Functions {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i] < entries[j] })
+
+	// Statements are built for all functions first, as the number of
+	// parameters and whether a function returns a value are only known from
+	// its call sites.
+	decompilers := make(map[int64]*decompiler)
+	globals := make(map[int64]bool)
+	for _, entry := range entries {
+		d := &decompiler{a: a, f: a.Functions[entry], globals: globals}
+		d.buildBlocks()
+		decompilers[entry] = d
+	}
+	for _, entry := range entries {
+		decompilers[entry].inferParams()
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, entry := range entries {
+			if decompilers[entry].inferResults() {
+				changed = true
+			}
+		}
+	}
+
+	var builder strings.Builder
+	var functions []string
+	for _, entry := range entries {
+		d := decompilers[entry]
+		d.finishCalls()
+		d.simplify()
+		functions = append(functions, d.emitFunction())
+	}
+
+	var addresses []int64
+	for address := range globals {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+
+	for _, address := range addresses {
+		if address >= 0 && address < int64(len(memory)) {
+			fmt.Fprintf(&builder, "var g%d = %d;\n", address, memory[address])
+		} else {
+			fmt.Fprintf(&builder, "var g%d;\n", address)
+		}
+	}
+	if len(addresses) != 0 {
+		builder.WriteString("\n")
+	}
+	builder.WriteString(strings.Join(functions, "\n"))
+
+	return builder.String()
+}
+
+// operand returns the expression read by an operand of the instruction at
+// address. Position operands patched by the previous instruction read
+// mem[patch].
+func (d *decompiler) operand(o Operand, delta int64, patch *node) *node {
+	switch o.Mode {
+	case ModeImmediate:
+		return constNode(o.Value)
+	case ModeRelative:
+		return varNode(location{Relative: true, Address: o.Value + delta})
+	}
+	if patch != nil {
+		return &node{Op: "mem", Args: []*node{patch}}
+	}
+	if o.Value == d.a.FramePointer {
+		// Reading the mirrored relative base outside of address arithmetic.
+		return &node{Op: "addr", Loc: location{Relative: true, Address: delta}}
+	}
+	d.globals[o.Value] = true
+	return varNode(location{Address: o.Value})
+}
+
+// computedOperand returns the expression read by an operand whose word, at
+// word, the program writes at run time.
+func (d *decompiler) computedOperand(o Operand, word, delta int64) *node {
+	value := &node{Op: "mem", Args: []*node{constNode(word)}}
+	switch o.Mode {
+	case ModeImmediate:
+		return value
+	case ModeRelative:
+		return &node{Op: "mem", Args: []*node{binaryOp("+", &node{Op: "addr", Loc: location{Relative: true, Address: delta}}, value)}}
+	}
+	return &node{Op: "mem", Args: []*node{value}}
+}
+
+// patchTarget returns the operand word of a later instruction of the same
+// block that the instruction at address writes, if the instructions in
+// between neither use that word nor write what the instruction reads.
+func (d *decompiler) patchTarget(address int64, line Line, leaders map[int64]bool) (int64, bool) {
+	w := writtenParameter(line.Opcode)
+	if w < 0 || line.Operands[w].Mode != ModePosition {
+		return 0, false
+	}
+	target := line.Operands[w].Value
+
+	for next := address + line.Length(); ; {
+		nextLine, ok := d.a.Code[next]
+		if !ok || leaders[next] || d.f.Calls[next] != nil || target <= next {
+			return 0, false
+		}
+		if target < next+nextLine.Length() {
+			mode := nextLine.Operands[target-next-1].Mode
+			return target, target != next && mode == ModePosition
+		}
+
+		// In between, the target must not be used as data, and the inputs
+		// of the write must stay as they are.
+		for _, o := range nextLine.Operands {
+			if o.Mode == ModePosition && o.Value == target {
+				return 0, false
+			}
+		}
+		if v := writtenParameter(nextLine.Opcode); v >= 0 {
+			written := nextLine.Operands[v]
+			for _, input := range line.Operands[:w] {
+				// Words at relative and at fixed addresses may be the same.
+				if input.Mode != ModeImmediate && (input.Mode != written.Mode || input.Value == written.Value) {
+					return 0, false
+				}
+			}
+		}
+		if nextLine.Opcode == OpAdjustBase {
+			for _, input := range line.Operands[:w] {
+				if input.Mode == ModeRelative {
+					return 0, false
+				}
+			}
+		}
+		if nextLine.Opcode == OpJumpIfTrue || nextLine.Opcode == OpJumpIfFalse || nextLine.Opcode == OpHalt {
+			return 0, false
+		}
+		next += nextLine.Length()
+	}
+}
+
+func (d *decompiler) buildBlocks() {
+	f := d.f
+	d.blocks = make(map[int64]*block)
+	d.patched = make(map[int64]bool)
+
+	var addresses []int64
+	for address := range f.Instructions {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+
+	// Leaders start blocks: the body, jump targets, return addresses and
+	// instructions following a branch.
+	leaders := map[int64]bool{f.Body: true}
+	jumps := make(map[int64]bool)
+	for _, call := range f.Calls {
+		jumps[call.Jump] = true
+		if call.Return != call.Jump+3 {
+			leaders[call.Return] = true
+		}
+	}
+	for _, address := range addresses {
+		line := d.a.Code[address]
+		if jumps[address] {
+			continue
+		}
+		if line.Opcode == OpJumpIfTrue || line.Opcode == OpJumpIfFalse {
+			if line.Operands[1].Mode == ModeImmediate {
+				leaders[line.Operands[1].Value] = true
+			}
+			leaders[address+line.Length()] = true
+		}
+	}
+	for address := range f.Invalid {
+		leaders[address] = true
+	}
+
+	var current *block
+	var patches map[int64]*node
+	var lastCall *callSite
+
+	// Words the block wrote at fixed addresses so far. Operands among them
+	// are computed at run time, unless tracked as patches.
+	var written map[int64]bool
+	lastWrite := int64(-1)
+
+	startBlock := func(address int64) {
+		b := &block{Start: address}
+		d.blocks[address] = b
+		current = b
+		patches = make(map[int64]*node)
+		written = make(map[int64]bool)
+		lastWrite = -1
+	}
+
+	for i, address := range addresses {
+		if lastCall != nil && lastCall.Jump == address {
+			continue
+		}
+		if current == nil || leaders[address] || (i > 0 && !d.follows(addresses[i-1], address)) || current.Kind != blockNext {
+			if current != nil && current.Kind == blockNext {
+				// This is also where the previous block continues at an
+				// invalid instruction.
+				d.pendingNext(current, d.after(addresses[i-1]))
+			}
+			startBlock(address)
+		}
+
+		line := d.a.Code[address]
+		delta := f.Instructions[address]
+		next := address + line.Length()
+
+		if call := f.Calls[address]; call != nil {
+			lastCall = call
+			n := &node{Op: "call"}
+			if call.Target.Mode == ModeImmediate {
+				n.Name = d.a.Functions[call.Target.Value].Name
+			} else {
+				n.Target = d.operand(call.Target, delta, patches[call.Jump+2])
+			}
+			current.Stmts = append(current.Stmts, &stmt{Kind: stmtAssign, Dest: location{true, delta + 1}, X: n})
+			if call.Return != call.Jump+3 {
+				current.Kind = blockNext
+				current.Next = nil
+				d.pendingNext(current, call.Return)
+				current = nil
+			}
+			continue
+		}
+
+		if lastWrite >= 0 {
+			written[lastWrite] = true
+		}
+		lastWrite = -1
+		if w := writtenParameter(line.Opcode); w >= 0 && line.Operands[w].Mode == ModePosition {
+			lastWrite = line.Operands[w].Value
+		}
+
+		// Operands written before in the block, other than by a patch, are
+		// read from memory, as the instruction modifies itself.
+		var computed []string
+		for word := address; word < next; word++ {
+			if written[word] && patches[word] == nil {
+				computed = append(computed, strconv.FormatInt(word, 10))
+			}
+		}
+		if len(computed) > 0 {
+			current.Stmts = append(current.Stmts, &stmt{Kind: stmtComment, Text: fmt.Sprintf("self-modifying: the instruction at %d reads %s, written at run time", address, strings.Join(computed, ", "))})
+		}
+
+		operand := func(i int) *node {
+			word := address + 1 + int64(i)
+			if patch := patches[word]; patch != nil || !written[word] {
+				return d.operand(line.Operands[i], delta, patch)
+			}
+			return d.computedOperand(line.Operands[i], word, delta)
+		}
+
+		// Writes to the operands of a later instruction of the block patch
+		// it, if nothing in between changes what the write reads.
+		if target, ok := d.patchTarget(address, line, leaders); ok && patches[address+1+int64(writtenParameter(line.Opcode))] == nil {
+			var value *node
+			switch line.Opcode {
+			case OpAdd:
+				value = binaryOp("+", operand(0), operand(1))
+			case OpMultiply:
+				value = binaryOp("*", operand(0), operand(1))
+			}
+			if value != nil {
+				patches[target] = value
+				d.patched[address] = true
+				continue
+			}
+		}
+
+		// The frame pointer updates of compiled programs are hidden.
+		if d.isFramePointerUpdate(line) {
+			continue
+		}
+
+		assign := func(x *node) {
+			o := line.Operands[writtenParameter(line.Opcode)]
+			patch := patches[address+1+int64(writtenParameter(line.Opcode))]
+			switch {
+			case o.Mode == ModeRelative:
+				current.Stmts = append(current.Stmts, &stmt{Kind: stmtAssign, Dest: location{true, o.Value + delta}, X: x})
+			case patch != nil:
+				current.Stmts = append(current.Stmts, &stmt{Kind: stmtStore, Addr: patch, X: x})
+			default:
+				d.globals[o.Value] = true
+				current.Stmts = append(current.Stmts, &stmt{Kind: stmtAssign, Dest: location{Address: o.Value}, X: x})
+			}
+		}
+
+		switch line.Opcode {
+		case OpAdd:
+			x, y := operand(0), operand(1)
+			if x.Op == "addr" && y.Op == "const" {
+				assign(&node{Op: "addr", Loc: location{true, x.Loc.Address + y.Value}})
+			} else {
+				assign(binaryOp("+", x, y))
+			}
+		case OpMultiply:
+			assign(binaryOp("*", operand(0), operand(1)))
+		case OpLessThan:
+			assign(binaryOp("<", operand(0), operand(1)))
+		case OpEqual:
+			assign(binaryOp("==", operand(0), operand(1)))
+		case OpInput:
+			assign(&node{Op: "input"})
+		case OpOutput:
+			current.Stmts = append(current.Stmts, &stmt{Kind: stmtOutput, X: operand(0)})
+
+		case OpAdjustBase:
+			// Epilogues are part of the return.
+			if !f.Returns[next] && !(d.isFramePointerUpdate(d.a.Code[next]) && f.Returns[next+4]) {
+				current.Stmts = append(current.Stmts, &stmt{Kind: stmtAdjust, X: operand(0)})
+			}
+
+		case OpHalt:
+			current.Stmts = append(current.Stmts, &stmt{Kind: stmtHalt})
+			current.Kind = blockExit
+
+		case OpJumpIfTrue, OpJumpIfFalse:
+			target := line.Operands[1]
+			if f.Returns[address] {
+				current.Stmts = append(current.Stmts, &stmt{Kind: stmtReturn})
+				current.Kind = blockExit
+				break
+			}
+
+			if _, ok := unconditionalJump(line); ok {
+				if target.Mode == ModeImmediate {
+					d.pendingNext(current, target.Value)
+				} else {
+					current.Stmts = append(current.Stmts, &stmt{Kind: stmtComment, Text: "goto *" + d.format(operand(1))})
+					current.Kind = blockExit
+				}
+				current = nil
+				break
+			}
+
+			if line.Operands[0].Mode == ModeImmediate {
+				// Never jumps.
+				break
+			}
+
+			if target.Mode != ModeImmediate {
+				current.Stmts = append(current.Stmts, &stmt{Kind: stmtComment, Text: fmt.Sprintf("if (%s) goto *%s", d.format(operand(0)), d.format(operand(1)))})
+				break
+			}
+
+			cond := operand(0)
+			if line.Opcode == OpJumpIfFalse {
+				cond = negate(cond)
+			}
+			current.Kind = blockBranch
+			current.Cond = cond
+			d.pendingTaken(current, target.Value)
+			d.pendingNext(current, next)
+			current = nil
+		}
+	}
+	if current != nil && current.Kind == blockNext {
+		d.pendingNext(current, d.after(addresses[len(addresses)-1]))
+	}
+
+	for address := range f.Invalid {
+		text := fmt.Sprintf("fault: jump to %d outside of memory", address)
+		if address >= 0 && address < int64(len(d.a.Memory)) {
+			text = fmt.Sprintf("fault: invalid instruction %d at %d, unless modified at run time", d.a.Memory[address], address)
+		}
+		b := &block{Start: address, Kind: blockExit}
+		b.Stmts = append(b.Stmts, &stmt{Kind: stmtComment, Text: text})
+		d.blocks[address] = b
+	}
+
+	d.link()
+}
+
+// isFramePointerUpdate reports whether line updates the word mirroring the
+// relative base in compiled programs.
+func (d *decompiler) isFramePointerUpdate(line Line) bool {
+	return d.a.FramePointer >= 0 && line.Opcode == OpAdd &&
+		isFramePointerUpdate(line, line.Operands[1].Value) && line.Operands[0].Value == d.a.FramePointer
+}
+
+// after returns the address execution continues at after the instruction at
+// address, for instructions that do not jump.
+func (d *decompiler) after(address int64) int64 {
+	if call := d.f.Calls[address]; call != nil {
+		return call.Return
+	}
+	return address + d.a.Code[address].Length()
+}
+
+// follows reports whether the instruction at address directly follows the
+// one at previous.
+func (d *decompiler) follows(previous, address int64) bool {
+	if call := d.f.Calls[previous]; call != nil {
+		return call.Return == address
+	}
+	return d.after(previous) == address
+}
+
+// Successors are recorded by address and linked once all blocks exist.
+type pendingLink struct {
+	b      *block
+	taken  bool
+	target int64
+}
+
+func (d *decompiler) pendingNext(b *block, target int64) {
+	d.pending = append(d.pending, pendingLink{b, false, target})
+}
+
+func (d *decompiler) pendingTaken(b *block, target int64) {
+	d.pending = append(d.pending, pendingLink{b, true, target})
+}
+
+func (d *decompiler) link() {
+	for _, p := range d.pending {
+		target := d.blocks[p.target]
+		if target == nil {
+			// Jumps into the middle of a block do not happen in sane code.
+			target = &block{Start: p.target, Kind: blockExit}
+			target.Stmts = append(target.Stmts, &stmt{Kind: stmtComment, Text: fmt.Sprintf("jump into instruction at %d", p.target)})
+			d.blocks[p.target] = target
+		}
+		if p.taken {
+			p.b.Taken = target
+		} else {
+			p.b.Next = target
+		}
+	}
+	d.pending = nil
+
+	for _, b := range d.blocks {
+		if b.Kind == blockNext && b.Next == nil {
+			b.Kind = blockExit
+		}
+		if b.Kind == blockExit {
+			b.Next, b.Taken = nil, nil
+		}
+		for _, succ := range []*block{b.Taken, b.Next} {
+			if succ != nil {
+				b.Succs = append(b.Succs, succ)
+				succ.Preds = append(succ.Preds, b)
+			}
+		}
+	}
+}
+
+// inferParams counts the arguments written before each call, as the number
+// of parameters of a function is only known from its call sites.
+func (d *decompiler) inferParams() {
+	for _, b := range d.blocks {
+		for i, s := range b.Stmts {
+			callee := d.callee(s)
+			if callee == nil {
+				continue
+			}
+
+			// Arguments are the outgoing slots written since the previous call.
+			frame := s.Dest.Address - 1
+			written := make(map[int64]bool)
+			for _, prev := range b.Stmts[:i] {
+				if d.callee(prev) != nil || (prev.Kind == stmtAssign && prev.X.Op == "call") {
+					written = make(map[int64]bool)
+				} else if prev.Kind == stmtAssign && prev.Dest.Relative && prev.Dest.Address > frame {
+					written[prev.Dest.Address-frame] = true
+				}
+			}
+			params := 0
+			for written[int64(params)+1] {
+				params++
+			}
+			if params > callee.Params {
+				callee.Params = params
+			}
+		}
+	}
+}
+
+// inferResults marks the functions whose result is read after a call. A
+// function returning the result of a call makes the callee return a value
+// too, so it reports whether anything changed, to be repeated until nothing
+// does.
+func (d *decompiler) inferResults() (changed bool) {
+	for _, b := range d.blocks {
+		for i, s := range b.Stmts {
+			callee := d.callee(s)
+			if callee == nil || callee.ReturnsValue {
+				continue
+			}
+
+			// The result is used if the slot is read before it is written again.
+			result := s.Dest
+			used := b.Cond != nil && b.Cond.reads(result) > 0
+			for _, next := range b.Stmts[i+1:] {
+				if d.readsLocation(next, result) {
+					used = true
+					break
+				}
+				if next.Kind == stmtAssign && next.Dest == result {
+					break
+				}
+			}
+			if used {
+				callee.ReturnsValue = true
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// readsLocation is stmt.reads, including the arguments of calls and the
+// result of returns, which are only added by finishCalls.
+func (d *decompiler) readsLocation(s *stmt, loc location) bool {
+	if s.reads(loc) > 0 {
+		return true
+	}
+	if callee := d.callee(s); callee != nil {
+		return loc.Relative && loc.Address >= s.Dest.Address && loc.Address < s.Dest.Address+int64(callee.Params)
+	}
+	return s.Kind == stmtReturn && d.f.ReturnsValue && loc == location{true, 1}
+}
+
+// callee returns the function called directly by the statement, or nil.
+func (d *decompiler) callee(s *stmt) *function {
+	if s.Kind != stmtAssign || s.X.Op != "call" || s.X.Name == "" {
+		return nil
+	}
+	return d.calleeOf(s.X)
+}
+
+func (d *decompiler) calleeOf(call *node) *function {
+	for _, f := range d.a.Functions {
+		if f.Name == call.Name {
+			return f
+		}
+	}
+	return nil
+}
+
+// finishCalls adds the arguments to the calls and results to the returns.
+func (d *decompiler) finishCalls() {
+	for _, b := range d.blocks {
+		for _, s := range b.Stmts {
+			switch {
+			case s.Kind == stmtAssign && s.X.Op == "call":
+				params := 0
+				if callee := d.calleeOf(s.X); callee != nil {
+					params = callee.Params
+				}
+				for i := 0; i < params; i++ {
+					s.X.Args = append(s.X.Args, varNode(location{true, s.Dest.Address + int64(i)}))
+				}
+			case s.Kind == stmtReturn && d.f.ReturnsValue:
+				s.X = varNode(location{true, 1})
+			}
+		}
+	}
+}
+
+// simplify folds temporaries into expressions and removes dead stores.
+func (d *decompiler) simplify() {
+	for changed := true; changed; {
+		d.liveness()
+		changed = false
+		for _, b := range d.blocks {
+			if d.inline(b) {
+				changed = true
+			}
+		}
+		d.liveness()
+		for _, b := range d.blocks {
+			if d.removeDeadStores(b) {
+				changed = true
+			}
+		}
+	}
+
+	for _, b := range d.blocks {
+		d.mergeOutputs(b)
+	}
+}
+
+// liveness computes the frame slots live at the start and end of each block.
+func (d *decompiler) liveness() {
+	use := make(map[*block]map[location]bool)
+	def := make(map[*block]map[location]bool)
+	for _, b := range d.blocks {
+		use[b], def[b] = make(map[location]bool), make(map[location]bool)
+		read := func(loc location) {
+			if loc.Relative && !def[b][loc] {
+				use[b][loc] = true
+			}
+		}
+		for _, s := range b.Stmts {
+			s.variables(read)
+			if s.Kind == stmtAssign && s.Dest.Relative {
+				def[b][s.Dest] = true
+			}
+		}
+		if b.Cond != nil {
+			b.Cond.variables(read)
+		}
+		b.LiveOut = make(map[location]bool)
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range d.blocks {
+			for _, succ := range b.Succs {
+				for loc := range use[succ] {
+					if !b.LiveOut[loc] {
+						b.LiveOut[loc] = true
+						changed = true
+					}
+				}
+				for loc := range succ.LiveOut {
+					if !def[succ][loc] && !b.LiveOut[loc] {
+						b.LiveOut[loc] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+
+	for _, b := range d.blocks {
+		b.LiveIn = use[b]
+		for loc := range b.LiveOut {
+			if !def[b][loc] {
+				b.LiveIn[loc] = true
+			}
+		}
+	}
+}
+
+// liveAfter reports whether loc is read after statement i of the block
+// before being written.
+func (d *decompiler) liveAfter(b *block, i int, loc location) bool {
+	for _, s := range b.Stmts[i+1:] {
+		if s.reads(loc) > 0 {
+			return true
+		}
+		if s.Kind == stmtAssign && s.Dest == loc {
+			return false
+		}
+	}
+	if b.Cond != nil && b.Cond.reads(loc) > 0 {
+		return true
+	}
+	return b.LiveOut[loc]
+}
+
+// inline substitutes frame slots that are assigned and then read once into
+// the statement reading them.
+func (d *decompiler) inline(b *block) bool {
+	changed := false
+
+	for i := 0; i < len(b.Stmts); i++ {
+		s := b.Stmts[i]
+		if s.Kind != stmtAssign || !s.Dest.Relative || s.X.reads(s.Dest) > 0 {
+			continue
+		}
+
+		// Find the single reader and check the expression can be moved there.
+		reader := -1
+		movable := true
+		for j := i + 1; j < len(b.Stmts); j++ {
+			t := b.Stmts[j]
+			if n := t.reads(s.Dest); n > 0 {
+				if n == 1 {
+					reader = j
+				}
+				break
+			}
+			if t.Kind == stmtAssign && t.Dest == s.Dest {
+				break
+			}
+			written := t.Kind == stmtAssign && s.X.reads(t.Dest) > 0
+			if written || (t.hasSideEffects() && (s.X.hasSideEffects() || s.X.readsMemory())) {
+				movable = false
+			}
+		}
+
+		if reader == -1 && b.Cond != nil && b.Cond.reads(s.Dest) == 1 && movable {
+			if !b.LiveOut[s.Dest] && !d.readLater(b, i, s.Dest) {
+				b.Cond = b.Cond.replace(s.Dest, s.X)
+				b.Stmts = append(b.Stmts[:i], b.Stmts[i+1:]...)
+				i--
+				changed = true
+			}
+			continue
+		}
+
+		if reader == -1 || !movable {
+			continue
+		}
+		t := b.Stmts[reader]
+		if !(t.Kind == stmtAssign && t.Dest == s.Dest) && d.liveAfter(b, reader, s.Dest) {
+			continue
+		}
+
+		if t.Addr != nil {
+			t.Addr = t.Addr.replace(s.Dest, s.X)
+		}
+		t.X = t.X.replace(s.Dest, s.X)
+		b.Stmts = append(b.Stmts[:i], b.Stmts[i+1:]...)
+		i--
+		changed = true
+	}
+
+	return changed
+}
+
+// readLater reports whether a statement after i reads loc.
+func (d *decompiler) readLater(b *block, i int, loc location) bool {
+	for _, s := range b.Stmts[i+1:] {
+		if s.reads(loc) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *decompiler) removeDeadStores(b *block) bool {
+	changed := false
+	for i := 0; i < len(b.Stmts); i++ {
+		s := b.Stmts[i]
+		if s.Kind == stmtAssign && s.X.Op == "var" && s.X.Loc == s.Dest {
+			b.Stmts = append(b.Stmts[:i], b.Stmts[i+1:]...)
+			i--
+			changed = true
+			continue
+		}
+		if s.Kind != stmtAssign || !s.Dest.Relative || d.liveAfter(b, i, s.Dest) {
+			continue
+		}
+		// Only the return address slot and the callee's frame are written
+		// without being read in compiled code, apart from real dead stores.
+		if s.X.hasSideEffects() {
+			if s.X.Op == "call" || s.X.Op == "input" {
+				s.Kind = stmtExpr
+				changed = true
+			}
+			continue
+		}
+		b.Stmts = append(b.Stmts[:i], b.Stmts[i+1:]...)
+		i--
+		changed = true
+	}
+	return changed
+}
+
+// mergeOutputs turns runs of constant ASCII outputs into print statements.
+func (d *decompiler) mergeOutputs(b *block) {
+	var merged []*stmt
+	for _, s := range b.Stmts {
+		if s.Kind == stmtOutput && s.X.Op == "const" && isText(s.X.Value) {
+			if last := len(merged) - 1; last >= 0 && merged[last].Kind == stmtPrint {
+				merged[last].Text += string(rune(s.X.Value))
+				continue
+			}
+			merged = append(merged, &stmt{Kind: stmtPrint, Text: string(rune(s.X.Value))})
+			continue
+		}
+		merged = append(merged, s)
+	}
+
+	// Single characters read better as output('c').
+	for i, s := range merged {
+		if s.Kind == stmtPrint && len(s.Text) == 1 {
+			merged[i] = &stmt{Kind: stmtOutput, X: constNode(int64(s.Text[0]))}
+		}
+	}
+	b.Stmts = merged
+}
+
+func isText(value int64) bool {
+	return value == '\n' || (value >= ' ' && value < 127)
+}
+
+// name returns the name of a location in the current function.
+func (d *decompiler) name(loc location) string {
+	if !loc.Relative {
+		return "g" + strconv.FormatInt(loc.Address, 10)
+	}
+
+	offset := loc.Address
+	switch {
+	case d.f.Frame == 0:
+		// Without a prologue, the slots are not a frame.
+		return fmt.Sprintf("stack[%d]", offset)
+	case d.f.Entry == 0 && d.f.Frame != 0 && offset >= d.f.Frame:
+		// The entry point sets up the stack, its slots are just variables.
+		return "v" + strconv.FormatInt(offset-d.f.Frame, 10)
+	case offset == 0:
+		return "ret"
+	case offset < 0:
+		return "caller" + strconv.FormatInt(-offset, 10)
+	case offset <= int64(d.f.Params):
+		return "arg" + strconv.FormatInt(offset, 10)
+	case offset < d.f.Frame:
+		return "local" + strconv.FormatInt(offset, 10)
+	default:
+		return "out" + strconv.FormatInt(offset-d.f.Frame, 10)
+	}
+}
+
+func (d *decompiler) format(n *node) string {
+	return d.formatNode(n, 0)
+}
+
+func (d *decompiler) formatNode(n *node, parent int) string {
+	var s string
+	switch n.Op {
+	case "const":
+		s = strconv.FormatInt(n.Value, 10)
+	case "var":
+		s = d.name(n.Loc)
+	case "addr":
+		s = "&" + d.name(n.Loc)
+	case "input":
+		s = "input()"
+	case "call":
+		var args []string
+		for _, arg := range n.Args {
+			args = append(args, d.format(arg))
+		}
+		name := n.Name
+		if n.Target != nil {
+			name = "(*" + d.formatNode(n.Target, 8) + ")"
+		}
+		s = name + "(" + strings.Join(args, ", ") + ")"
+	case "mem":
+		s = d.formatMemory(n.Args[0])
+	case "neg":
+		s = "-" + d.formatNode(n.Args[0], 7)
+	case "!":
+		s = "!" + d.formatNode(n.Args[0], 7)
+	default:
+		p := n.precedence()
+		s = d.formatNode(n.Args[0], p) + " " + n.Op + " " + d.formatNode(n.Args[1], p+1)
+	}
+
+	if n.precedence() < parent {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// formatMemory formats a word at a computed address, as an array access if
+// the address is a base plus an index.
+func (d *decompiler) formatMemory(address *node) string {
+	if address.Op == "+" {
+		base, index := address.Args[0], address.Args[1]
+		if index.Op == "const" || index.Op == "addr" {
+			base, index = index, base
+		}
+		switch base.Op {
+		case "const":
+			d.globals[base.Value] = true
+			return fmt.Sprintf("g%d[%s]", base.Value, d.format(index))
+		case "addr", "var":
+			return fmt.Sprintf("%s[%s]", d.name(base.Loc), d.format(index))
+		}
+	}
+	if address.Op == "addr" {
+		return d.name(address.Loc) + "[0]"
+	}
+	return "mem[" + d.format(address) + "]"
+}
+
+func (d *decompiler) formatStmt(s *stmt) string {
+	switch s.Kind {
+	case stmtAssign:
+		if s.X.Op == "+" || s.X.Op == "-" {
+			if x := s.X.Args[0]; x.Op == "var" && x.Loc == s.Dest {
+				y := s.X.Args[1]
+				return fmt.Sprintf("%s %s= %s;", d.name(s.Dest), s.X.Op, d.format(y))
+			}
+		}
+		return fmt.Sprintf("%s = %s;", d.name(s.Dest), d.format(s.X))
+	case stmtStore:
+		return fmt.Sprintf("%s = %s;", d.formatMemory(s.Addr), d.format(s.X))
+	case stmtOutput:
+		if s.X.Op == "const" && isText(s.X.Value) {
+			return fmt.Sprintf("output(%s);", strconv.QuoteRune(rune(s.X.Value)))
+		}
+		return fmt.Sprintf("output(%s);", d.format(s.X))
+	case stmtPrint:
+		return fmt.Sprintf("print(%s);", strconv.Quote(s.Text))
+	case stmtExpr:
+		return d.format(s.X) + ";"
+	case stmtReturn:
+		if s.X != nil {
+			return "return " + d.format(s.X) + ";"
+		}
+		return "return;"
+	case stmtHalt:
+		return "halt();"
+	case stmtAdjust:
+		return "rb += " + d.format(s.X) + ";"
+	default:
+		return "// " + s.Text
+	}
+}
+
+func (d *decompiler) emitFunction() string {
+	f := d.f
+	d.lines = nil
+	d.emitted = make(map[*block]bool)
+	d.labels = make(map[*block]bool)
+	d.firstAt = make(map[*block]int)
+
+	// Functions only called through pointers have no call sites to count
+	// their arguments; slots read before they are written are arguments too.
+	// Not so if the frame holds arrays, which are written through pointers.
+	if entry := d.blocks[f.Body]; entry != nil && f.Entry != 0 && f.Frame != 0 && !d.takesAddresses() {
+		for loc := range entry.LiveIn {
+			if loc.Relative && loc.Address > int64(f.Params) && loc.Address < f.Frame {
+				f.Params = int(loc.Address)
+			}
+		}
+	}
+
+	var params []string
+	for i := 1; i <= f.Params; i++ {
+		params = append(params, "arg"+strconv.Itoa(i))
+	}
+
+	header := fmt.Sprintf("// %s at %d", f.Name, f.Entry)
+	if f.Frame != 0 {
+		header += fmt.Sprintf(", frame of %d words", f.Frame)
+	}
+
+	entry := d.blocks[f.Body]
+	if entry == nil {
+		return header + "\n" + fmt.Sprintf("func %s(%s) {\n\t// fault: no code\n}\n", f.Name, strings.Join(params, ", "))
+	}
+
+	d.order = d.reversePostorder(entry)
+	d.idom = d.dominators(false)
+	d.ipdom = d.dominators(true)
+	d.findLoops()
+
+	d.region(entry, nil, 1)
+
+	// Emit blocks that are only reached by goto.
+	for {
+		var missing *block
+		for b := range d.labels {
+			if !d.emitted[b] && (missing == nil || b.Start < missing.Start) {
+				missing = b
+			}
+		}
+		if missing == nil {
+			break
+		}
+		d.region(missing, nil, 1)
+	}
+
+	// Insert labels, last first so that the indexes stay valid.
+	var targets []*block
+	for b := range d.labels {
+		targets = append(targets, b)
+	}
+	sort.Slice(targets, func(i, j int) bool { return d.firstAt[targets[i]] > d.firstAt[targets[j]] })
+	for _, b := range targets {
+		at := d.firstAt[b]
+		label := fmt.Sprintf("L%d:", b.Start)
+		d.lines = append(d.lines[:at], append([]string{label}, d.lines[at:]...)...)
+	}
+
+	var builder strings.Builder
+	builder.WriteString(header + "\n")
+	fmt.Fprintf(&builder, "func %s(%s) {\n", f.Name, strings.Join(params, ", "))
+	for _, line := range d.lines {
+		builder.WriteString(line + "\n")
+	}
+	builder.WriteString("}\n")
+	return builder.String()
+}
+
+func (d *decompiler) emit(depth int, format string, args ...interface{}) {
+	d.lines = append(d.lines, strings.Repeat("\t", depth)+fmt.Sprintf(format, args...))
+}
+
+func (d *decompiler) reversePostorder(entry *block) []*block {
+	var order []*block
+	visited := make(map[*block]bool)
+	var visit func(b *block)
+	visit = func(b *block) {
+		visited[b] = true
+		for _, succ := range b.Succs {
+			if !visited[succ] {
+				visit(succ)
+			}
+		}
+		order = append(order, b)
+	}
+	visit(entry)
+
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	for i, b := range order {
+		b.Index = i
+	}
+	return order
+}
+
+// dominators computes immediate dominators, or immediate post-dominators
+// with a virtual exit node at index len(d.order), using the algorithm of
+// Cooper, Harvey and Kennedy. Nodes that are not reached get -1.
+func (d *decompiler) dominators(post bool) []int {
+	n := len(d.order)
+	exit := n
+
+	preds := make([][]int, n+1)
+	succs := make([][]int, n+1)
+	edge := func(from, to int) {
+		succs[from] = append(succs[from], to)
+		preds[to] = append(preds[to], from)
+	}
+	for _, b := range d.order {
+		for _, s := range b.Succs {
+			if post {
+				edge(s.Index, b.Index)
+			} else {
+				edge(b.Index, s.Index)
+			}
+		}
+		if post && b.Kind == blockExit {
+			edge(exit, b.Index)
+		}
+	}
+
+	root := 0
+	if post {
+		root = exit
+	}
+
+	// Number the nodes in postorder of the graph walked from the root.
+	number := make([]int, n+1)
+	for i := range number {
+		number[i] = -1
+	}
+	var order []int
+	visited := make([]bool, n+1)
+	var visit func(i int)
+	visit = func(i int) {
+		visited[i] = true
+		for _, s := range succs[i] {
+			if !visited[s] {
+				visit(s)
+			}
+		}
+		number[i] = len(order)
+		order = append(order, i)
+	}
+	visit(root)
+
+	idom := make([]int, n+1)
+	for i := range idom {
+		idom[i] = -1
+	}
+	idom[root] = root
+
+	intersect := func(a, b int) int {
+		for a != b {
+			for number[a] < number[b] {
+				a = idom[a]
+			}
+			for number[b] < number[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for k := len(order) - 1; k >= 0; k-- {
+			i := order[k]
+			if i == root {
+				continue
+			}
+			candidate := -1
+			for _, p := range preds[i] {
+				if idom[p] == -1 {
+					continue
+				}
+				if candidate == -1 {
+					candidate = p
+				} else {
+					candidate = intersect(p, candidate)
+				}
+			}
+			if candidate != -1 && idom[i] != candidate {
+				idom[i] = candidate
+				changed = true
+			}
+		}
+	}
+
+	return idom
+}
+
+func (d *decompiler) reachable(b *block) bool {
+	return b.Index < len(d.order) && d.order[b.Index] == b
+}
+
+func (d *decompiler) dominates(a, b *block) bool {
+	i := b.Index
+	for {
+		if i == a.Index {
+			return true
+		}
+		if d.idom[i] == i || d.idom[i] == -1 {
+			return false
+		}
+		i = d.idom[i]
+	}
+}
+
+func (d *decompiler) findLoops() {
+	d.loops = make(map[*block]*naturalLoop)
+	for _, b := range d.order {
+		for _, h := range b.Succs {
+			if !d.dominates(h, b) {
+				continue
+			}
+
+			loop := d.loops[h]
+			if loop == nil {
+				loop = &naturalLoop{Header: h, Body: map[*block]bool{h: true}}
+				d.loops[h] = loop
+			}
+
+			// Walk backwards from the latch to the header.
+			stack := []*block{b}
+			for len(stack) != 0 {
+				x := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if loop.Body[x] {
+					continue
+				}
+				loop.Body[x] = true
+				for _, p := range x.Preds {
+					if d.reachable(p) {
+						stack = append(stack, p)
+					}
+				}
+			}
+		}
+	}
+
+	for h, loop := range d.loops {
+		if h.Kind == blockBranch {
+			if !loop.Body[h.Taken] {
+				loop.Exit = h.Taken
+			} else if !loop.Body[h.Next] {
+				loop.Exit = h.Next
+			}
+		}
+		if loop.Exit != nil {
+			continue
+		}
+		for _, b := range d.order {
+			if !loop.Body[b] {
+				continue
+			}
+			for _, succ := range b.Succs {
+				if !loop.Body[succ] && (loop.Exit == nil || succ.Start < loop.Exit.Start) {
+					loop.Exit = succ
+				}
+			}
+		}
+	}
+}
+
+// region emits the blocks from b until stop is reached.
+func (d *decompiler) region(b, stop *block, depth int) {
+	for b != nil && b != stop {
+		if len(d.active) != 0 {
+			loop := d.active[len(d.active)-1]
+			if b == loop.Header {
+				d.emit(depth, "continue;")
+				return
+			}
+			if b == loop.Exit {
+				d.emit(depth, "break;")
+				return
+			}
+			if !loop.Body[b] && !d.onlyFrom(loop, b) {
+				// Code after the loop is emitted there.
+				d.labels[b] = true
+				d.emit(depth, "goto L%d;", b.Start)
+				return
+			}
+		}
+
+		if d.emitted[b] {
+			d.labels[b] = true
+			d.emit(depth, "goto L%d;", b.Start)
+			return
+		}
+
+		if loop := d.loops[b]; loop != nil {
+			d.emitLoop(loop, depth)
+			b = loop.Exit
+			continue
+		}
+
+		b = d.emitBlock(b, depth)
+	}
+}
+
+// onlyFrom reports whether b is only reached from the loop.
+func (d *decompiler) onlyFrom(loop *naturalLoop, b *block) bool {
+	for _, p := range b.Preds {
+		if d.reachable(p) && !loop.Body[p] {
+			return false
+		}
+	}
+	return true
+}
+
+// emitBlock emits the statements of a block and, for branches, the if
+// statement. It returns the block that follows.
+func (d *decompiler) emitBlock(b *block, depth int) *block {
+	d.emitted[b] = true
+	d.firstAt[b] = len(d.lines)
+
+	for _, s := range b.Stmts {
+		d.emit(depth, "%s", d.formatStmt(s))
+	}
+
+	switch b.Kind {
+	case blockNext:
+		return b.Next
+
+	case blockBranch:
+		// Branches leaving the loop read better as if (...) break; with the
+		// rest of the loop following.
+		if len(d.active) != 0 {
+			loop := d.active[len(d.active)-1]
+			for _, jump := range []*block{loop.Exit, loop.Header} {
+				cond, other := b.Cond, b.Next
+				if b.Next == jump {
+					cond, other = negate(b.Cond), b.Taken
+				} else if b.Taken != jump {
+					continue
+				}
+				if other == jump || other == loop.Exit || other == loop.Header {
+					continue
+				}
+				d.emit(depth, "if (%s) {", d.format(cond))
+				d.region(jump, nil, depth+1)
+				d.emit(depth, "}")
+				return other
+			}
+		}
+
+		join := d.postDominator(b)
+		format := "if (%s) {"
+		for {
+			then, otherwise, cond := d.skipEmpty(b.Next, join), d.skipEmpty(b.Taken, join), negate(b.Cond)
+			if then == join {
+				then, otherwise, cond = otherwise, then, b.Cond
+			}
+
+			d.emit(depth, format, d.format(cond))
+			d.region(then, join, depth+1)
+			if otherwise == join {
+				break
+			}
+
+			// A branch with nothing else in it continues an else if chain.
+			if d.elseIf(otherwise, b, join) {
+				b = otherwise
+				d.emitted[b] = true
+				d.firstAt[b] = len(d.lines)
+				format = "} else if (%s) {"
+				continue
+			}
+
+			d.emit(depth, "} else {")
+			d.region(otherwise, join, depth+1)
+			break
+		}
+		d.emit(depth, "}")
+		return join
+
+	default:
+		return nil
+	}
+}
+
+// skipEmpty returns join instead of b if b does nothing but continue there.
+func (d *decompiler) skipEmpty(b, join *block) *block {
+	if b != join && len(b.Stmts) == 0 && b.Kind == blockNext && b.Next == join && len(b.Preds) == 1 && d.loops[b] == nil {
+		d.emitted[b] = true
+		d.firstAt[b] = len(d.lines)
+		return join
+	}
+	return b
+}
+
+// takesAddresses reports whether the function uses the address of a frame
+// slot.
+func (d *decompiler) takesAddresses() bool {
+	found := false
+	var visit func(n *node)
+	visit = func(n *node) {
+		if n.Op == "addr" {
+			found = true
+		}
+		if n.Target != nil {
+			visit(n.Target)
+		}
+		for _, arg := range n.Args {
+			visit(arg)
+		}
+	}
+	for _, b := range d.blocks {
+		for _, s := range b.Stmts {
+			for _, n := range []*node{s.Addr, s.X} {
+				if n != nil {
+					visit(n)
+				}
+			}
+		}
+		if b.Cond != nil {
+			visit(b.Cond)
+		}
+	}
+	return found
+}
+
+// elseIf reports whether b, the else branch of parent, is a condition only
+// reached from there that can be emitted as an else if.
+func (d *decompiler) elseIf(b, parent, join *block) bool {
+	if len(b.Stmts) != 0 || b.Kind != blockBranch || d.emitted[b] || d.loops[b] != nil {
+		return false
+	}
+	if len(b.Preds) != 1 || b.Preds[0] != parent || d.postDominator(b) != join {
+		return false
+	}
+	if len(d.active) != 0 && d.active[len(d.active)-1].Exit == b {
+		return false
+	}
+	return true
+}
+
+func (d *decompiler) postDominator(b *block) *block {
+	i := d.ipdom[b.Index]
+	if i < 0 || i >= len(d.order) {
+		return nil
+	}
+	return d.order[i]
+}
+
+func (d *decompiler) emitLoop(loop *naturalLoop, depth int) {
+	h := loop.Header
+	d.active = append(d.active, loop)
+	defer func() { d.active = d.active[:len(d.active)-1] }()
+
+	if h.Kind == blockBranch && loop.Exit != nil && (h.Taken == loop.Exit || h.Next == loop.Exit) {
+		body, cond := h.Taken, h.Cond
+		if body == loop.Exit {
+			body, cond = h.Next, negate(h.Cond)
+		}
+
+		if len(h.Stmts) == 0 {
+			d.emitted[h] = true
+			d.firstAt[h] = len(d.lines)
+			d.emit(depth, "while (%s) {", d.format(cond))
+		} else {
+			d.emit(depth, "while (1) {")
+			d.emitted[h] = true
+			d.firstAt[h] = len(d.lines)
+			for _, s := range h.Stmts {
+				d.emit(depth+1, "%s", d.formatStmt(s))
+			}
+			d.emit(depth+1, "if (%s) {", d.format(negate(cond)))
+			d.emit(depth+2, "break;")
+			d.emit(depth+1, "}")
+		}
+		d.region(body, h, depth+1)
+		d.emit(depth, "}")
+		return
+	}
+
+	d.emit(depth, "while (1) {")
+	next := d.emitBlock(h, depth+1)
+	d.region(next, h, depth+1)
+	d.emit(depth, "}")
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// decode decodes the instruction at address. It reports false if the word
+// there is not a valid instruction.
+func decode(memory []int64, address int64) (Line, bool) {
+	if address < 0 || address >= int64(len(memory)) {
+		return Line{}, false
+	}
+
+	instruction := memory[address]
+	opcode, ok := opcodes[instruction%100]
+	if instruction < 0 || !ok {
+		return Line{}, false
+	}
+
+	line := Line{Kind: LineInstruction, Opcode: instruction % 100}
+	modes := instruction / 100
+	for i := int64(0); i < opcode.Parameters; i++ {
+		mode := modes % 10
+		modes /= 10
+		if mode > ModeRelative || address+1+i >= int64(len(memory)) {
+			return Line{}, false
+		}
+		line.Operands = append(line.Operands, Operand{Mode: mode, Value: memory[address+1+i]})
+	}
+
+	// Superfluous mode digits and immediate destinations are faults.
+	if modes != 0 {
+		return Line{}, false
+	}
+	if i := writtenParameter(line.Opcode); i >= 0 && line.Operands[i].Mode == ModeImmediate {
+		return Line{}, false
+	}
+
+	return line, true
+}
+
+// writtenParameter returns the index of the parameter an instruction writes
+// to, or -1 if it does not write to memory.
+func writtenParameter(opcode int64) int {
+	switch opcode {
+	case OpAdd, OpMultiply, OpLessThan, OpEqual:
+		return 2
+	case OpInput:
+		return 0
+	default:
+		return -1
+	}
+}
+
+// constantResult returns the value an ADD or MUL instruction computes if
+// both its inputs are immediate.
+func constantResult(line Line) (int64, bool) {
+	if line.Opcode != OpAdd && line.Opcode != OpMultiply {
+		return 0, false
+	}
+	a, b := line.Operands[0], line.Operands[1]
+	if a.Mode != ModeImmediate || b.Mode != ModeImmediate {
+		return 0, false
+	}
+	if line.Opcode == OpAdd {
+		return a.Value + b.Value, true
+	}
+	return a.Value * b.Value, true
+}
+
+// unconditionalJump returns the target of a JNZ or JZ instruction whose
+// condition is immediate and always holds.
+func unconditionalJump(line Line) (Operand, bool) {
+	if line.Opcode != OpJumpIfTrue && line.Opcode != OpJumpIfFalse {
+		return Operand{}, false
+	}
+	condition := line.Operands[0]
+	if condition.Mode != ModeImmediate || (condition.Value != 0) != (line.Opcode == OpJumpIfTrue) {
+		return Operand{}, false
+	}
+	return line.Operands[1], true
+}
+
+// formatInstruction formats an instruction with its address and raw words.
+func formatInstruction(memory []int64, address int64, line Line) string {
+	words := make([]string, line.Length())
+	for i := range words {
+		words[i] = strconv.FormatInt(memory[address+int64(i)], 10)
+	}
+	return fmt.Sprintf("%6d: %-24s %v", address, strings.Join(words, ","), line)
+}
+
+// disassemble lists the code reachable from the entry point, and the data
+// words in between.
+func disassemble(memory []int64) string {
+	var builder strings.Builder
+	for _, line := range listing(memory, analyze(memory).Code) {
+		builder.WriteString(line.Text)
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// A listingLine is a line of a disassembly: an instruction, or up to eight
+// data words.
+type listingLine struct {
+	Address int64
+	Code    bool
+	Text    string
+}
+
+// listing lists the given instructions in address order, and the data words
+// in between.
+func listing(memory []int64, code map[int64]Line) []listingLine {
+	var addresses []int64
+	for address := range code {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+
+	var lines []listingLine
+	writeData := func(from, to int64) {
+		for from < to {
+			end := from + 8
+			if end > to {
+				end = to
+			}
+			var words []string
+			for _, word := range memory[from:end] {
+				words = append(words, strconv.FormatInt(word, 10))
+			}
+			lines = append(lines, listingLine{Address: from, Text: fmt.Sprintf("%6d: %-24s DATA", from, strings.Join(words, ","))})
+			from = end
+		}
+	}
+
+	var next int64
+	for _, address := range addresses {
+		if address < next {
+			// Overlapping instructions; the earlier one already covers it.
+			continue
+		}
+		writeData(next, address)
+		line := code[address]
+		lines = append(lines, listingLine{Address: address, Code: true, Text: formatInstruction(memory, address, line)})
+		next = address + line.Length()
+	}
+	writeData(next, int64(len(memory)))
+
+	return lines
+}
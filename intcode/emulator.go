@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+)
+
+// This version of the intcode emulator does not use goroutines.
+
+type EmulatorStatus int
+
+const (
+	EmulatorStatusHalted          EmulatorStatus = 0
+	EmulatorStatusOutput          EmulatorStatus = 1
+	EmulatorStatusWaitingForInput EmulatorStatus = 2
+	EmulatorStatusStepped         EmulatorStatus = 3
+)
+
+type Emulator struct {
+	program          []int64 // the pristine program, restored by Reset
+	memory           []int64
+	input            []int64
+	ip, relativeBase int64
+
+	// If singleStep is set, emulate returns after every instruction. If
+	// history is set, every instruction is recorded there, if coverage is
+	// set, it counts the instructions and branches executed, if accesses is
+	// set, the reads and writes of memory, and if calls is set, it follows
+	// the calls and returns.
+	singleStep bool
+	history    *History
+	coverage   *Coverage
+	accesses   *AccessLog
+	calls      *CallStack
+
+	// If memoryLimit is set, addresses beyond it fault instead of growing
+	// memory.
+	memoryLimit int64
+}
+
+func (emulator *Emulator) WriteString(s string) (int, error) {
+	for _, char := range s {
+		emulator.input = append(emulator.input, int64(char))
+	}
+	return len(s), nil
+}
+
+func makeEmulator(program []int64, input ...int64) *Emulator {
+	// Copy the program into memory, so that we do not modify the original.
+	memory := make([]int64, len(program))
+	copy(memory, program)
+
+	return &Emulator{
+		program: program,
+		memory:  memory,
+		input:   input,
+	}
+}
+
+// Reset restores the emulator to its state before the program started,
+// reusing its memory, and discards any input and history.
+func (emulator *Emulator) Reset() {
+	emulator.memory = append(emulator.memory[:0], emulator.program...)
+	emulator.input = emulator.input[:0]
+	emulator.ip, emulator.relativeBase = 0, 0
+	if emulator.history != nil {
+		emulator.history.Steps = emulator.history.Steps[:0]
+	}
+}
+
+func emulate(emulator *Emulator, input ...int64) (int64, EmulatorStatus) {
+	emulator.input = append(emulator.input, input...)
+
+	getMemoryPointer := func(index int64) *int64 {
+		// Grow memory, if index is out of range.
+		if emulator.memoryLimit > 0 && index >= emulator.memoryLimit {
+			panic(fmt.Sprintf("fault: address beyond memory limit: ip=%d address=%d", emulator.ip, index))
+		}
+		for int64(len(emulator.memory)) <= index {
+			emulator.memory = append(emulator.memory, 0)
+		}
+		return &emulator.memory[index]
+	}
+
+	for {
+		instruction := emulator.memory[emulator.ip]
+		opcode := instruction % 100
+
+		if emulator.history != nil && opcode != 99 {
+			emulator.history.record(emulator, instruction)
+		}
+		if emulator.coverage != nil {
+			emulator.coverage.executed(emulator.ip)
+		}
+		if emulator.accesses != nil {
+			emulator.accesses.Steps++
+		}
+
+		getParameter := func(offset int64) *int64 {
+			parameter := emulator.memory[emulator.ip+offset]
+			mode := instruction / pow(10, offset+1) % 10
+			if emulator.accesses != nil && (mode == 0 || mode == 2) {
+				address := parameter
+				if mode == 2 {
+					address += emulator.relativeBase
+				}
+				emulator.accesses.access(address, int(offset)-1 == writtenParameter(opcode))
+			}
+			switch mode {
+			case 0: // position mode
+				return getMemoryPointer(parameter)
+			case 1: // immediate mode
+				return &parameter
+			case 2: // relative mode
+				return getMemoryPointer(emulator.relativeBase + parameter)
+			default:
+				panic(fmt.Sprintf("fault: invalid parameter mode: ip=%d instruction=%d offset=%d mode=%d", emulator.ip, instruction, offset, mode))
+			}
+		}
+
+		switch opcode {
+
+		case 1: // ADD
+			a, b, c := getParameter(1), getParameter(2), getParameter(3)
+			*c = *a + *b
+			emulator.ip += 4
+
+		case 2: // MULTIPLY
+			a, b, c := getParameter(1), getParameter(2), getParameter(3)
+			*c = *a * *b
+			emulator.ip += 4
+
+		case 3: // INPUT
+			if len(emulator.input) == 0 {
+				if emulator.history != nil {
+					// The instruction is executed again once there is input.
+					emulator.history.Steps = emulator.history.Steps[:len(emulator.history.Steps)-1]
+				}
+				if emulator.coverage != nil {
+					emulator.coverage.Executed[emulator.ip]--
+				}
+				if emulator.accesses != nil {
+					emulator.accesses.Steps--
+				}
+				return 0, EmulatorStatusWaitingForInput
+			}
+			a := getParameter(1)
+			if emulator.history != nil {
+				emulator.history.consumed(emulator.input[0])
+			}
+			*a = emulator.input[0]
+			emulator.input = emulator.input[1:]
+			emulator.ip += 2
+
+		case 4: // OUTPUT
+			a := getParameter(1)
+			emulator.ip += 2
+			return *a, EmulatorStatusOutput
+
+		case 5: // JUMP IF TRUE
+			a, b := getParameter(1), getParameter(2)
+			if emulator.coverage != nil {
+				emulator.coverage.branch(emulator.ip, *a != 0)
+			}
+			if *a != 0 {
+				if emulator.calls != nil {
+					emulator.calls.jump(emulator, *b)
+				}
+				emulator.ip = *b
+			} else {
+				emulator.ip += 3
+			}
+
+		case 6: // JUMP IF FALSE
+			a, b := getParameter(1), getParameter(2)
+			if emulator.coverage != nil {
+				emulator.coverage.branch(emulator.ip, *a == 0)
+			}
+			if *a == 0 {
+				if emulator.calls != nil {
+					emulator.calls.jump(emulator, *b)
+				}
+				emulator.ip = *b
+			} else {
+				emulator.ip += 3
+			}
+
+		case 7: // LESS THAN
+			a, b, c := getParameter(1), getParameter(2), getParameter(3)
+			if *a < *b {
+				*c = 1
+			} else {
+				*c = 0
+			}
+			emulator.ip += 4
+
+		case 8: // EQUAL
+			a, b, c := getParameter(1), getParameter(2), getParameter(3)
+			if *a == *b {
+				*c = 1
+			} else {
+				*c = 0
+			}
+			emulator.ip += 4
+
+		case 9: // RELATIVE BASE OFFSET
+			a := getParameter(1)
+			emulator.relativeBase += *a
+			emulator.ip += 2
+
+		case 99: // HALT
+			return 0, EmulatorStatusHalted
+
+		default:
+			panic(fmt.Sprintf("fault: invalid opcode: ip=%d instruction=%d opcode=%d", emulator.ip, instruction, opcode))
+		}
+
+		if emulator.singleStep {
+			return 0, EmulatorStatusStepped
+		}
+	}
+}
+
+func pow(a, b int64) int64 {
+	var p int64 = 1
+	for b > 0 {
+		if b&1 != 0 {
+			p *= a
+		}
+		b >>= 1
+		a *= a
+	}
+	return p
+}
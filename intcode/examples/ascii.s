@@ -0,0 +1,105 @@
+; Helpers for ASCII programs, like those of days 17, 21 and 25, written by
+; hand in assembly and linked into programs as an object module:
+;
+;	intcode asm -o ascii.o examples/ascii.s
+;
+; They follow the calling convention of the compiler (see compiler.go), so
+; compiled programs call them after declaring them:
+;
+;	func printnum(n);
+;	func puts(s);
+;	func readline(buffer, size);
+
+; printnum(n) writes n in decimal. Intcode cannot divide, so every digit
+; counts how often a power of ten can be subtracted.
+;
+; Frame: [rb-7] return address, [rb-6] n, [rb-5] pointer into .powers,
+; [rb-4] digit, [rb-3] nonzero once a digit was, [rb-2] power, [rb-1] scratch.
+printnum:
+	ARB 7
+	LT [rb-6], 0, [rb-1]
+	JZ [rb-1], .positive
+	OUT '-'
+	MUL [rb-6], -1, [rb-6]
+.positive:
+	ADD .powers, 0, [rb-5]
+	ADD 0, 0, [rb-3]
+.power:
+	ADD [rb-5], 0, [.power_load+1]
+.power_load:
+	ADD [0], 0, [rb-2]
+	ADD 0, 0, [rb-4]
+.subtract:
+	LT [rb-6], [rb-2], [rb-1]
+	JNZ [rb-1], .digit
+	MUL [rb-2], -1, [rb-1]
+	ADD [rb-6], [rb-1], [rb-6]
+	ADD [rb-4], 1, [rb-4]
+	JNZ 1, .subtract
+.digit:
+	; Leading zeros are skipped, but the last digit is always written.
+	ADD [rb-3], [rb-4], [rb-3]
+	EQ [rb-2], 1, [rb-1]
+	ADD [rb-3], [rb-1], [rb-1]
+	JZ [rb-1], .next_power
+	ADD [rb-4], '0', [rb-1]
+	OUT [rb-1]
+.next_power:
+	ADD [rb-5], 1, [rb-5]
+	EQ [rb-2], 1, [rb-1]
+	JZ [rb-1], .power
+	ARB -7
+	JNZ 1, [rb+0]
+
+; puts(s) writes the zero-terminated string at s.
+;
+; Frame: [rb-3] return address, [rb-2] s, [rb-1] character.
+puts:
+	ARB 3
+.puts_next:
+	ADD [rb-2], 0, [.puts_load+1]
+.puts_load:
+	ADD [0], 0, [rb-1]
+	JZ [rb-1], .puts_end
+	OUT [rb-1]
+	ADD [rb-2], 1, [rb-2]
+	JNZ 1, .puts_next
+.puts_end:
+	ARB -3
+	JNZ 1, [rb+0]
+
+; readline(buffer, size) reads a line, and stores it without the newline and
+; zero-terminated in buffer, dropping the characters that do not fit in size
+; words. It returns the length of the stored line.
+;
+; Frame: [rb-6] return address, [rb-5] buffer, [rb-4] size, [rb-3] length,
+; [rb-2] character, [rb-1] scratch.
+readline:
+	ARB 6
+	ADD 0, 0, [rb-3]
+.read:
+	IN [rb-2]
+	EQ [rb-2], '\n', [rb-1]
+	JNZ [rb-1], .terminate
+	ADD [rb-3], 1, [rb-1]
+	LT [rb-1], [rb-4], [rb-1]
+	JZ [rb-1], .read
+	ADD [rb-5], [rb-3], [.store+3]
+.store:
+	ADD [rb-2], 0, [0]
+	ADD [rb-3], 1, [rb-3]
+	JNZ 1, .read
+.terminate:
+	ADD [rb-5], [rb-3], [.store_zero+3]
+.store_zero:
+	ADD 0, 0, [0]
+	ADD [rb-3], 0, [rb-5]
+	ARB -6
+	JNZ 1, [rb+0]
+
+	SECTION data
+.powers:
+	DATA 1000000000000000000, 100000000000000000, 10000000000000000
+	DATA 1000000000000000, 100000000000000, 10000000000000, 1000000000000
+	DATA 100000000000, 10000000000, 1000000000, 100000000, 10000000
+	DATA 1000000, 100000, 10000, 1000, 100, 10, 1
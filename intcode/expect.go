@@ -0,0 +1,528 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expect scripts drive ASCII programs, like the adventure of day 25, in the
+// spirit of expect: they wait for output matching a pattern, send lines of
+// input, and branch on what the program said. A script is a list of
+// commands, one per line:
+//
+//	# Take whatever lies north of the start.
+//	expect /== (?P<start>.+) ==/
+//	print starting in the $start
+//	send north
+//	expect /Items here:\n- (?P<item>.+)/ goto found, /Command\?/ goto nothing
+//	found:
+//	send take $item
+//	expect /You take the $item\./ timeout 10000
+//	exit
+//	nothing:
+//	fail nothing north of the $start
+//
+// The commands are
+//
+//	expect alternatives  wait for one of the alternatives, see below
+//	send text            send text and a newline
+//	set name text        set a variable
+//	if a == b goto label jump to label if a and b are equal, or with !=,
+//	                     different
+//	goto label           jump to label, defined by a line "label:"
+//	print text           print text and a newline
+//	fail text            stop the script, failing with text as the reason
+//	exit                 stop the script
+//
+// $name and ${name} in text are replaced by the value of the variable name,
+// and in patterns by the value quoted.
+//
+// The alternatives of an expect are separated by commas, and each may be
+// followed by "goto label" to jump there when it happens. /pattern/ is a
+// regular expression, matched against the output not matched yet each time
+// a line is complete or the program waits for input; ^ and $ match at the
+// start and end of lines, and \/ is a slash. A match sets the variable 0 to
+// the text matched, and 1, 2, ... and the named groups to the text of the
+// groups, and discards the output up to its end. "halt" and "waiting" are
+// the program halting and waiting for input without a match. "timeout n"
+// waits n steps instead of the default; without goto, the script fails when
+// none of the alternatives happen in time, or the program halts or waits
+// when those are not alternatives.
+//
+// Output that is not ASCII is text too, as a decimal number on a line of its
+// own, like the result of day 17.
+
+type ExpectScript struct {
+	Filename string
+	Commands []ExpectCommand
+	Labels   map[string]int // the index of the command after every label
+}
+
+type ExpectCommand struct {
+	Line int
+	Name string // expect, send, set, if, goto, print, fail or exit
+
+	Text         string // of send, print and fail, the value of set, the left side of if
+	Variable     string // of set
+	Right        string // of if
+	Equal        bool   // if == rather than !=
+	Label        string // of goto and if
+	Alternatives []ExpectAlternative
+	Timeout      int64 // of expect, if not the default
+}
+
+// An ExpectAlternative is a pattern or, if Pattern is empty, an event: halt,
+// waiting or timeout.
+type ExpectAlternative struct {
+	Pattern string
+	Event   string
+	Label   string // where to go when it happens, if not the next command
+}
+
+var (
+	expectVariableRegex = regexp.MustCompile(`\$(\w+|\{\w+\})`)
+	expectNameRegex     = regexp.MustCompile(`^\w+$`)
+)
+
+// parseExpectScript parses a script and checks its labels and patterns.
+func parseExpectScript(filename, text string) (*ExpectScript, error) {
+	script := &ExpectScript{Filename: filename, Labels: make(map[string]int)}
+	var gotos []ExpectCommand
+
+	for i, line := range strings.Split(text, "\n") {
+		errorf := func(format string, args ...interface{}) (*ExpectScript, error) {
+			return nil, fmt.Errorf("%s:%d: %s", filename, i+1, fmt.Sprintf(format, args...))
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if label := strings.TrimSuffix(line, ":"); label != line && isIdentifier(label) {
+			if _, ok := script.Labels[label]; ok {
+				return errorf("label %s defined twice", label)
+			}
+			script.Labels[label] = len(script.Commands)
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		command := ExpectCommand{Line: i + 1, Name: fields[0]}
+		var rest string
+		if len(fields) == 2 {
+			rest = strings.TrimSpace(fields[1])
+		}
+
+		switch command.Name {
+		case "send", "print", "fail":
+			command.Text = rest
+
+		case "set":
+			parts := strings.SplitN(rest, " ", 2)
+			if !expectNameRegex.MatchString(parts[0]) {
+				return errorf("expected set name text")
+			}
+			command.Variable = parts[0]
+			if len(parts) == 2 {
+				command.Text = strings.TrimSpace(parts[1])
+			}
+
+		case "if":
+			at := strings.LastIndex(rest, " goto ")
+			if at < 0 {
+				return errorf("expected if a == b goto label")
+			}
+			command.Label = strings.TrimSpace(rest[at+len(" goto "):])
+			condition := rest[:at]
+			operator := " == "
+			if !strings.Contains(condition, operator) {
+				operator = " != "
+			}
+			sides := strings.SplitN(condition, operator, 2)
+			if len(sides) != 2 {
+				return errorf("expected a == b or a != b")
+			}
+			command.Text, command.Right = strings.TrimSpace(sides[0]), strings.TrimSpace(sides[1])
+			command.Equal = operator == " == "
+
+		case "goto":
+			command.Label = rest
+
+		case "exit":
+			if rest != "" {
+				return errorf("unexpected %q after exit", rest)
+			}
+
+		case "expect":
+			var err error
+			command.Alternatives, command.Timeout, err = parseExpectAlternatives(rest)
+			if err != nil {
+				return errorf("%v", err)
+			}
+
+		default:
+			return errorf("unknown command %q", command.Name)
+		}
+
+		if command.Name == "goto" || command.Name == "if" {
+			gotos = append(gotos, command)
+		}
+		for _, alternative := range command.Alternatives {
+			if alternative.Label != "" {
+				gotos = append(gotos, ExpectCommand{Line: command.Line, Label: alternative.Label})
+			}
+		}
+		script.Commands = append(script.Commands, command)
+	}
+
+	for _, command := range gotos {
+		if _, ok := script.Labels[command.Label]; !ok {
+			return nil, fmt.Errorf("%s:%d: undefined label %q", filename, command.Line, command.Label)
+		}
+	}
+	return script, nil
+}
+
+// parseExpectAlternatives parses the alternatives of an expect command.
+func parseExpectAlternatives(text string) (alternatives []ExpectAlternative, timeout int64, err error) {
+	word := func() string {
+		text = strings.TrimLeft(text, " \t")
+		end := strings.IndexAny(text, " \t,")
+		if end < 0 {
+			end = len(text)
+		}
+		word := text[:end]
+		text = text[end:]
+		return word
+	}
+
+	for {
+		text = strings.TrimLeft(text, " \t,")
+		if text == "" {
+			break
+		}
+
+		if text[0] == '/' {
+			var pattern strings.Builder
+			end := -1
+			for i := 1; i < len(text); i++ {
+				if text[i] == '\\' && i+1 < len(text) && text[i+1] == '/' {
+					pattern.WriteByte('/')
+					i++
+				} else if text[i] == '/' {
+					end = i
+					break
+				} else {
+					pattern.WriteByte(text[i])
+				}
+			}
+			if end < 0 {
+				return nil, 0, fmt.Errorf("unterminated pattern %s", text)
+			}
+			// Check the pattern, with the variables empty.
+			if _, err := regexp.Compile(expectVariableRegex.ReplaceAllString(pattern.String(), "")); err != nil {
+				return nil, 0, err
+			}
+			alternatives = append(alternatives, ExpectAlternative{Pattern: pattern.String()})
+			text = text[end+1:]
+			continue
+		}
+
+		switch keyword := word(); keyword {
+		case "halt", "waiting":
+			alternatives = append(alternatives, ExpectAlternative{Event: keyword})
+
+		case "timeout":
+			steps := word()
+			if timeout, err = strconv.ParseInt(steps, 10, 64); err != nil || timeout <= 0 {
+				return nil, 0, fmt.Errorf("invalid timeout %q", steps)
+			}
+			alternatives = append(alternatives, ExpectAlternative{Event: "timeout"})
+
+		case "goto":
+			label := word()
+			if len(alternatives) == 0 || alternatives[len(alternatives)-1].Label != "" || !isIdentifier(label) {
+				return nil, 0, fmt.Errorf("expected an alternative before goto %s", label)
+			}
+			alternatives[len(alternatives)-1].Label = label
+
+		default:
+			return nil, 0, fmt.Errorf("unexpected %q, expected /pattern/, halt, waiting, timeout n or goto label", keyword)
+		}
+	}
+
+	if len(alternatives) == 0 {
+		return nil, 0, fmt.Errorf("expect without alternatives")
+	}
+	return alternatives, timeout, nil
+}
+
+// expectRun runs a script against a program.
+type expectRun struct {
+	script    *ExpectScript
+	emulator  *Emulator
+	variables map[string]string
+	timeout   int64 // steps an expect waits by default
+
+	output  strings.Builder // not matched yet
+	halted  bool
+	waiting bool
+
+	stdout     io.Writer
+	transcript io.Writer // gets the output and input, if not nil
+}
+
+// substitute replaces the variables in text by their values, quoted if
+// the text is a pattern.
+func (run *expectRun) substitute(text string, quote bool) (string, error) {
+	var err error
+	result := expectVariableRegex.ReplaceAllStringFunc(text, func(reference string) string {
+		name := strings.Trim(reference, "${}")
+		value, ok := run.variables[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("undefined variable %s", name)
+		}
+		if quote {
+			return regexp.QuoteMeta(value)
+		}
+		return value
+	})
+	return result, err
+}
+
+// step runs the program until it completes a line of output, waits for
+// input, halts, or runs out of steps, and returns the steps taken.
+func (run *expectRun) step(steps int64) int64 {
+	var taken int64
+	for taken < steps && !run.halted && !run.waiting {
+		value, status := emulate(run.emulator)
+		switch status {
+		case EmulatorStatusHalted:
+			run.halted = true
+		case EmulatorStatusWaitingForInput:
+			run.waiting = true
+		case EmulatorStatusStepped:
+			taken++
+		case EmulatorStatusOutput:
+			taken++
+			text := fmt.Sprintf("%d\n", value)
+			if value >= 0 && value < 128 {
+				text = string(rune(value))
+			}
+			run.output.WriteString(text)
+			if run.transcript != nil {
+				fmt.Fprint(run.transcript, text)
+			}
+			if strings.HasSuffix(text, "\n") {
+				return taken
+			}
+		}
+	}
+	return taken
+}
+
+// expect waits for one of the alternatives of a command and returns it.
+func (run *expectRun) expect(command ExpectCommand) (ExpectAlternative, error) {
+	patterns := make([]*regexp.Regexp, len(command.Alternatives))
+	for i, alternative := range command.Alternatives {
+		if alternative.Pattern == "" {
+			continue
+		}
+		pattern, err := run.substitute(alternative.Pattern, true)
+		if err == nil {
+			patterns[i], err = regexp.Compile("(?m)" + pattern)
+		}
+		if err != nil {
+			return alternative, err
+		}
+	}
+	event := func(name string) (ExpectAlternative, bool) {
+		for _, alternative := range command.Alternatives {
+			if alternative.Event == name {
+				return alternative, true
+			}
+		}
+		return ExpectAlternative{}, false
+	}
+
+	timeout := run.timeout
+	if command.Timeout > 0 {
+		timeout = command.Timeout
+	}
+
+	for taken := int64(0); ; taken += run.step(timeout - taken) {
+		// The earliest match wins, or the first alternative matching there.
+		output := run.output.String()
+		best, bestMatch := -1, []int(nil)
+		for i, pattern := range patterns {
+			if pattern == nil {
+				continue
+			}
+			if match := pattern.FindStringSubmatchIndex(output); match != nil && (best < 0 || match[0] < bestMatch[0]) {
+				best, bestMatch = i, match
+			}
+		}
+		if best >= 0 {
+			for group, name := range patterns[best].SubexpNames() {
+				if bestMatch[2*group] < 0 {
+					continue
+				}
+				value := output[bestMatch[2*group]:bestMatch[2*group+1]]
+				run.variables[strconv.Itoa(group)] = value
+				if name != "" {
+					run.variables[name] = value
+				}
+			}
+			run.output.Reset()
+			run.output.WriteString(output[bestMatch[1]:])
+			return command.Alternatives[best], nil
+		}
+
+		switch {
+		case run.halted:
+			if alternative, ok := event("halt"); ok {
+				return alternative, nil
+			}
+			return ExpectAlternative{}, fmt.Errorf("the program halted")
+		case run.waiting:
+			if alternative, ok := event("waiting"); ok {
+				return alternative, nil
+			}
+			return ExpectAlternative{}, fmt.Errorf("the program is waiting for input")
+		case taken >= timeout:
+			if alternative, ok := event("timeout"); ok && alternative.Label != "" {
+				return alternative, nil
+			}
+			return ExpectAlternative{}, fmt.Errorf("no match after %d steps", timeout)
+		}
+	}
+}
+
+// send sends a line of input.
+func (run *expectRun) send(text string) {
+	if run.transcript != nil {
+		fmt.Fprintln(run.transcript, text)
+	}
+	run.emulator.WriteString(text + "\n")
+	run.waiting = false
+}
+
+// run runs the script. The error of a failed command gives its line, and the
+// output not matched yet.
+func (run *expectRun) run() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("the program faulted: %v", r)
+		}
+	}()
+
+	script := run.script
+	for next := 0; next < len(script.Commands); {
+		command := script.Commands[next]
+		next++
+
+		fail := func(err error) error {
+			message := fmt.Sprintf("%s:%d: %s: %v", script.Filename, command.Line, command.Name, err)
+			if unmatched := strings.TrimSpace(run.output.String()); unmatched != "" {
+				message += "\noutput not matched:\n" + unmatched
+			}
+			return fmt.Errorf("%s", message)
+		}
+
+		var values []string
+		for _, text := range []string{command.Text, command.Right} {
+			value, err := run.substitute(text, false)
+			if err != nil {
+				return fail(err)
+			}
+			values = append(values, value)
+		}
+
+		switch command.Name {
+		case "expect":
+			alternative, err := run.expect(command)
+			if err != nil {
+				return fail(err)
+			}
+			if alternative.Label != "" {
+				next = script.Labels[alternative.Label]
+			}
+		case "send":
+			if run.halted {
+				return fail(fmt.Errorf("the program halted"))
+			}
+			run.send(values[0])
+		case "set":
+			run.variables[command.Variable] = values[0]
+		case "if":
+			if (values[0] == values[1]) == command.Equal {
+				next = script.Labels[command.Label]
+			}
+		case "goto":
+			next = script.Labels[command.Label]
+		case "print":
+			fmt.Fprintln(run.stdout, values[0])
+		case "fail":
+			return fail(fmt.Errorf("%s", values[0]))
+		case "exit":
+			return nil
+		}
+	}
+	return nil
+}
+
+func expectCommand(args []string) {
+	flags := flag.NewFlagSet("expect", flag.ExitOnError)
+	timeoutFlag := flags.Int64("timeout", 1000000, "steps an expect waits for a match, unless it gives a timeout")
+	verboseFlag := flags.Bool("v", false, "show the output of the program and the input sent on stderr")
+	var sets stringsFlag
+	flags.Var(&sets, "set", "set the variable `name=value` before the script starts; may be repeated")
+	patches := &patchFlags{}
+	patches.register(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: intcode expect [-timeout steps] [-v] [-set name=value] [-poke address=value] [-patch file[:name]] script program")
+		os.Exit(2)
+	}
+
+	text, err := ioutil.ReadFile(flags.Arg(0))
+	check(err)
+	script, err := parseExpectScript(flags.Arg(0), string(text))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "intcode:", err)
+		os.Exit(2)
+	}
+
+	run := &expectRun{
+		script:    script,
+		emulator:  makeEmulator(loadPatchedProgram(flags.Arg(1), patches)),
+		variables: make(map[string]string),
+		timeout:   *timeoutFlag,
+		stdout:    os.Stdout,
+	}
+	run.emulator.singleStep = true
+	if *verboseFlag {
+		run.transcript = os.Stderr
+	}
+	for _, set := range sets {
+		parts := strings.SplitN(set, "=", 2)
+		if len(parts) != 2 || !expectNameRegex.MatchString(parts[0]) {
+			fmt.Fprintf(os.Stderr, "intcode: invalid variable %q, expected name=value\n", set)
+			os.Exit(2)
+		}
+		run.variables[parts[0]] = parts[1]
+	}
+
+	if err := run.run(); err != nil {
+		fmt.Fprintln(os.Stderr, "intcode:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Devices write their output as records of a fixed number of values: the
+// arcade cabinet of day 13 writes x, y and tile, except for the score, which
+// it writes as -1, 0, score; the robot of day 11 writes a color and a turn;
+// the computers of day 23 write packets of an address, X and Y. A framer
+// collects output values into such records, and reports an error naming the
+// partial record if the program halts or waits for input in the middle of
+// one.
+
+// RecordShape declares the records a device writes.
+type RecordShape struct {
+	Name      string
+	Fields    []string
+	Sentinels []Sentinel
+}
+
+// Sentinel is a record with fixed values in some of its fields, which means
+// something else, like the score of day 13.
+type Sentinel struct {
+	Name  string
+	Match map[string]int64
+}
+
+type Record struct {
+	Kind   string // the name of the shape, or of the sentinel it matched
+	Values []int64
+	shape  *RecordShape
+}
+
+// Field returns the value of the field with the given name.
+func (record Record) Field(name string) int64 {
+	return record.Values[record.shape.index(name)]
+}
+
+func (record Record) String() string {
+	fields := make([]string, len(record.Values))
+	for i, value := range record.Values {
+		fields[i] = fmt.Sprintf("%s=%d", record.shape.Fields[i], value)
+	}
+	return fmt.Sprintf("%s(%s)", record.Kind, strings.Join(fields, ", "))
+}
+
+func (shape *RecordShape) has(field string) bool {
+	for _, name := range shape.Fields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (shape *RecordShape) index(field string) int {
+	for i, name := range shape.Fields {
+		if name == field {
+			return i
+		}
+	}
+	panic(fmt.Sprintf("%s records have no field %q", shape.Name, field))
+}
+
+// FramingError is a record cut short.
+type FramingError struct {
+	Shape   *RecordShape
+	Partial []int64
+	Event   string // what happened instead of the next value
+}
+
+func (err *FramingError) Error() string {
+	var got []string
+	for i, value := range err.Partial {
+		got = append(got, fmt.Sprintf("%s=%d", err.Shape.Fields[i], value))
+	}
+	return fmt.Sprintf("incomplete %s record: program %s after %s, before %s",
+		err.Shape.Name, err.Event, strings.Join(got, ", "), strings.Join(err.Shape.Fields[len(err.Partial):], ", "))
+}
+
+type Framer struct {
+	shape   *RecordShape
+	partial []int64
+}
+
+func makeFramer(shape *RecordShape) *Framer {
+	for _, sentinel := range shape.Sentinels {
+		for field := range sentinel.Match {
+			shape.index(field)
+		}
+	}
+	return &Framer{shape: shape}
+}
+
+// Push adds an output value, and returns the record if it is complete.
+func (framer *Framer) Push(value int64) (Record, bool) {
+	framer.partial = append(framer.partial, value)
+	if len(framer.partial) < len(framer.shape.Fields) {
+		return Record{}, false
+	}
+
+	record := Record{Kind: framer.shape.Name, Values: framer.partial, shape: framer.shape}
+	framer.partial = nil
+	for _, sentinel := range framer.shape.Sentinels {
+		matches := true
+		for field, value := range sentinel.Match {
+			matches = matches && record.Field(field) == value
+		}
+		if matches {
+			record.Kind = sentinel.Name
+			break
+		}
+	}
+	return record, true
+}
+
+// End reports an error if the program stopped writing in the middle of a
+// record, because of the event (e.g. "halted").
+func (framer *Framer) End(event string) error {
+	if len(framer.partial) == 0 {
+		return nil
+	}
+	err := &FramingError{Shape: framer.shape, Partial: framer.partial, Event: event}
+	framer.partial = nil
+	return err
+}
+
+// Next runs a step-wise emulator until it writes a complete record, halts or
+// waits for input.
+func (framer *Framer) Next(emulator *Emulator) (Record, EmulatorStatus, error) {
+	for {
+		value, status := emulate(emulator)
+		switch status {
+		case EmulatorStatusOutput:
+			if record, ok := framer.Push(value); ok {
+				return record, status, nil
+			}
+
+		case EmulatorStatusHalted:
+			return Record{}, status, framer.End("halted")
+
+		case EmulatorStatusWaitingForInput:
+			return Record{}, status, framer.End("waited for input")
+		}
+	}
+}
+
+// Receive reads a record from the output channel of an emulator running in a
+// goroutine. It reports false if the emulator halted instead; halt may be
+// nil for emulators that do not signal it.
+func (framer *Framer) Receive(output <-chan int64, halt <-chan bool) (Record, bool, error) {
+	for {
+		select {
+		case value := <-output:
+			if record, ok := framer.Push(value); ok {
+				return record, true, nil
+			}
+		case <-halt:
+			return Record{}, false, framer.End("halted")
+		}
+	}
+}
+
+// ReceiveFrom reads a record from a machine. It reports false if the machine
+// halted instead.
+func (framer *Framer) ReceiveFrom(machine Machine) (Record, bool, error) {
+	for {
+		message := machine.Receive()
+		switch message.Kind {
+		case MessageOutput:
+			if record, ok := framer.Push(message.Value); ok {
+				return record, true, nil
+			}
+		case MessageHalt:
+			return Record{}, false, framer.End("halted")
+		case MessageWaitingForInput:
+			if err := framer.End("waited for input"); err != nil {
+				return Record{}, false, err
+			}
+			return Record{}, false, fmt.Errorf("program waited for input instead of writing a %s record", framer.shape.Name)
+		}
+	}
+}
+
+// parseRecordShape parses "name:field,field,..." for the -frame flag.
+func parseRecordShape(text string) (*RecordShape, error) {
+	parts := strings.SplitN(text, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("expected name:field,field,... but got %q", text)
+	}
+	return &RecordShape{Name: parts[0], Fields: strings.Split(parts[1], ",")}, nil
+}
+
+// parseSentinel parses "name:field=value,..." for the -sentinel flag, with
+// fields of the shape.
+func parseSentinel(text string, shape *RecordShape) (Sentinel, error) {
+	parts := strings.SplitN(text, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return Sentinel{}, fmt.Errorf("expected name:field=value,... but got %q", text)
+	}
+	sentinel := Sentinel{Name: parts[0], Match: make(map[string]int64)}
+	for _, match := range strings.Split(parts[1], ",") {
+		sides := strings.SplitN(match, "=", 2)
+		if len(sides) != 2 {
+			return Sentinel{}, fmt.Errorf("expected field=value but got %q", match)
+		}
+		if !shape.has(sides[0]) {
+			return Sentinel{}, fmt.Errorf("%s records have no field %q", shape.Name, sides[0])
+		}
+		var value int64
+		if _, err := fmt.Sscan(sides[1], &value); err != nil {
+			return Sentinel{}, fmt.Errorf("invalid value %q", sides[1])
+		}
+		sentinel.Match[sides[0]] = value
+	}
+	return sentinel, nil
+}
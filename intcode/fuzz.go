@@ -0,0 +1,465 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The fuzzer runs a program on mutated input, keeping input that executes
+// instructions or takes branches no earlier input did, to mutate further.
+// Input making the program fault or run for too long is minimized and saved
+// as a reproducer. With -variants, programs that halt are also run on the
+// emulators of the puzzles (see variants.go), and any difference in their
+// output is saved the same way.
+//
+// The fuzzer keeps its state in a directory:
+//
+//	corpus/     the input found to increase coverage, one file per case
+//	crashes/    minimized faults, hangs and divergences
+//
+// A case file holds the input as comma-separated values, and the program on
+// a second line if it was mutated as well. Lines starting with # are comments.
+
+// fuzzCase is an input to try, and the program if it was mutated.
+type fuzzCase struct {
+	Input   []int64
+	Program []int64 // nil for the program being fuzzed
+}
+
+// fuzzResult is how a case ran on the emulator of the toolbox.
+type fuzzResult struct {
+	Outputs  []int64
+	Status   string // "halted", "waiting for input", or the fault or hang
+	Message  string // the fault in full
+	Failed   bool   // faulted or hung
+	Relative bool   // used ARB or relative mode
+	Memory   int64  // size of memory at the end
+	Coverage *Coverage
+}
+
+// fuzzMemoryLimit is the largest memory a run may use, as mutated programs
+// easily write to huge addresses.
+const fuzzMemoryLimit = 1 << 20
+
+// fuzzRun runs a program on the input for at most steps instructions.
+func fuzzRun(program, input []int64, steps int) (result fuzzResult) {
+	emulator := makeEmulator(program, input...)
+	emulator.singleStep = true
+	emulator.memoryLimit = fuzzMemoryLimit
+	emulator.coverage = &Coverage{Executed: make(map[int64]int64), Branches: make(map[int64]*Branch)}
+	result.Coverage = emulator.coverage
+
+	defer func() {
+		result.Memory = int64(len(emulator.memory))
+		if r := recover(); r != nil {
+			result.Failed = true
+			result.Message = fmt.Sprint(r)
+			result.Status = faultSignature(result.Message, emulator.ip)
+		}
+	}()
+
+	for step := 0; ; step++ {
+		if step == steps {
+			result.Failed = true
+			result.Status = stepLimit(steps)
+			result.Message = fmt.Sprintf("%s at ip=%d", result.Status, emulator.ip)
+			return result
+		}
+
+		if emulator.ip >= 0 && emulator.ip < int64(len(emulator.memory)) {
+			instruction := emulator.memory[emulator.ip]
+			if instruction%100 == OpAdjustBase {
+				result.Relative = true
+			}
+			for modes := instruction / 100; modes > 0; modes /= 10 {
+				if modes%10 == ModeRelative {
+					result.Relative = true
+				}
+			}
+		}
+
+		value, status := emulate(emulator)
+		switch status {
+		case EmulatorStatusHalted:
+			result.Status = "halted"
+			return result
+		case EmulatorStatusWaitingForInput:
+			result.Status = "waiting for input"
+			return result
+		case EmulatorStatusOutput:
+			result.Outputs = append(result.Outputs, value)
+		}
+	}
+}
+
+// faultSignature identifies a fault by its kind and address, leaving out the
+// values involved, so that the same bug found twice is saved once.
+func faultSignature(message string, ip int64) string {
+	if i := strings.Index(message, ": ip="); i >= 0 {
+		message = message[:i]
+	}
+	if i := strings.Index(message, " ["); i >= 0 {
+		message = message[:i]
+	}
+	return fmt.Sprintf("%s at ip=%d", message, ip)
+}
+
+// divergence runs the case on the emulators of the puzzles that support the
+// program, and describes the first one that does not agree with result.
+func divergence(program []int64, input []int64, steps int, result fuzzResult) string {
+	if result.Status != "halted" {
+		return ""
+	}
+	for _, implementation := range implementations[1:] {
+		if !implementation.Supports(program, result.Relative, result.Memory) {
+			continue
+		}
+		outputs, _, err := implementation.Run(program, input, steps)
+		if err != nil {
+			return fmt.Sprintf("%s: %v", implementation.Name(), err)
+		}
+		if formatProgram(outputs) != formatProgram(result.Outputs) {
+			return fmt.Sprintf("%s: output %s instead of %s", implementation.Name(), formatProgram(outputs), formatProgram(result.Outputs))
+		}
+	}
+	return ""
+}
+
+type fuzzer struct {
+	program   []int64
+	corpus    []fuzzCase
+	edges     map[int64]bool
+	found     map[string]bool // signatures of the faults and divergences saved
+	random    *rand.Rand
+	dir       string
+	steps     int
+	maxInputs int
+	ascii     bool
+	programs  bool
+	variants  bool
+
+	runs, crashes, hangs, divergences int
+}
+
+func (f *fuzzer) programOf(c fuzzCase) []int64 {
+	if c.Program != nil {
+		return c.Program
+	}
+	return f.program
+}
+
+// interesting returns a value likely to find bugs.
+func (f *fuzzer) interesting() int64 {
+	if f.ascii {
+		if f.random.Intn(8) == 0 {
+			return '\n'
+		}
+		return int64(' ' + f.random.Intn(95))
+	}
+	values := []int64{0, 1, -1, 2, 8, 10, 100, 1000, math.MaxInt32, math.MinInt32, math.MaxInt64, math.MinInt64}
+	if f.random.Intn(4) == 0 {
+		return int64(f.random.Intn(256)) - 128
+	}
+	return values[f.random.Intn(len(values))]
+}
+
+func (f *fuzzer) mutate(c fuzzCase) fuzzCase {
+	input := append([]int64(nil), c.Input...)
+	var program []int64
+	if c.Program != nil {
+		program = append([]int64(nil), c.Program...)
+	}
+
+	for n := 1 + f.random.Intn(4); n > 0; n-- {
+		operation := f.random.Intn(6)
+		if f.programs && f.random.Intn(4) == 0 {
+			operation = 6
+		}
+		if len(input) == 0 && operation < 5 {
+			operation = 2
+		}
+
+		switch operation {
+		case 0: // replace a value
+			input[f.random.Intn(len(input))] = f.interesting()
+
+		case 1: // change a value a little
+			input[f.random.Intn(len(input))] += int64(f.random.Intn(33) - 16)
+
+		case 2: // insert a value
+			i := f.random.Intn(len(input) + 1)
+			input = append(input[:i], append([]int64{f.interesting()}, input[i:]...)...)
+
+		case 3: // delete a value
+			i := f.random.Intn(len(input))
+			input = append(input[:i], input[i+1:]...)
+
+		case 4: // repeat a range
+			i := f.random.Intn(len(input))
+			j := i + 1 + f.random.Intn(len(input)-i)
+			input = append(input[:j], append(append([]int64(nil), input[i:j]...), input[j:]...)...)
+
+		case 5: // splice with another case
+			other := f.corpus[f.random.Intn(len(f.corpus))].Input
+			i := f.random.Intn(len(input) + 1)
+			j := f.random.Intn(len(other) + 1)
+			input = append(input[:i:i], other[j:]...)
+
+		case 6: // change a word of the program
+			if program == nil {
+				program = append([]int64(nil), f.program...)
+			}
+			if len(program) == 0 {
+				break
+			}
+			i := f.random.Intn(len(program))
+			switch f.random.Intn(3) {
+			case 0:
+				program[i] = f.interesting()
+			case 1:
+				program[i] += int64(f.random.Intn(33) - 16)
+			case 2: // change a parameter mode
+				program[i] += []int64{100, 1000, 10000}[f.random.Intn(3)] * int64(f.random.Intn(5)-2)
+			}
+		}
+	}
+
+	if len(input) > f.maxInputs {
+		input = input[:f.maxInputs]
+	}
+	return fuzzCase{input, program}
+}
+
+// run runs a case, adding it to the corpus if it increases the coverage and
+// saving it if it fails.
+func (f *fuzzer) run(c fuzzCase) {
+	f.runs++
+	program := f.programOf(c)
+	result := fuzzRun(program, c.Input, f.steps)
+
+	if result.Failed {
+		if f.found[result.Status] {
+			return
+		}
+		f.found[result.Status] = true
+		if strings.HasPrefix(result.Status, "stopped after") {
+			f.hangs++
+		} else {
+			f.crashes++
+		}
+		signature := result.Status
+		minimized := f.minimize(c, func(c fuzzCase) bool {
+			return fuzzRun(f.programOf(c), c.Input, f.steps).Status == signature
+		})
+		f.save("crashes", minimized, result.Message)
+		return
+	}
+
+	if f.variants {
+		if difference := divergence(program, c.Input, f.steps, result); difference != "" {
+			name := strings.SplitN(difference, ":", 2)[0]
+			if !f.found[name] {
+				// Differences are saved once per emulator, as the same
+				// bug tends to show in many outputs.
+				f.found[name] = true
+				f.divergences++
+				minimized := f.minimize(c, func(c fuzzCase) bool {
+					program := f.programOf(c)
+					difference := divergence(program, c.Input, f.steps, fuzzRun(program, c.Input, f.steps))
+					return strings.HasPrefix(difference, name+":")
+				})
+				program := f.programOf(minimized)
+				f.save("crashes", minimized, divergence(program, minimized.Input, f.steps, fuzzRun(program, minimized.Input, f.steps)))
+			}
+		}
+	}
+
+	var added bool
+	addEdge := func(edge int64) {
+		if !f.edges[edge] {
+			f.edges[edge] = true
+			added = true
+		}
+	}
+	for address := range result.Coverage.Executed {
+		addEdge(address * 3)
+	}
+	for address, branch := range result.Coverage.Branches {
+		if branch.Taken > 0 {
+			addEdge(address*3 + 1)
+		}
+		if branch.NotTaken > 0 {
+			addEdge(address*3 + 2)
+		}
+	}
+	if added {
+		f.corpus = append(f.corpus, c)
+		f.save("corpus", c, "")
+	}
+}
+
+// minimize makes the case as small as it can while it still fails the test:
+// it drops input values, moves the rest towards zero and undoes changes to
+// the program.
+func (f *fuzzer) minimize(c fuzzCase, fails func(fuzzCase) bool) fuzzCase {
+	try := func(candidate fuzzCase) bool {
+		if fails(candidate) {
+			c = candidate
+			return true
+		}
+		return false
+	}
+
+	for size := len(c.Input) / 2; size >= 1; size /= 2 {
+		for i := 0; i+size <= len(c.Input); {
+			input := append(append([]int64(nil), c.Input[:i]...), c.Input[i+size:]...)
+			if !try(fuzzCase{input, c.Program}) {
+				i += size
+			}
+		}
+	}
+
+	for i := range c.Input {
+		for c.Input[i] != 0 {
+			input := append([]int64(nil), c.Input...)
+			input[i] = 0
+			if try(fuzzCase{input, c.Program}) {
+				break
+			}
+			input[i] = c.Input[i] / 2
+			if !try(fuzzCase{input, c.Program}) {
+				break
+			}
+		}
+	}
+
+	if c.Program != nil {
+		for i := range c.Program {
+			if i < len(f.program) && c.Program[i] != f.program[i] {
+				program := append([]int64(nil), c.Program...)
+				program[i] = f.program[i]
+				try(fuzzCase{c.Input, program})
+			}
+		}
+	}
+	return c
+}
+
+// save writes a case to a file in the subdirectory, named by its contents.
+func (f *fuzzer) save(subdirectory string, c fuzzCase, comment string) {
+	if f.dir == "" {
+		if comment != "" {
+			fmt.Printf("%s\n  input: %s\n", comment, formatProgram(c.Input))
+		}
+		return
+	}
+
+	text := formatProgram(c.Input) + "\n"
+	if c.Program != nil {
+		text += formatProgram(c.Program) + "\n"
+	}
+	name := fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(text)))
+	if comment != "" {
+		text = "# " + comment + "\n" + text
+	}
+
+	directory := filepath.Join(f.dir, subdirectory)
+	check(os.MkdirAll(directory, 0755))
+	filename := filepath.Join(directory, name+".txt")
+	check(ioutil.WriteFile(filename, []byte(text), 0644))
+	if comment != "" {
+		fmt.Printf("%s\n  saved to %s\n", comment, filename)
+	}
+}
+
+// parseFuzzCase parses a case file.
+func parseFuzzCase(text string) fuzzCase {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	var c fuzzCase
+	if len(lines) > 0 {
+		c.Input = parseProgram(lines[0])
+	}
+	if len(lines) > 1 && strings.TrimSpace(lines[1]) != "" {
+		c.Program = parseProgram(lines[1])
+	}
+	return c
+}
+
+func fuzzCommand(args []string) {
+	flags := flag.NewFlagSet("fuzz", flag.ExitOnError)
+	inputFlag := flags.String("input", "", "comma-separated input to start from")
+	dirFlag := flags.String("dir", "", "keep the corpus and crashes in this directory")
+	runsFlag := flags.Int("runs", 100000, "number of runs")
+	durationFlag := flags.Duration("duration", 0, "stop after this long instead")
+	stepsFlag := flags.Int("steps", 100000, "instructions after which a run is considered hung")
+	inputsFlag := flags.Int("inputs", 64, "maximum number of input values")
+	asciiFlag := flags.Bool("ascii", false, "generate printable ASCII input")
+	programsFlag := flags.Bool("programs", false, "mutate the program as well")
+	variantsFlag := flags.Bool("variants", false, "compare with the emulators of the puzzles")
+	seedFlag := flags.Int64("seed", 1, "seed for the random mutations")
+	patches := &patchFlags{}
+	patches.register(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode fuzz [-input values] [-dir dir] [-runs n] [-duration d] [-steps n] [-inputs n] [-ascii] [-programs] [-variants] [-seed n] [-poke address=value] [-patch file[:name]] program")
+		os.Exit(2)
+	}
+
+	f := &fuzzer{
+		program:   loadPatchedProgram(flags.Arg(0), patches),
+		edges:     make(map[int64]bool),
+		found:     make(map[string]bool),
+		random:    rand.New(rand.NewSource(*seedFlag)),
+		dir:       *dirFlag,
+		steps:     *stepsFlag,
+		maxInputs: *inputsFlag,
+		ascii:     *asciiFlag,
+		programs:  *programsFlag,
+		variants:  *variantsFlag,
+	}
+
+	seeds := []fuzzCase{{Input: parseProgram(*inputFlag)}}
+	if f.dir != "" {
+		filenames, _ := filepath.Glob(filepath.Join(f.dir, "corpus", "*.txt"))
+		for _, filename := range filenames {
+			seeds = append(seeds, parseFuzzCase(readFile(filename)))
+		}
+	}
+	for _, seed := range seeds {
+		f.run(seed)
+	}
+	if len(f.corpus) == 0 {
+		f.corpus = seeds[:1]
+	}
+
+	report := func() {
+		fmt.Printf("runs %d, corpus %d, edges %d, crashes %d, hangs %d, divergences %d\n", f.runs, len(f.corpus), len(f.edges), f.crashes, f.hangs, f.divergences)
+	}
+	start, lastReport := time.Now(), time.Now()
+	for {
+		if *durationFlag > 0 && time.Since(start) > *durationFlag || *durationFlag == 0 && f.runs >= *runsFlag {
+			break
+		}
+		f.run(f.mutate(f.corpus[f.random.Intn(len(f.corpus))]))
+
+		if time.Since(lastReport) > 5*time.Second {
+			report()
+			lastReport = time.Now()
+		}
+	}
+	report()
+}
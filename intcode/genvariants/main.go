@@ -0,0 +1,342 @@
+// Command genvariants writes variants_days.go, the copies of the emulators of
+// the puzzles that the toolbox checks (see variants.go). Every copy is taken
+// from the main.go of its day together with the declarations it uses, with
+// the names of the day suffixed (emulate becomes emulateDay21, Direction
+// day15Direction), and with a limit on the number of steps added to the loop
+// of the emulator. Run it from the intcode directory, with `go generate
+// variants.go`; with -check it writes nothing, and fails if variants_days.go
+// is not what it would write, i.e. if a day changed since.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A day names the declarations to copy, functions, types or methods as
+// Type.Method, and the function that gets a step limit.
+type day struct {
+	Dir     string
+	Roots   []string
+	Stepped string
+}
+
+var days = []day{
+	{"day05", []string{"emulateWithTrace"}, "emulateWithTrace"},
+	{"day07", []string{"emulate"}, "emulate"},
+	{"day09", []string{"emulateWithTrace"}, "emulateWithTrace"},
+	{"day11", []string{"emulate", "makeBatchedMachine", "BatchedMachine.Send", "BatchedMachine.Receive"}, "emulate"},
+	{"day13", []string{"emulate", "makeBatchedMachine", "BatchedMachine.Send", "BatchedMachine.Receive"}, "emulate"},
+	{"day15", []string{"emulate"}, "emulate"},
+	{"day17", []string{"emulate", "makeBatchedMachine", "BatchedMachine.Send", "BatchedMachine.Receive"}, "emulate"},
+	{"day19", []string{"makeMachine", "Machine.Run"}, "Machine.Run"},
+	{"day21", []string{"emulate"}, "emulate"},
+	{"day23", []string{"emulate"}, "emulate"},
+	{"day25", []string{"makeEmulator", "emulate"}, "emulate"},
+}
+
+const output = "variants_days.go"
+
+var checkFlag = flag.Bool("check", false, "fail if "+output+" differs from the days, instead of writing it")
+
+func main() {
+	flag.Parse()
+
+	var body bytes.Buffer
+	imports := make(map[string]bool)
+	for _, d := range days {
+		check(d.generate(&body, imports))
+	}
+
+	var paths []string
+	for path := range imports {
+		paths = append(paths, strconv.Quote(path))
+	}
+	sort.Strings(paths)
+
+	var source bytes.Buffer
+	fmt.Fprintf(&source, "// Code generated by genvariants/main.go from the emulators in day05 to day25; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&source, "package main\n\nimport (\n%s\n)\n", strings.Join(paths, "\n"))
+	source.Write(body.Bytes())
+
+	formatted, err := format.Source(source.Bytes())
+	check(err)
+
+	if !*checkFlag {
+		check(ioutil.WriteFile(output, formatted, 0644))
+		return
+	}
+
+	current, err := ioutil.ReadFile(output)
+	check(err)
+	if !bytes.Equal(current, formatted) {
+		fmt.Fprintf(os.Stderr, "%s is out of date with the days, run `go generate variants.go`\n", output)
+		os.Exit(1)
+	}
+}
+
+// generate writes the copy of the emulator of the day, and adds the packages
+// it uses to imports.
+func (d day) generate(w *bytes.Buffer, imports map[string]bool) error {
+	fset := token.NewFileSet()
+	filename := filepath.Join("..", d.Dir, "main.go")
+	file, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+
+	info := &types.Info{
+		Defs: make(map[*ast.Ident]types.Object),
+		Uses: make(map[*ast.Ident]types.Object),
+	}
+	config := types.Config{Importer: importer.Default()}
+	pkg, err := config.Check(d.Dir, fset, []*ast.File{file}, info)
+	if err != nil {
+		return err
+	}
+
+	// The declaration of every object of the package.
+	decls := make(map[types.Object]ast.Decl)
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			decls[info.Defs[decl.Name]] = decl
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					decls[info.Defs[spec.Name]] = decl
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						decls[info.Defs[name]] = decl
+					}
+				}
+			}
+		}
+	}
+
+	lookup := func(name string) (types.Object, error) {
+		var obj types.Object
+		if i := strings.Index(name, "."); i >= 0 {
+			if typ := pkg.Scope().Lookup(name[:i]); typ != nil {
+				obj, _, _ = types.LookupFieldOrMethod(typ.Type(), true, pkg, name[i+1:])
+			}
+		} else {
+			obj = pkg.Scope().Lookup(name)
+		}
+		if obj == nil || decls[obj] == nil {
+			return nil, fmt.Errorf("%s: no declaration of %s", filename, name)
+		}
+		return obj, nil
+	}
+
+	// Copy the roots, and what they use.
+	copied := make(map[ast.Decl]bool)
+	renamed := make(map[types.Object]string)
+	var queue []types.Object
+	for _, root := range d.Roots {
+		obj, err := lookup(root)
+		if err != nil {
+			return err
+		}
+		queue = append(queue, obj)
+	}
+	for len(queue) > 0 || implementations(pkg, renamed, &queue) {
+		decl := decls[queue[0]]
+		queue = queue[1:]
+		if copied[decl] {
+			continue
+		}
+		copied[decl] = true
+
+		ast.Inspect(decl, func(node ast.Node) bool {
+			id, ok := node.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			obj := info.Defs[id]
+			if obj == nil {
+				obj = info.Uses[id]
+			}
+			if obj == nil || decls[obj] == nil {
+				if name, ok := obj.(*types.PkgName); ok {
+					imports[name.Imported().Path()] = true
+				}
+				return true
+			}
+			if obj.Parent() == pkg.Scope() {
+				renamed[obj] = d.rename(obj)
+			}
+			queue = append(queue, obj)
+			return true
+		})
+	}
+
+	for _, decl := range file.Decls {
+		if !copied[decl] {
+			continue
+		}
+		ast.Inspect(decl, func(node ast.Node) bool {
+			if id, ok := node.(*ast.Ident); ok {
+				if name, ok := renamed[info.Defs[id]]; ok {
+					id.Name = name
+				} else if name, ok := renamed[info.Uses[id]]; ok {
+					id.Name = name
+				}
+			}
+			return true
+		})
+	}
+
+	stepped, err := lookup(d.Stepped)
+	if err != nil {
+		return err
+	}
+	if err := limitSteps(decls[stepped].(*ast.FuncDecl), info); err != nil {
+		return fmt.Errorf("%s: %s: %v", filename, d.Stepped, err)
+	}
+
+	fmt.Fprintf(w, "\n// %s/main.go\n", d.Dir)
+	for _, decl := range file.Decls {
+		if !copied[decl] {
+			continue
+		}
+		w.WriteString("\n")
+		if err := printer.Fprint(w, fset, &printer.CommentedNode{Node: decl, Comments: file.Comments}); err != nil {
+			return err
+		}
+		w.WriteString("\n")
+	}
+	return nil
+}
+
+// implementations adds to the queue the methods by which the copied types
+// implement the copied interfaces, as calls through an interface do not use
+// them by name, and reports whether it added any.
+func implementations(pkg *types.Package, copied map[types.Object]string, queue *[]types.Object) bool {
+	var interfaces []*types.Interface
+	var named []types.Type
+	for obj := range copied {
+		if _, ok := obj.(*types.TypeName); !ok {
+			continue
+		}
+		if iface, ok := obj.Type().Underlying().(*types.Interface); ok {
+			interfaces = append(interfaces, iface)
+		} else {
+			named = append(named, obj.Type(), types.NewPointer(obj.Type()))
+		}
+	}
+
+	added := false
+	for _, iface := range interfaces {
+		for _, typ := range named {
+			if !types.Implements(typ, iface) {
+				continue
+			}
+			for i := 0; i < iface.NumMethods(); i++ {
+				method, _, _ := types.LookupFieldOrMethod(typ, true, pkg, iface.Method(i).Name())
+				if _, ok := copied[method]; !ok {
+					copied[method] = method.Name()
+					*queue = append(*queue, method)
+					added = true
+				}
+			}
+		}
+	}
+	return added
+}
+
+// rename returns the name of the copy of a package-level object of the day:
+// functions get the day as a suffix, e.g. emulateDay11, and types, constants
+// and variables as a prefix, e.g. day11BatchedMachine.
+func (d day) rename(obj types.Object) string {
+	suffix := strings.ToUpper(d.Dir[:1]) + d.Dir[1:]
+	if _, ok := obj.(*types.Func); ok {
+		return obj.Name() + suffix
+	}
+	name := []rune(obj.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return d.Dir + string(name)
+}
+
+// limitSteps adds a parameter steps to the function, before a variadic one,
+// and makes its loop panic once it ran that many times.
+func limitSteps(fn *ast.FuncDecl, info *types.Info) error {
+	for id, obj := range info.Defs {
+		if obj != nil && (id.Name == "step" || id.Name == "steps") && fn.Pos() <= id.Pos() && id.Pos() < fn.End() {
+			return fmt.Errorf("%s is declared already", id.Name)
+		}
+	}
+
+	var loop *ast.ForStmt
+	for _, stmt := range fn.Body.List {
+		if stmt, ok := stmt.(*ast.ForStmt); ok && stmt.Init == nil && stmt.Cond == nil && stmt.Post == nil {
+			if loop != nil {
+				return fmt.Errorf("more than one loop")
+			}
+			loop = stmt
+		}
+	}
+	if loop == nil {
+		return fmt.Errorf("no loop")
+	}
+
+	// The new nodes are placed at the closing parenthesis of the parameters
+	// and at the loop, for the comments around them to stay in place.
+	at := func(pos token.Pos, name string) *ast.Ident {
+		return &ast.Ident{NamePos: pos, Name: name}
+	}
+
+	params := fn.Type.Params.List
+	closing := fn.Type.Params.Closing
+	param := &ast.Field{Names: []*ast.Ident{at(closing, "steps")}, Type: at(closing, "int")}
+	i := len(params)
+	if i > 0 {
+		if _, ok := params[i-1].Type.(*ast.Ellipsis); ok {
+			i--
+		}
+	}
+	fn.Type.Params.List = append(params[:i:i], append([]*ast.Field{param}, params[i:]...)...)
+
+	pos := loop.Body.Lbrace
+	loop.Init = &ast.AssignStmt{
+		Lhs:    []ast.Expr{at(loop.For, "step")},
+		TokPos: loop.For,
+		Tok:    token.DEFINE,
+		Rhs:    []ast.Expr{&ast.BasicLit{ValuePos: loop.For, Kind: token.INT, Value: "0"}},
+	}
+	loop.Post = &ast.IncDecStmt{X: at(loop.For, "step"), TokPos: loop.For, Tok: token.INC}
+	limit := &ast.IfStmt{
+		If:   pos,
+		Cond: &ast.BinaryExpr{X: at(pos, "step"), OpPos: pos, Op: token.EQL, Y: at(pos, "steps")},
+		Body: &ast.BlockStmt{Lbrace: pos, Rbrace: pos, List: []ast.Stmt{&ast.ExprStmt{X: &ast.CallExpr{
+			Fun:    at(pos, "panic"),
+			Lparen: pos,
+			Args:   []ast.Expr{&ast.CallExpr{Fun: at(pos, "stepLimit"), Lparen: pos, Args: []ast.Expr{at(pos, "steps")}, Rparen: pos}},
+			Rparen: pos,
+		}}}},
+	}
+	loop.Body.List = append([]ast.Stmt{limit}, loop.Body.List...)
+	return nil
+}
+
+func check(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"sort"
+)
+
+// An access heatmap shows which addresses a program reads and writes over
+// time, to find where its data structures live: the screen of the arcade of
+// day 13, or the map of the repair droid of day 15. Time runs down the image
+// and addresses across it; reads are green, writes red, and both yellow.
+
+type Access struct {
+	Reads, Writes int64
+}
+
+// AccessLog counts the reads and writes of every address in periods of
+// Period steps. When there are more than MaxPeriods periods, neighbouring
+// periods are merged and the period doubles, so that a long run takes no more
+// memory than a short one.
+type AccessLog struct {
+	Steps      int64
+	Period     int64
+	MaxPeriods int
+	Periods    []map[int64]*Access
+}
+
+func makeAccessLog(maxPeriods int) *AccessLog {
+	return &AccessLog{Period: 1, MaxPeriods: maxPeriods}
+}
+
+func (log *AccessLog) access(address int64, write bool) {
+	period := int((log.Steps - 1) / log.Period)
+	for period >= log.MaxPeriods {
+		log.merge()
+		period = int((log.Steps - 1) / log.Period)
+	}
+	for len(log.Periods) <= period {
+		log.Periods = append(log.Periods, make(map[int64]*Access))
+	}
+
+	counts := log.Periods[period][address]
+	if counts == nil {
+		counts = &Access{}
+		log.Periods[period][address] = counts
+	}
+	if write {
+		counts.Writes++
+	} else {
+		counts.Reads++
+	}
+}
+
+// merge doubles the period, merging the counts of pairs of periods.
+func (log *AccessLog) merge() {
+	var merged []map[int64]*Access
+	for i := 0; i < len(log.Periods); i += 2 {
+		periods := log.Periods[i : i+1]
+		if i+1 < len(log.Periods) {
+			periods = log.Periods[i : i+2]
+		}
+		counts := make(map[int64]*Access)
+		for _, period := range periods {
+			for address, access := range period {
+				if counts[address] == nil {
+					counts[address] = &Access{}
+				}
+				counts[address].Reads += access.Reads
+				counts[address].Writes += access.Writes
+			}
+		}
+		merged = append(merged, counts)
+	}
+	log.Periods = merged
+	log.Period *= 2
+}
+
+// bounds returns the lowest and one past the highest address accessed.
+func (log *AccessLog) bounds() (low, high int64) {
+	low = math.MaxInt64
+	for _, period := range log.Periods {
+		for address := range period {
+			if address < low {
+				low = address
+			}
+			if address >= high {
+				high = address + 1
+			}
+		}
+	}
+	if low > high {
+		low = high
+	}
+	return low, high
+}
+
+// heatmap renders the accesses to addresses from low to high, with columns
+// of at most width pixels, each covering as many addresses as needed, and a
+// row per period. Intensities are logarithmic, so that rare accesses show.
+func (log *AccessLog) heatmap(low, high int64, width int) image.Image {
+	perColumn := (high - low + int64(width) - 1) / int64(width)
+	if perColumn < 1 {
+		perColumn = 1
+	}
+	columns := int((high - low + perColumn - 1) / perColumn)
+
+	cells := make([][]Access, len(log.Periods))
+	var most int64
+	for row, period := range log.Periods {
+		cells[row] = make([]Access, columns)
+		for address, access := range period {
+			if address < low || address >= high {
+				continue
+			}
+			cell := &cells[row][(address-low)/perColumn]
+			cell.Reads += access.Reads
+			cell.Writes += access.Writes
+			if cell.Reads > most {
+				most = cell.Reads
+			}
+			if cell.Writes > most {
+				most = cell.Writes
+			}
+		}
+	}
+
+	intensity := func(count int64) uint8 {
+		if count == 0 {
+			return 0
+		}
+		// Any access is visible.
+		return uint8(64 + 191*math.Log1p(float64(count))/math.Log1p(float64(most)))
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, columns, len(cells)))
+	for row := range cells {
+		for column, cell := range cells[row] {
+			img.Set(column, row, color.RGBA{R: intensity(cell.Writes), G: intensity(cell.Reads), A: 255})
+		}
+	}
+	return img
+}
+
+// writeCSV writes a line per period and address accessed in it.
+func (log *AccessLog) writeCSV(filename string) {
+	file, err := os.Create(filename)
+	check(err)
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	defer writer.Flush()
+
+	fmt.Fprintln(writer, "step,address,reads,writes")
+	for i, period := range log.Periods {
+		var addresses []int64
+		for address := range period {
+			addresses = append(addresses, address)
+		}
+		sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+		for _, address := range addresses {
+			fmt.Fprintf(writer, "%d,%d,%d,%d\n", int64(i)*log.Period, address, period[address].Reads, period[address].Writes)
+		}
+	}
+}
+
+func heatmapCommand(args []string) {
+	flags := flag.NewFlagSet("heatmap", flag.ExitOnError)
+	inputFlag := flags.String("input", "", "comma-separated input values")
+	textFlag := flags.String("text", "", "send the contents of this file as ASCII input, after the values")
+	cycleFlag := flags.String("cycle", "", "once the input runs out, send these comma-separated values over and over, e.g. joystick moves")
+	stepsFlag := flags.Int64("steps", 10000000, "stop after this many instructions")
+	pngFlag := flags.String("png", "", "write the heatmap to this PNG file")
+	csvFlag := flags.String("csv", "", "write the counts to this CSV file")
+	rowsFlag := flags.Int("rows", 512, "most rows of the heatmap, each a period of time")
+	widthFlag := flags.Int("width", 2048, "most columns of the heatmap")
+	fromFlag := flags.Int64("from", -1, "first address to show (default: the lowest accessed)")
+	toFlag := flags.Int64("to", -1, "address to stop at, or -1 for after the highest accessed")
+	patches := &patchFlags{}
+	patches.register(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || (*pngFlag == "" && *csvFlag == "") {
+		fmt.Fprintln(os.Stderr, "usage: intcode heatmap [-input values] [-text file] [-cycle values] [-steps n] [-png file] [-csv file] [-rows n] [-width n] [-from address] [-to address] [-poke address=value] [-patch file[:name]] program")
+		os.Exit(2)
+	}
+
+	var input, cycle []int64
+	if *inputFlag != "" {
+		input = parseProgram(*inputFlag)
+	}
+	if *textFlag != "" {
+		for _, char := range readFile(*textFlag) + "\n" {
+			input = append(input, int64(char))
+		}
+	}
+	if *cycleFlag != "" {
+		cycle = parseProgram(*cycleFlag)
+	}
+
+	emulator := makeEmulator(loadPatchedProgram(flags.Arg(0), patches), input...)
+	emulator.accesses = makeAccessLog(*rowsFlag)
+	emulator.singleStep = true
+
+	stopped := "halted"
+	for next := 0; ; {
+		_, status := emulate(emulator)
+		if status == EmulatorStatusHalted {
+			break
+		}
+		if emulator.accesses.Steps >= *stepsFlag {
+			stopped = "reached the step limit"
+			break
+		}
+		if status == EmulatorStatusWaitingForInput {
+			if len(cycle) == 0 {
+				stopped = "waiting for input"
+				break
+			}
+			emulator.input = append(emulator.input, cycle[next%len(cycle)])
+			next++
+		}
+	}
+
+	log := emulator.accesses
+	low, high := log.bounds()
+	if *fromFlag >= 0 {
+		low = *fromFlag
+	}
+	if *toFlag >= 0 {
+		high = *toFlag
+	}
+	fmt.Fprintf(os.Stderr, "intcode: %s after %d steps; %d periods of %d steps, addresses %d to %d\n",
+		stopped, log.Steps, len(log.Periods), log.Period, low, high-1)
+
+	if *csvFlag != "" {
+		log.writeCSV(*csvFlag)
+	}
+	if *pngFlag != "" {
+		if high <= low || len(log.Periods) == 0 {
+			fmt.Fprintln(os.Stderr, "intcode: no memory accesses to show")
+			os.Exit(1)
+		}
+		file, err := os.Create(*pngFlag)
+		check(err)
+		check(png.Encode(file, log.heatmap(low, high, *widthFlag)))
+		check(file.Close())
+	}
+}
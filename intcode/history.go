@@ -0,0 +1,80 @@
+package main
+
+// History is an undo log of the instructions an emulator executed, so that
+// execution can be stepped backwards. Each instruction writes at most one
+// word, so a step records the registers before the instruction, the word it
+// overwrote and the input it consumed.
+type History struct {
+	Steps []Step
+}
+
+type Step struct {
+	IP, RelativeBase int64
+	MemorySize       int // memory grows on writes past its end
+
+	Wrote        bool
+	Address, Old int64
+	Consumed     bool
+	Input        int64
+	Output       bool
+}
+
+// record is called before an instruction is executed.
+func (history *History) record(emulator *Emulator, instruction int64) {
+	step := Step{
+		IP:           emulator.ip,
+		RelativeBase: emulator.relativeBase,
+		MemorySize:   len(emulator.memory),
+	}
+
+	opcode := instruction % 100
+	if i := writtenParameter(opcode); i >= 0 && emulator.ip+int64(i)+1 < int64(len(emulator.memory)) {
+		offset := int64(i) + 1
+		parameter := emulator.memory[emulator.ip+offset]
+		switch instruction / pow(10, offset+1) % 10 {
+		case 0: // position mode
+			step.Wrote, step.Address = true, parameter
+		case 2: // relative mode
+			step.Wrote, step.Address = true, emulator.relativeBase+parameter
+		}
+		if step.Wrote && step.Address >= 0 && step.Address < int64(len(emulator.memory)) {
+			step.Old = emulator.memory[step.Address]
+		}
+	}
+	step.Output = opcode == OpOutput
+
+	history.Steps = append(history.Steps, step)
+}
+
+// consumed records the input value read by the current instruction.
+func (history *History) consumed(value int64) {
+	step := &history.Steps[len(history.Steps)-1]
+	step.Consumed, step.Input = true, value
+}
+
+// Undo reverts the last instruction executed and returns its step. It reports
+// false if there is nothing to undo.
+func (history *History) Undo(emulator *Emulator) (Step, bool) {
+	if len(history.Steps) == 0 {
+		return Step{}, false
+	}
+
+	step := history.Steps[len(history.Steps)-1]
+	history.Steps = history.Steps[:len(history.Steps)-1]
+
+	if step.Wrote && step.Address >= 0 && step.Address < int64(len(emulator.memory)) {
+		emulator.memory[step.Address] = step.Old
+	}
+	if len(emulator.memory) > step.MemorySize {
+		emulator.memory = emulator.memory[:step.MemorySize]
+	}
+	if step.Consumed {
+		emulator.input = append([]int64{step.Input}, emulator.input...)
+	}
+	emulator.ip, emulator.relativeBase = step.IP, step.RelativeBase
+	if emulator.calls != nil {
+		emulator.calls.rewind(len(history.Steps))
+	}
+
+	return step, true
+}
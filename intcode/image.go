@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"sort"
+)
+
+// Programs can be stored as binary images instead of comma-separated text.
+// An image is laid out as follows, with all numbers as varints:
+//
+//	magic     "INTC"
+//	version   1 byte
+//	flags     1 byte, imageCompressed if the words are zlib-compressed
+//	metadata  count, then count pairs of length-prefixed key and value
+//	words     length of the section, then the word count and the words as
+//	          zigzag varints, compressed if the flag is set
+//	checksum  CRC-32 (IEEE) of everything before it, 4 bytes big endian
+//
+// Metadata is free-form; the pack command sets "name", "notes" (e.g. how the
+// program is entered), "protocol" (the expected input and output) and
+// "source" (where the program came from).
+
+const (
+	imageMagic   = "INTC"
+	imageVersion = 1
+
+	imageCompressed = 1
+)
+
+type Image struct {
+	Program    []int64
+	Metadata   map[string]string
+	Compressed bool
+}
+
+// isImage reports whether data starts like a binary image.
+func isImage(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(imageMagic))
+}
+
+func encodeImage(image Image) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString(imageMagic)
+	buffer.WriteByte(imageVersion)
+
+	var flags byte
+	if image.Compressed {
+		flags |= imageCompressed
+	}
+	buffer.WriteByte(flags)
+
+	var scratch [binary.MaxVarintLen64]byte
+	writeUvarint := func(buffer *bytes.Buffer, value uint64) {
+		buffer.Write(scratch[:binary.PutUvarint(scratch[:], value)])
+	}
+	writeString := func(s string) {
+		writeUvarint(&buffer, uint64(len(s)))
+		buffer.WriteString(s)
+	}
+
+	// Keys are sorted, so that the same image always encodes the same.
+	var keys []string
+	for key := range image.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	writeUvarint(&buffer, uint64(len(keys)))
+	for _, key := range keys {
+		writeString(key)
+		writeString(image.Metadata[key])
+	}
+
+	var words bytes.Buffer
+	writeUvarint(&words, uint64(len(image.Program)))
+	for _, word := range image.Program {
+		words.Write(scratch[:binary.PutVarint(scratch[:], word)])
+	}
+	if image.Compressed {
+		var compressed bytes.Buffer
+		writer := zlib.NewWriter(&compressed)
+		writer.Write(words.Bytes())
+		check(writer.Close())
+		words = compressed
+	}
+	writeUvarint(&buffer, uint64(words.Len()))
+	buffer.Write(words.Bytes())
+
+	var checksum [4]byte
+	binary.BigEndian.PutUint32(checksum[:], crc32.ChecksumIEEE(buffer.Bytes()))
+	buffer.Write(checksum[:])
+
+	return buffer.Bytes()
+}
+
+var errTruncatedImage = errors.New("image is truncated")
+
+func decodeImage(data []byte) (Image, error) {
+	if !isImage(data) {
+		return Image{}, errors.New("not an Intcode image")
+	}
+	if len(data) < len(imageMagic)+2+4 {
+		return Image{}, errTruncatedImage
+	}
+
+	body, checksum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != checksum {
+		return Image{}, errors.New("image checksum mismatch")
+	}
+
+	version, flags := body[len(imageMagic)], body[len(imageMagic)+1]
+	if version != imageVersion {
+		return Image{}, fmt.Errorf("unsupported image version %d", version)
+	}
+	image := Image{Metadata: make(map[string]string), Compressed: flags&imageCompressed != 0}
+
+	reader := bytes.NewReader(body[len(imageMagic)+2:])
+	readUvarint := func(reader *bytes.Reader) uint64 {
+		value, err := binary.ReadUvarint(reader)
+		if err != nil {
+			panic(errTruncatedImage)
+		}
+		return value
+	}
+	readBytes := func() []byte {
+		length := readUvarint(reader)
+		if length > uint64(reader.Len()) {
+			panic(errTruncatedImage)
+		}
+		value := make([]byte, length)
+		reader.Read(value)
+		return value
+	}
+
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = r.(error)
+			}
+		}()
+
+		for count := readUvarint(reader); count > 0; count-- {
+			key := string(readBytes())
+			image.Metadata[key] = string(readBytes())
+		}
+
+		words := readBytes()
+		if image.Compressed {
+			zreader, zerr := zlib.NewReader(bytes.NewReader(words))
+			if zerr == nil {
+				words, zerr = ioutil.ReadAll(zreader)
+			}
+			if zerr != nil {
+				panic(fmt.Errorf("image words: %v", zerr))
+			}
+		}
+
+		wordReader := bytes.NewReader(words)
+		count := readUvarint(wordReader)
+		if count > uint64(len(words)) {
+			panic(errTruncatedImage)
+		}
+		image.Program = make([]int64, count)
+		for i := range image.Program {
+			word, verr := binary.ReadVarint(wordReader)
+			if verr != nil {
+				panic(errTruncatedImage)
+			}
+			image.Program[i] = word
+		}
+	}()
+
+	return image, err
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The drivers of the puzzles, the code running a program as a device like the
+// robot of day 11, the arcade of day 13 or the droid of day 15, talk to it
+// through a Machine. Besides the emulator, a FakeMachine can play the part of
+// the program: it plays back a script of the output the program writes and
+// checks the input the driver sends, so that a driver can be tried on a small
+// scenario written by hand. Days 11, 13 and 15 have a copy of Machine and
+// FakeMachine, and `run -record` writes a run as a script.
+//
+// A script has a step per line, "#" starts a comment:
+//
+//	# The robot of day 11 paints the panel white, and turns left.
+//	in 0
+//	out 1,0
+//	in
+//	halt
+//
+// "out values" writes the values, "in value" waits for the driver to send
+// that value, or any value without one, and "halt" halts. A script may end
+// without halting, as if the program waited for input forever.
+
+type Machine interface {
+	// Send gives the machine an input value.
+	Send(value int64)
+
+	// Receive runs the machine until it writes an output value, waits for
+	// input or halts.
+	Receive() Message
+}
+
+const (
+	MessageWaitingForInput = iota
+	MessageOutput
+	MessageHalt
+)
+
+type Message struct {
+	Kind  int
+	Value int64
+}
+
+type FakeStep struct {
+	Line  int
+	Kind  int // MessageWaitingForInput for an input
+	Value int64
+	Any   bool // any input value
+}
+
+// A FakeMachine panics if the driver sends input other than the script
+// expects, or goes past its end, with an error naming the line of the step.
+type FakeMachine struct {
+	Filename string
+	Steps    []FakeStep
+	next     int
+}
+
+func (fake *FakeMachine) fail(format string, args ...interface{}) {
+	line := 0
+	if fake.next < len(fake.Steps) {
+		line = fake.Steps[fake.next].Line
+	} else if len(fake.Steps) > 0 {
+		line = fake.Steps[len(fake.Steps)-1].Line
+	}
+	panic(fmt.Errorf("fake machine: %s:%d: %s", fake.Filename, line, fmt.Sprintf(format, args...)))
+}
+
+func (fake *FakeMachine) Send(value int64) {
+	if fake.next == len(fake.Steps) {
+		fake.fail("sent %d after the end of the script", value)
+	}
+	step := fake.Steps[fake.next]
+	switch {
+	case step.Kind == MessageOutput:
+		fake.fail("sent %d, but the program writes %d first", value, step.Value)
+	case step.Kind == MessageHalt:
+		fake.fail("sent %d, but the program halted", value)
+	case !step.Any && value != step.Value:
+		fake.fail("sent %d, expected %d", value, step.Value)
+	}
+	fake.next++
+}
+
+func (fake *FakeMachine) Receive() Message {
+	if fake.next == len(fake.Steps) {
+		fake.fail("received after the end of the script")
+	}
+	step := fake.Steps[fake.next]
+	if step.Kind == MessageOutput {
+		fake.next++
+	}
+	return Message{Kind: step.Kind, Value: step.Value}
+}
+
+// Done returns an error if the driver stopped before the end of the script.
+func (fake *FakeMachine) Done() error {
+	if fake.next < len(fake.Steps) && fake.Steps[fake.next].Kind != MessageHalt {
+		return fmt.Errorf("fake machine: %s:%d: the driver stopped here", fake.Filename, fake.Steps[fake.next].Line)
+	}
+	return nil
+}
+
+// parseFakeMachine parses a script.
+func parseFakeMachine(filename, text string) (*FakeMachine, error) {
+	fake := &FakeMachine{Filename: filename}
+
+	for i, line := range strings.Split(text, "\n") {
+		if j := strings.Index(line, "#"); j >= 0 {
+			line = line[:j]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		errorf := func(format string, args ...interface{}) (*FakeMachine, error) {
+			return nil, fmt.Errorf("%s:%d: %s", filename, i+1, fmt.Sprintf(format, args...))
+		}
+
+		var values []int64
+		if len(fields) > 1 {
+			for _, field := range strings.Split(strings.Join(fields[1:], ""), ",") {
+				value, err := strconv.ParseInt(field, 10, 64)
+				if err != nil {
+					return errorf("invalid value %q", field)
+				}
+				values = append(values, value)
+			}
+		}
+
+		switch fields[0] {
+		case "out":
+			if len(values) == 0 {
+				return errorf("expected out values")
+			}
+			for _, value := range values {
+				fake.Steps = append(fake.Steps, FakeStep{Line: i + 1, Kind: MessageOutput, Value: value})
+			}
+
+		case "in":
+			if len(values) > 1 {
+				return errorf("expected in [value]")
+			}
+			step := FakeStep{Line: i + 1, Kind: MessageWaitingForInput, Any: len(values) == 0}
+			if len(values) == 1 {
+				step.Value = values[0]
+			}
+			fake.Steps = append(fake.Steps, step)
+
+		case "halt":
+			if len(values) > 0 {
+				return errorf("expected halt")
+			}
+			fake.Steps = append(fake.Steps, FakeStep{Line: i + 1, Kind: MessageHalt})
+
+		default:
+			return errorf("unknown step %q", fields[0])
+		}
+	}
+
+	return fake, nil
+}
+
+// formatFakeSteps writes steps as a script, with the output values written
+// between two inputs on one line.
+func formatFakeSteps(steps []FakeStep) string {
+	var builder strings.Builder
+	for i, step := range steps {
+		switch {
+		case step.Kind == MessageOutput && i > 0 && steps[i-1].Kind == MessageOutput:
+			fmt.Fprintf(&builder, ",%d", step.Value)
+		case step.Kind == MessageOutput:
+			fmt.Fprintf(&builder, "out %d", step.Value)
+		case step.Kind == MessageHalt:
+			builder.WriteString("halt")
+		case step.Any:
+			builder.WriteString("in")
+		default:
+			fmt.Fprintf(&builder, "in %d", step.Value)
+		}
+		if step.Kind != MessageOutput || i+1 == len(steps) || steps[i+1].Kind != MessageOutput {
+			builder.WriteString("\n")
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Command is a subcommand of the intcode tool.
+type Command struct {
+	Name  string
+	Usage string
+	Run   func(args []string)
+}
+
+var commands = []Command{
+	{"compile", "compile a source file to Intcode", compileCommand},
+	{"asm", "assemble a hand-written module to an object file", asmCommand},
+	{"link", "link object files into a program", linkCommand},
+	{"run", "run an Intcode program", runCommand},
+	{"batch", "run a program once for every line of input, concurrently", batchCommand},
+	{"debug", "step through an Intcode program, forwards and backwards", debugCommand},
+	{"dap", "serve the Debug Adapter Protocol for editors", dapCommand},
+	{"serve", "run programs for other tools over HTTP on localhost", serveCommand},
+	{"expect", "drive an ASCII program with a script of sends and expects", expectCommand},
+	{"dump", "show the memory of a machine, or save a snapshot of it", dumpCommand},
+	{"memdiff", "compare the memory of two machine snapshots", memdiffCommand},
+	{"heatmap", "render the memory reads and writes of a run over time", heatmapCommand},
+	{"cover", "report which instructions and branches runs of a program execute", coverCommand},
+	{"concolic", "search for inputs taking every path, or reaching an output", concolicCommand},
+	{"conformance", "check the emulators against programs with known results", conformanceCommand},
+	{"fuzz", "run a program on mutated input, looking for faults", fuzzCommand},
+	{"patch", "apply patches to a program and print it", patchCommand},
+	{"diff", "make a binary patch of the changes between two programs", diffCommand},
+	{"apply", "apply a binary patch to a program", applyCommand},
+	{"revert", "revert a binary patch from a program", revertCommand},
+	{"optimize", "fold constants, thread jumps and remove dead code", optimizeCommand},
+	{"pack", "convert a program to a binary image", packCommand},
+	{"unpack", "convert a binary image to text, or show its metadata", unpackCommand},
+	{"disasm", "disassemble the code of an Intcode program", disasmCommand},
+	{"decompile", "decompile an Intcode program to pseudo-code", decompileCommand},
+	{"strings", "list the text and data tables of a program", stringsCommand},
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: intcode <command> [arguments]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "commands:")
+		for _, command := range commands {
+			fmt.Fprintf(os.Stderr, "  %-10s %s\n", command.Name, command.Usage)
+		}
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	for _, command := range commands {
+		if command.Name == flag.Arg(0) {
+			command.Run(flag.Args()[1:])
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "intcode: unknown command %q\n", flag.Arg(0))
+	flag.Usage()
+	os.Exit(2)
+}
+
+func compileCommand(args []string) {
+	flags := flag.NewFlagSet("compile", flag.ExitOnError)
+	outputFlag := flags.String("o", "", "write the program to this file instead of stdout, as an image if it ends in .intc")
+	assemblyFlag := flags.Bool("S", false, "print the generated assembly instead of Intcode")
+	objectFlag := flags.Bool("c", false, "compile to an object file for the linker")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode compile [-o file] [-S] [-c] source")
+		os.Exit(2)
+	}
+
+	filename := flags.Arg(0)
+	compileFile := compile
+	if *objectFlag {
+		compileFile = compileObject
+	}
+	lines, err := compileFile(filename, readFile(filename))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var text string
+	if *assemblyFlag {
+		text = formatAssembly(lines)
+	} else if *objectFlag {
+		object, err := assembleObject(filepath.Base(filename), lines)
+		check(err)
+		text = formatObject(object)
+	} else {
+		program, _, err := assemble(lines)
+		check(err)
+		text = formatProgram(program) + "\n"
+
+		// Images record where the program came from.
+		if filepath.Ext(*outputFlag) == ".intc" {
+			text = string(encodeImage(Image{
+				Program:  program,
+				Metadata: map[string]string{"name": filepath.Base(filename), "source": "compiled from " + filename},
+			}))
+		}
+	}
+
+	if *outputFlag != "" {
+		check(ioutil.WriteFile(*outputFlag, []byte(text), 0644))
+	} else {
+		fmt.Print(text)
+	}
+}
+
+func asmCommand(args []string) {
+	flags := flag.NewFlagSet("asm", flag.ExitOnError)
+	outputFlag := flags.String("o", "", "write the object file to this file instead of stdout")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode asm [-o file] source")
+		os.Exit(2)
+	}
+
+	filename := flags.Arg(0)
+	lines, err := parseAssembly(filename, readFile(filename))
+	var object *Object
+	if err == nil {
+		if object, err = assembleObject(filepath.Base(filename), lines); err != nil {
+			err = fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "intcode:", err)
+		os.Exit(1)
+	}
+
+	text := formatObject(object)
+	if *outputFlag != "" {
+		check(ioutil.WriteFile(*outputFlag, []byte(text), 0644))
+	} else {
+		fmt.Print(text)
+	}
+}
+
+func linkCommand(args []string) {
+	flags := flag.NewFlagSet("link", flag.ExitOnError)
+	outputFlag := flags.String("o", "", "write the program to this file instead of stdout, as an image if it ends in .intc")
+	entryFlag := flags.String("entry", "__start", "the symbol the program starts at")
+	mapFlag := flags.String("map", "", "write the addresses of the symbols to this file")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: intcode link [-o file] [-entry symbol] [-map file] object...")
+		os.Exit(2)
+	}
+
+	var objects []*Object
+	for _, filename := range flags.Args() {
+		object, err := parseObject(readFile(filename))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "intcode: %s: %v\n", filename, err)
+			os.Exit(1)
+		}
+		objects = append(objects, object)
+	}
+
+	program, symbols, err := link(objects, *entryFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "intcode:", err)
+		os.Exit(1)
+	}
+
+	if *mapFlag != "" {
+		var names []string
+		for name := range symbols {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			return symbols[names[i]] < symbols[names[j]] || symbols[names[i]] == symbols[names[j]] && names[i] < names[j]
+		})
+		var builder strings.Builder
+		for _, name := range names {
+			fmt.Fprintf(&builder, "%d %s\n", symbols[name], name)
+		}
+		check(ioutil.WriteFile(*mapFlag, []byte(builder.String()), 0644))
+	}
+
+	text := formatProgram(program) + "\n"
+	if filepath.Ext(*outputFlag) == ".intc" {
+		var names []string
+		for _, object := range objects {
+			names = append(names, object.Name)
+		}
+		text = string(encodeImage(Image{
+			Program:  program,
+			Metadata: map[string]string{"source": "linked from " + strings.Join(names, ", ")},
+		}))
+	}
+	if *outputFlag != "" {
+		check(ioutil.WriteFile(*outputFlag, []byte(text), 0644))
+	} else {
+		fmt.Print(text)
+	}
+}
+
+func runCommand(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	asciiFlag := flags.Bool("ascii", false, "print output as text and send input lines as ASCII")
+	inputFlag := flags.String("input", "", "comma-separated input values, read before stdin")
+	frameFlag := flags.String("frame", "", "print output as records of the shape `name:field,...`, e.g. tile:x,y,id")
+	var sentinels stringsFlag
+	flags.Var(&sentinels, "sentinel", "records matching `name:field=value,...` are called name instead; may be repeated")
+	callsFlag := flags.Bool("calls", false, "trace calls and returns on stderr")
+	recordFlag := flags.String("record", "", "write the run to this file as a script for a fake machine")
+	patches := &patchFlags{}
+	patches.register(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode run [-ascii] [-input values] [-frame name:fields [-sentinel name:field=value,...]] [-calls] [-record file] [-poke address=value] [-patch file[:name]] program")
+		os.Exit(2)
+	}
+
+	program := loadPatchedProgram(flags.Arg(0), patches)
+
+	var framer *Framer
+	if *frameFlag != "" {
+		shape, err := parseRecordShape(*frameFlag)
+		for _, text := range sentinels {
+			var sentinel Sentinel
+			if err == nil {
+				sentinel, err = parseSentinel(text, shape)
+				shape.Sentinels = append(shape.Sentinels, sentinel)
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "intcode:", err)
+			os.Exit(2)
+		}
+		framer = makeFramer(shape)
+	}
+	endFrame := func(event string) {
+		if framer == nil {
+			return
+		}
+		if err := framer.End(event); err != nil {
+			fmt.Fprintln(os.Stderr, "intcode:", err)
+			os.Exit(1)
+		}
+	}
+
+	var input []int64
+	if *inputFlag != "" {
+		input = parseProgram(*inputFlag)
+	}
+
+	emulator := makeEmulator(program, input...)
+	emulator.calls = &CallStack{}
+	if *callsFlag {
+		emulator.calls.trace = os.Stderr
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// The recording lists the input values each event consumed before it.
+	var recorded []FakeStep
+	record := func(pending []int64, kind int, value int64) {
+		if *recordFlag == "" {
+			return
+		}
+		for _, input := range pending[:len(pending)-len(emulator.input)] {
+			recorded = append(recorded, FakeStep{Kind: MessageWaitingForInput, Value: input})
+		}
+		if kind != MessageWaitingForInput {
+			recorded = append(recorded, FakeStep{Kind: kind, Value: value})
+		}
+		if kind != MessageOutput {
+			check(ioutil.WriteFile(*recordFlag, []byte(formatFakeSteps(recorded)), 0644))
+		}
+	}
+
+	// A fault shows where the program was, and how it got there.
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "intcode: %v\n%s", r, emulator.calls.backtrace(emulator.ip, emulator.relativeBase))
+			os.Exit(1)
+		}
+	}()
+
+	for {
+		pending := emulator.input
+		value, status := emulate(emulator)
+		switch status {
+		case EmulatorStatusHalted:
+			record(pending, MessageHalt, 0)
+			endFrame("halted")
+			return
+
+		case EmulatorStatusOutput:
+			record(pending, MessageOutput, value)
+			if framer != nil {
+				if record, ok := framer.Push(value); ok {
+					fmt.Println(record)
+				}
+			} else if *asciiFlag && value >= 0 && value < 128 {
+				fmt.Print(string(rune(value)))
+			} else {
+				fmt.Println(value)
+			}
+
+		case EmulatorStatusWaitingForInput:
+			record(pending, MessageWaitingForInput, 0)
+			endFrame("waited for input")
+			if !scanner.Scan() {
+				fmt.Fprintln(os.Stderr, "intcode: program is waiting for input, but stdin is closed")
+				os.Exit(1)
+			}
+
+			if *asciiFlag {
+				emulator.WriteString(scanner.Text())
+				emulator.WriteString("\n")
+			} else {
+				emulator.input = append(emulator.input, parseProgram(scanner.Text())...)
+			}
+		}
+	}
+}
+
+func batchCommand(args []string) {
+	flags := flag.NewFlagSet("batch", flag.ExitOnError)
+	workersFlag := flags.Int("workers", runtime.NumCPU(), "number of programs to run at the same time")
+	patches := &patchFlags{}
+	patches.register(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode batch [-workers n] [-poke address=value] [-patch file[:name]] program < queries")
+		os.Exit(2)
+	}
+
+	pool := makePool(loadPatchedProgram(flags.Arg(0), patches), *workersFlag)
+
+	var inputs [][]int64
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		inputs = append(inputs, parseProgram(scanner.Text()))
+	}
+	check(scanner.Err())
+
+	outputs, err := pool.Batch(inputs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "intcode:", err)
+		os.Exit(1)
+	}
+	for _, output := range outputs {
+		fmt.Println(formatProgram(output))
+	}
+}
+
+func patchCommand(args []string) {
+	flags := flag.NewFlagSet("patch", flag.ExitOnError)
+	outputFlag := flags.String("o", "", "write the program to this file instead of stdout")
+	patches := &patchFlags{}
+	patches.register(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode patch [-o file] [-poke address=value] [-patch file[:name]] program")
+		os.Exit(2)
+	}
+
+	text := formatProgram(loadPatchedProgram(flags.Arg(0), patches)) + "\n"
+	if *outputFlag != "" {
+		check(ioutil.WriteFile(*outputFlag, []byte(text), 0644))
+	} else {
+		fmt.Print(text)
+	}
+}
+
+func packCommand(args []string) {
+	flags := flag.NewFlagSet("pack", flag.ExitOnError)
+	outputFlag := flags.String("o", "", "write the image to this file (default: program with .intc extension)")
+	compressFlag := flags.Bool("z", false, "compress the words")
+	nameFlag := flags.String("name", "", "name of the program")
+	notesFlag := flags.String("notes", "", "notes, e.g. on the entry point")
+	protocolFlag := flags.String("protocol", "", "the expected input and output")
+	sourceFlag := flags.String("source", "", "where the program came from")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode pack [-o file] [-z] [-name s] [-notes s] [-protocol s] [-source s] program")
+		os.Exit(2)
+	}
+
+	filename := flags.Arg(0)
+	image := loadImage(filename)
+	if image.Metadata == nil {
+		image.Metadata = make(map[string]string)
+	}
+	for key, value := range map[string]string{"name": *nameFlag, "notes": *notesFlag, "protocol": *protocolFlag, "source": *sourceFlag} {
+		if value != "" {
+			image.Metadata[key] = value
+		}
+	}
+	image.Compressed = *compressFlag
+
+	output := *outputFlag
+	if output == "" {
+		output = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".intc"
+	}
+	check(ioutil.WriteFile(output, encodeImage(image), 0644))
+}
+
+func unpackCommand(args []string) {
+	flags := flag.NewFlagSet("unpack", flag.ExitOnError)
+	outputFlag := flags.String("o", "", "write the program to this file instead of stdout")
+	infoFlag := flags.Bool("info", false, "show the metadata instead of the program")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode unpack [-o file] [-info] image")
+		os.Exit(2)
+	}
+
+	image := loadImage(flags.Arg(0))
+	if *infoFlag {
+		var keys []string
+		for key := range image.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Printf("words: %d\n", len(image.Program))
+		fmt.Printf("compressed: %v\n", image.Compressed)
+		for _, key := range keys {
+			fmt.Printf("%s: %s\n", key, image.Metadata[key])
+		}
+		return
+	}
+
+	text := formatProgram(image.Program) + "\n"
+	if *outputFlag != "" {
+		check(ioutil.WriteFile(*outputFlag, []byte(text), 0644))
+	} else {
+		fmt.Print(text)
+	}
+}
+
+func disasmCommand(args []string) {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode disasm program")
+		os.Exit(2)
+	}
+
+	fmt.Print(disassemble(loadProgram(flags.Arg(0))))
+}
+
+func decompileCommand(args []string) {
+	flags := flag.NewFlagSet("decompile", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode decompile program")
+		os.Exit(2)
+	}
+
+	fmt.Print(decompile(loadProgram(flags.Arg(0))))
+}
+
+// stringsFlag is a flag that may be repeated.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// loadProgram reads an Intcode program from a file, either as text or as a
+// binary image.
+func loadProgram(filename string) []int64 {
+	return loadImage(filename).Program
+}
+
+// loadPatchedProgram reads a program and applies the patches given on the
+// command line, exiting if they do not apply.
+func loadPatchedProgram(filename string, patches *patchFlags) []int64 {
+	program, err := patches.apply(loadProgram(filename))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "intcode:", err)
+		os.Exit(1)
+	}
+	return program
+}
+
+// loadImage reads a binary image, or a text program as an image without
+// metadata.
+func loadImage(filename string) Image {
+	data, err := ioutil.ReadFile(filename)
+	check(err)
+	if !isImage(data) {
+		return Image{Program: parseProgram(string(data))}
+	}
+
+	image, err := decodeImage(data)
+	if err != nil {
+		panic(fmt.Errorf("%s: %v", filename, err))
+	}
+	return image
+}
+
+// parseProgram parses comma-separated values. Whitespace around the values
+// is ignored, so programs may be wrapped over several lines.
+func parseProgram(text string) (program []int64) {
+	for _, value := range strings.Split(text, ",") {
+		for _, field := range strings.Fields(value) {
+			program = append(program, toInt64(field))
+		}
+	}
+	return program
+}
+
+func formatProgram(program []int64) string {
+	values := make([]string, len(program))
+	for i, value := range program {
+		values[i] = strconv.FormatInt(value, 10)
+	}
+	return strings.Join(values, ",")
+}
+
+func toInt64(s string) int64 {
+	result, err := strconv.ParseInt(s, 10, 64)
+	check(err)
+	return result
+}
+
+func readFile(filename string) string {
+	bytes, err := ioutil.ReadFile(filename)
+	check(err)
+	return strings.TrimSpace(string(bytes))
+}
+
+func check(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
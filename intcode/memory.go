@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Memory dumps show a machine's memory word by word, and memory diffs compare
+// two machine states, to find where a program keeps its state: the room of
+// day 25 before and after a move, or the screen of day 13 between frames.
+//
+// Machine states are saved as snapshots, which are text:
+//
+//	intcode snapshot
+//	ip 1234
+//	rb 2000
+//	memory 109,2000,...
+
+type Snapshot struct {
+	IP, RelativeBase int64
+	Memory           []int64
+}
+
+func takeSnapshot(emulator *Emulator) Snapshot {
+	return Snapshot{
+		IP:           emulator.ip,
+		RelativeBase: emulator.relativeBase,
+		Memory:       append([]int64(nil), emulator.memory...),
+	}
+}
+
+func formatSnapshot(snapshot Snapshot) string {
+	return fmt.Sprintf("intcode snapshot\nip %d\nrb %d\nmemory %s\n",
+		snapshot.IP, snapshot.RelativeBase, formatProgram(snapshot.Memory))
+}
+
+func isSnapshot(data []byte) bool {
+	return strings.HasPrefix(string(data), "intcode snapshot\n")
+}
+
+func parseSnapshot(text string) (Snapshot, error) {
+	var snapshot Snapshot
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if lines[0] != "intcode snapshot" || len(lines) != 4 {
+		return snapshot, errors.New("not an Intcode snapshot")
+	}
+
+	var err error
+	for _, line := range lines[1:] {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return snapshot, fmt.Errorf("invalid line %q", line)
+		}
+		switch fields[0] {
+		case "ip":
+			snapshot.IP, err = strconv.ParseInt(fields[1], 10, 64)
+		case "rb":
+			snapshot.RelativeBase, err = strconv.ParseInt(fields[1], 10, 64)
+		case "memory":
+			snapshot.Memory, err = parseValues(fields[1])
+		default:
+			err = fmt.Errorf("unknown field %q", fields[0])
+		}
+		if err != nil {
+			return snapshot, err
+		}
+	}
+	return snapshot, nil
+}
+
+// printable returns a word as a character, if it is one.
+func printable(word int64) string {
+	switch {
+	case word == '\n':
+		return `\n`
+	case word >= 32 && word < 127:
+		return string(rune(word))
+	}
+	return ""
+}
+
+// formatDump lists the words from start to end: their address, value, the
+// value as a character and, where the disassembler finds code, the
+// instruction. Runs of zeros are shortened. The word at ip is marked.
+func formatDump(snapshot Snapshot, start, end int64) string {
+	memory := snapshot.Memory
+	if end > int64(len(memory)) {
+		end = int64(len(memory))
+	}
+	code := analyze(memory).Code
+	if line, ok := decode(memory, snapshot.IP); ok {
+		code[snapshot.IP] = line
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "ip %d, rb %d, %d words\n", snapshot.IP, snapshot.RelativeBase, len(memory))
+	for address := start; address < end; address++ {
+		zeros := address
+		for zeros < end && memory[zeros] == 0 && code[zeros].Kind != LineInstruction && zeros != snapshot.IP {
+			zeros++
+		}
+		if zeros-address > 3 {
+			fmt.Fprintf(&builder, "         ...  %d zero words\n", zeros-address)
+			address = zeros - 1
+			continue
+		}
+
+		marker := " "
+		if address == snapshot.IP {
+			marker = ">"
+		}
+		var instruction string
+		if line, ok := code[address]; ok {
+			instruction = line.String()
+		}
+		line := fmt.Sprintf("%s%6d  %20d  %-2s  %s", marker, address, memory[address], printable(memory[address]), instruction)
+		builder.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return builder.String()
+}
+
+// formatMemoryDiff lists the words that differ between two states, with
+// their old and new values. Memory beyond the end of a state is zero.
+func formatMemoryDiff(old, new Snapshot) string {
+	var builder strings.Builder
+	if old.IP != new.IP {
+		fmt.Fprintf(&builder, "ip %d -> %d\n", old.IP, new.IP)
+	}
+	if old.RelativeBase != new.RelativeBase {
+		fmt.Fprintf(&builder, "rb %d -> %d\n", old.RelativeBase, new.RelativeBase)
+	}
+
+	word := func(memory []int64, address int) int64 {
+		if address < len(memory) {
+			return memory[address]
+		}
+		return 0
+	}
+
+	size := len(old.Memory)
+	if len(new.Memory) > size {
+		size = len(new.Memory)
+	}
+	var changes int
+	for address := 0; address < size; address++ {
+		a, b := word(old.Memory, address), word(new.Memory, address)
+		if a == b {
+			continue
+		}
+		changes++
+		line := fmt.Sprintf("%6d  %20d  %-2s -> %20d  %s", address, a, printable(a), b, printable(b))
+		builder.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	fmt.Fprintf(&builder, "%d words changed\n", changes)
+	return builder.String()
+}
+
+// machineState loads a snapshot, or runs a program until it waits for input
+// after all of the given input is read, halts, writes the given number of
+// outputs, or executes the given number of steps, which may be none.
+func machineState(filename string, patches *patchFlags, input []int64, outputs, steps int) Snapshot {
+	data, err := ioutil.ReadFile(filename)
+	check(err)
+	if isSnapshot(data) {
+		snapshot, err := parseSnapshot(string(data))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "intcode: %s: %v\n", filename, err)
+			os.Exit(1)
+		}
+		return snapshot
+	}
+
+	emulator := makeEmulator(loadPatchedProgram(filename, patches), input...)
+	emulator.singleStep = true
+	for step := 0; step != steps; step++ {
+		_, status := emulate(emulator)
+		if status == EmulatorStatusHalted || status == EmulatorStatusWaitingForInput {
+			break
+		}
+		if status == EmulatorStatusOutput {
+			if outputs--; outputs == 0 {
+				break
+			}
+		}
+	}
+	return takeSnapshot(emulator)
+}
+
+func dumpCommand(args []string) {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	inputFlag := flags.String("input", "", "comma-separated input values")
+	textFlag := flags.String("text", "", "send the contents of this file as ASCII input, after the values")
+	outputsFlag := flags.Int("outputs", 0, "stop after this many outputs (default: at the first wait for input)")
+	stepsFlag := flags.Int("steps", 10000000, "stop after this many instructions")
+	outputFlag := flags.String("o", "", "save a snapshot of the machine to this file instead of dumping it")
+	fromFlag := flags.Int64("from", 0, "first address to dump")
+	toFlag := flags.Int64("to", -1, "address to stop the dump at, or -1 for the end of memory")
+	patches := &patchFlags{}
+	patches.register(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode dump [-input values] [-text file] [-outputs n] [-steps n] [-o snapshot] [-from address] [-to address] [-poke address=value] [-patch file[:name]] program|snapshot")
+		os.Exit(2)
+	}
+	switch {
+	case *fromFlag < 0:
+		fmt.Fprintln(os.Stderr, "intcode: -from expects an address, not", *fromFlag)
+		os.Exit(2)
+	case *toFlag < -1:
+		fmt.Fprintln(os.Stderr, "intcode: -to expects an address or -1, not", *toFlag)
+		os.Exit(2)
+	case *toFlag >= 0 && *fromFlag > *toFlag:
+		fmt.Fprintf(os.Stderr, "intcode: -from %d is past -to %d\n", *fromFlag, *toFlag)
+		os.Exit(2)
+	}
+
+	var input []int64
+	if *inputFlag != "" {
+		input = parseProgram(*inputFlag)
+	}
+	if *textFlag != "" {
+		for _, char := range readFile(*textFlag) + "\n" {
+			input = append(input, int64(char))
+		}
+	}
+
+	snapshot := machineState(flags.Arg(0), patches, input, *outputsFlag, *stepsFlag)
+	if *outputFlag != "" {
+		check(ioutil.WriteFile(*outputFlag, []byte(formatSnapshot(snapshot)), 0644))
+		return
+	}
+
+	end := *toFlag
+	if end < 0 || end > int64(len(snapshot.Memory)) {
+		end = int64(len(snapshot.Memory))
+	}
+	writer := bufio.NewWriter(os.Stdout)
+	defer writer.Flush()
+	fmt.Fprint(writer, formatDump(snapshot, *fromFlag, end))
+}
+
+func memdiffCommand(args []string) {
+	flags := flag.NewFlagSet("memdiff", flag.ExitOnError)
+	patches := &patchFlags{}
+	patches.register(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: intcode memdiff [-poke address=value] [-patch file[:name]] old new")
+		os.Exit(2)
+	}
+
+	// A program is compared as it is before it runs.
+	old := machineState(flags.Arg(0), patches, nil, 0, 0)
+	new := machineState(flags.Arg(1), patches, nil, 0, 0)
+	fmt.Print(formatMemoryDiff(old, new))
+}
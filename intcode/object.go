@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Object modules hold code that is assembled on its own, like a library of
+// helpers for ASCII programs, and linked into programs with others. A module
+// has three segments: code, data, and bss, which is reserved zero words. Its
+// labels are exported as symbols, except those starting with a dot, which are
+// local to it. Words that hold an address in the module are relocated when
+// the linker places its segments, and words that refer to labels of other
+// modules are resolved to the symbols they export.
+//
+// Object files are text:
+//
+//	intcode object
+//	name ascii.s
+//	code 1109,6,...
+//	data 10,0
+//	bss 16
+//	symbol readline code 0
+//	reloc code 12 data
+//	extern code 20 __stack
+//
+// A reloc adds the address of a segment of the module to the word at the
+// offset in the segment, and an extern the address of a symbol.
+
+const (
+	SegmentCode = iota
+	SegmentData
+	SegmentBSS
+)
+
+var segmentNames = []string{"code", "data", "bss"}
+
+type Object struct {
+	Name        string
+	Code, Data  []int64
+	BSS         int64
+	Symbols     []ObjectSymbol
+	Relocations []Relocation
+}
+
+// ObjectSymbol is a label exported by a module.
+type ObjectSymbol struct {
+	Name    string
+	Segment int
+	Offset  int64
+}
+
+// Relocation is a word of the code or data segment holding an address: of
+// the Target segment of the module, or if Symbol is set, of that symbol.
+type Relocation struct {
+	Segment int
+	Offset  int64
+	Target  int
+	Symbol  string
+}
+
+// assembleObject assembles an object module. Lines before the first SECTION
+// are code.
+func assembleObject(name string, lines []Line) (*Object, error) {
+	object := &Object{Name: name}
+
+	type location struct {
+		segment int
+		offset  int64
+	}
+	labels := make(map[string]location)
+	sizes := make([]int64, len(segmentNames))
+
+	segment := SegmentCode
+	for _, line := range lines {
+		switch line.Kind {
+		case LineSection:
+			segment = line.Segment
+		case LineLabel:
+			if _, ok := labels[line.Label]; ok {
+				return nil, fmt.Errorf("duplicate label %q", line.Label)
+			}
+			labels[line.Label] = location{segment, sizes[segment]}
+			if !strings.HasPrefix(line.Label, ".") {
+				object.Symbols = append(object.Symbols, ObjectSymbol{line.Label, segment, sizes[segment]})
+			}
+		case LineInstruction, LineData:
+			if segment == SegmentBSS {
+				return nil, fmt.Errorf("%s in bss", line)
+			}
+		}
+		sizes[segment] += line.Length()
+	}
+
+	segments := [][]int64{nil, nil}
+	segment = SegmentCode
+	for _, line := range lines {
+		switch line.Kind {
+		case LineSection:
+			segment = line.Segment
+
+		case LineInstruction, LineData:
+			words := &segments[segment]
+			if line.Kind == LineInstruction {
+				instruction := line.Opcode
+				for i, operand := range line.Operands {
+					instruction += operand.Mode * pow(10, int64(i)+2)
+				}
+				*words = append(*words, instruction)
+			}
+
+			for _, operand := range line.Operands {
+				offset := int64(len(*words))
+				value := operand.Value
+				if operand.Symbol != "" {
+					if label, ok := labels[operand.Symbol]; ok {
+						value += label.offset
+						object.Relocations = append(object.Relocations, Relocation{Segment: segment, Offset: offset, Target: label.segment})
+					} else if strings.HasPrefix(operand.Symbol, ".") {
+						return nil, fmt.Errorf("undefined local label %q", operand.Symbol)
+					} else {
+						object.Relocations = append(object.Relocations, Relocation{Segment: segment, Offset: offset, Symbol: operand.Symbol})
+					}
+				}
+				*words = append(*words, value)
+			}
+
+		case LineSpace:
+			if segment != SegmentBSS {
+				segments[segment] = append(segments[segment], make([]int64, line.Size)...)
+			}
+		}
+	}
+
+	object.Code, object.Data, object.BSS = segments[SegmentCode], segments[SegmentData], sizes[SegmentBSS]
+	return object, nil
+}
+
+func formatObject(object *Object) string {
+	var builder strings.Builder
+	fmt.Fprintln(&builder, "intcode object")
+	fmt.Fprintf(&builder, "name %s\n", object.Name)
+	fmt.Fprintf(&builder, "code %s\n", formatProgram(object.Code))
+	fmt.Fprintf(&builder, "data %s\n", formatProgram(object.Data))
+	fmt.Fprintf(&builder, "bss %d\n", object.BSS)
+	for _, symbol := range object.Symbols {
+		fmt.Fprintf(&builder, "symbol %s %s %d\n", symbol.Name, segmentNames[symbol.Segment], symbol.Offset)
+	}
+	for _, relocation := range object.Relocations {
+		if relocation.Symbol != "" {
+			fmt.Fprintf(&builder, "extern %s %d %s\n", segmentNames[relocation.Segment], relocation.Offset, relocation.Symbol)
+		} else {
+			fmt.Fprintf(&builder, "reloc %s %d %s\n", segmentNames[relocation.Segment], relocation.Offset, segmentNames[relocation.Target])
+		}
+	}
+	return builder.String()
+}
+
+func isObject(data []byte) bool {
+	return strings.HasPrefix(string(data), "intcode object\n")
+}
+
+func parseObject(text string) (*Object, error) {
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(nil, len(text)+1)
+	if !scanner.Scan() || scanner.Text() != "intcode object" {
+		return nil, errors.New("not an Intcode object file")
+	}
+
+	segment := func(name string) (int, error) {
+		for segment, segmentName := range segmentNames {
+			if segmentName == name {
+				return segment, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown segment %q", name)
+	}
+
+	object := &Object{}
+	for line := 2; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var err error
+		switch {
+		case fields[0] == "name" && len(fields) == 2:
+			object.Name = fields[1]
+		case fields[0] == "code" && len(fields) <= 2:
+			object.Code, err = parseValues(strings.Join(fields[1:], ""))
+		case fields[0] == "data" && len(fields) <= 2:
+			object.Data, err = parseValues(strings.Join(fields[1:], ""))
+		case fields[0] == "bss" && len(fields) == 2:
+			object.BSS, err = strconv.ParseInt(fields[1], 10, 64)
+		case fields[0] == "symbol" && len(fields) == 4:
+			symbol := ObjectSymbol{Name: fields[1]}
+			if symbol.Segment, err = segment(fields[2]); err == nil {
+				symbol.Offset, err = strconv.ParseInt(fields[3], 10, 64)
+			}
+			object.Symbols = append(object.Symbols, symbol)
+		case (fields[0] == "reloc" || fields[0] == "extern") && len(fields) == 4:
+			var relocation Relocation
+			if relocation.Segment, err = segment(fields[1]); err == nil {
+				relocation.Offset, err = strconv.ParseInt(fields[2], 10, 64)
+			}
+			if fields[0] == "extern" {
+				relocation.Symbol = fields[3]
+			} else if err == nil {
+				relocation.Target, err = segment(fields[3])
+			}
+			object.Relocations = append(object.Relocations, relocation)
+		default:
+			err = errors.New("invalid line")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+
+	// Relocations must refer to words of the module.
+	for _, relocation := range object.Relocations {
+		size := int64(len(object.Code))
+		if relocation.Segment == SegmentData {
+			size = int64(len(object.Data))
+		}
+		if relocation.Segment == SegmentBSS || relocation.Offset < 0 || relocation.Offset >= size {
+			return nil, fmt.Errorf("relocation outside of the %s segment", segmentNames[relocation.Segment])
+		}
+	}
+	return object, nil
+}
+
+// link lays out the modules, their code first, then their data, then their
+// bss, and resolves the symbols they refer to. The module defining the entry
+// symbol comes first; if the entry is not its first word, the program starts
+// with a jump to it. The linker defines __stack, the first word after the
+// program and its bss. All undefined and duplicate symbols are reported
+// together.
+func link(objects []*Object, entry string) (program []int64, symbols map[string]int64, err error) {
+	var problems []string
+	symbols = map[string]int64{}
+	definedBy := map[string]string{"__stack": "the linker"}
+
+	first := -1
+	for i, object := range objects {
+		for _, symbol := range object.Symbols {
+			if symbol.Name == entry && symbol.Segment == SegmentCode && first < 0 {
+				first = i
+			}
+		}
+	}
+	if first < 0 {
+		return nil, nil, fmt.Errorf("no module defines the entry %s in its code", entry)
+	}
+	objects = append([]*Object{objects[first]}, append(append([]*Object{}, objects[:first]...), objects[first+1:]...)...)
+
+	// A jump to the entry, if needed: the entry module is placed first, so
+	// its code starts at 0 or after the jump.
+	var start int64
+	for _, symbol := range objects[0].Symbols {
+		if symbol.Name == entry && symbol.Offset != 0 {
+			start = 3
+		}
+	}
+
+	bases := make([][]int64, len(objects))
+	address := start
+	for segment := range segmentNames {
+		for i, object := range objects {
+			if segment == SegmentCode {
+				bases[i] = make([]int64, len(segmentNames))
+			}
+			bases[i][segment] = address
+			switch segment {
+			case SegmentCode:
+				address += int64(len(object.Code))
+			case SegmentData:
+				address += int64(len(object.Data))
+			case SegmentBSS:
+				address += object.BSS
+			}
+		}
+	}
+	symbols["__stack"] = address
+
+	for i, object := range objects {
+		for _, symbol := range object.Symbols {
+			if other, ok := definedBy[symbol.Name]; ok {
+				problems = append(problems, fmt.Sprintf("duplicate symbol %s: defined by %s and %s", symbol.Name, other, object.Name))
+				continue
+			}
+			definedBy[symbol.Name] = object.Name
+			symbols[symbol.Name] = bases[i][symbol.Segment] + symbol.Offset
+		}
+	}
+
+	if start != 0 {
+		program = append(program, 1105, 1, symbols[entry])
+	}
+	for segment := SegmentCode; segment <= SegmentData; segment++ {
+		for i, object := range objects {
+			words := object.Code
+			if segment == SegmentData {
+				words = object.Data
+			}
+
+			base := int64(len(program))
+			program = append(program, words...)
+			for _, relocation := range object.Relocations {
+				if relocation.Segment != segment {
+					continue
+				}
+				if relocation.Symbol == "" {
+					program[base+relocation.Offset] += bases[i][relocation.Target]
+				} else if address, ok := symbols[relocation.Symbol]; ok {
+					program[base+relocation.Offset] += address
+				} else {
+					problems = append(problems, fmt.Sprintf("%s: undefined symbol %s", object.Name, relocation.Symbol))
+				}
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, nil, errors.New(strings.Join(dedupe(problems), "\n"))
+	}
+	return program, symbols, nil
+}
+
+// dedupe removes repeated strings from a sorted slice.
+func dedupe(sorted []string) []string {
+	var result []string
+	for i, s := range sorted {
+		if i == 0 || s != sorted[i-1] {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// The optimizer rewrites the code of a program in place: every instruction
+// keeps its address, since any word of a program may be an address (a jump
+// target, a return address pushed as a constant, or the base of an array),
+// and there is no telling which. It
+//
+//   - folds ADD, MUL, LT and EQ instructions with only immediate inputs into
+//     ADD c, 0, dest,
+//   - threads jumps: a jump to an unconditional jump, or to a jump that is
+//     never taken, goes straight to where that one leads,
+//   - removes the code no longer reachable after that, filling it with zeros,
+//     and trims the zeros at the end of the program, as memory beyond it is
+//     zero anyway.
+//
+// Instructions that the program reads or writes as data are left alone: the
+// ones given by position mode operands, and those accessed in recorded runs.
+
+type optimization struct {
+	Program []int64
+
+	Folded    int // instructions folded
+	Threaded  int // jumps threaded
+	Removed   int // words of unreachable code zeroed
+	Trimmed   int // words trimmed from the end
+	Protected int // instructions left alone, as they are accessed as data
+}
+
+// optimize optimizes a program. accessed holds the addresses accessed as data
+// in recorded runs.
+func optimize(program []int64, accessed map[int64]bool) optimization {
+	memory := append([]int64(nil), program...)
+	result := optimization{}
+	code := analyze(memory).Code
+
+	// Words accessed as data, by position mode operands or in runs.
+	data := make(map[int64]bool)
+	for address := range accessed {
+		data[address] = true
+	}
+	for _, line := range code {
+		for _, operand := range line.Operands {
+			if operand.Mode == ModePosition {
+				data[operand.Value] = true
+			}
+		}
+	}
+	protected := func(address int64, line Line) bool {
+		for i := int64(0); i < line.Length(); i++ {
+			if data[address+i] {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, address := range sortedLines(code) {
+		line := code[address]
+		if protected(address, line) {
+			result.Protected++
+			continue
+		}
+
+		if value, ok := foldConstant(line); ok && !(line.Opcode == OpAdd && line.Operands[1].Value == 0) {
+			destination := line.Operands[2]
+			memory[address] = OpAdd + ModeImmediate*100 + ModeImmediate*1000 + destination.Mode*10000
+			memory[address+1], memory[address+2] = value, 0
+			result.Folded++
+		}
+
+		if line.Opcode == OpJumpIfTrue || line.Opcode == OpJumpIfFalse {
+			if target := line.Operands[1]; target.Mode == ModeImmediate {
+				if threaded := threadJump(code, target.Value, protected); threaded != target.Value {
+					memory[address+2] = threaded
+					result.Threaded++
+				}
+			}
+		}
+	}
+
+	// Code that was reachable, but is not any more, is removed.
+	reachable := make(map[int64]bool)
+	for address, line := range analyze(memory).Code {
+		for i := int64(0); i < line.Length(); i++ {
+			reachable[address+i] = true
+		}
+	}
+	for address, line := range code {
+		if protected(address, line) {
+			continue
+		}
+		for i := address; i < address+line.Length(); i++ {
+			if !reachable[i] && memory[i] != 0 {
+				memory[i] = 0
+				result.Removed++
+			}
+		}
+	}
+
+	end := len(memory)
+	for end > 0 && memory[end-1] == 0 && !data[int64(end-1)] {
+		end--
+	}
+	result.Trimmed = len(memory) - end
+	result.Program = memory[:end]
+	return result
+}
+
+// foldConstant returns the value an ADD, MUL, LT or EQ instruction with only
+// immediate inputs writes.
+func foldConstant(line Line) (int64, bool) {
+	if value, ok := constantResult(line); ok {
+		return value, true
+	}
+	if line.Opcode != OpLessThan && line.Opcode != OpEqual {
+		return 0, false
+	}
+	a, b := line.Operands[0], line.Operands[1]
+	if a.Mode != ModeImmediate || b.Mode != ModeImmediate {
+		return 0, false
+	}
+	if (line.Opcode == OpLessThan && a.Value < b.Value) || (line.Opcode == OpEqual && a.Value == b.Value) {
+		return 1, true
+	}
+	return 0, true
+}
+
+// threadJump follows a jump target through unconditional jumps and jumps
+// never taken, and returns where it leads.
+func threadJump(code map[int64]Line, target int64, protected func(int64, Line) bool) int64 {
+	seen := map[int64]bool{}
+	for !seen[target] {
+		seen[target] = true
+		line, ok := code[target]
+		if !ok || protected(target, line) || (line.Opcode != OpJumpIfTrue && line.Opcode != OpJumpIfFalse) {
+			break
+		}
+
+		condition := line.Operands[0]
+		if next, ok := unconditionalJump(line); ok && next.Mode == ModeImmediate {
+			target = next.Value
+		} else if condition.Mode == ModeImmediate && !ok {
+			target += line.Length()
+		} else {
+			break
+		}
+	}
+	return target
+}
+
+func sortedLines(code map[int64]Line) []int64 {
+	counts := make(map[int64]int64, len(code))
+	for address := range code {
+		counts[address] = 0
+	}
+	return sortedAddresses(counts)
+}
+
+// optimizationRun runs a program on an input for at most steps instructions,
+// noting the addresses it accesses as data, and returns its output, how it
+// ended, and the steps it took.
+func optimizationRun(program, input []int64, steps int, accessed map[int64]bool) (output []int64, ended string, taken int64) {
+	emulator := makeEmulator(program, input...)
+	emulator.singleStep = true
+	emulator.accesses = makeAccessLog(1)
+
+	defer func() {
+		if r := recover(); r != nil {
+			ended = fmt.Sprint(r)
+		}
+		taken = emulator.accesses.Steps
+		for _, period := range emulator.accesses.Periods {
+			for address := range period {
+				accessed[address] = true
+			}
+		}
+	}()
+
+	for step := 0; step < steps; step++ {
+		value, status := emulate(emulator)
+		switch status {
+		case EmulatorStatusHalted:
+			return output, "halted", 0
+		case EmulatorStatusWaitingForInput:
+			return output, "waiting for input", 0
+		case EmulatorStatusOutput:
+			output = append(output, value)
+		}
+	}
+	return output, stepLimit(steps), 0
+}
+
+func optimizeCommand(args []string) {
+	flags := flag.NewFlagSet("optimize", flag.ExitOnError)
+	outputFlag := flags.String("o", "", "write the program to this file instead of stdout")
+	asciiFlag := flags.Bool("ascii", false, "send the input files as ASCII text")
+	inputFlag := flags.String("input", "", "comma-separated input values, sent at the start of every run")
+	verifyFlag := flags.Bool("verify", false, "run both programs on every input and compare their output")
+	stepsFlag := flags.Int("steps", 10000000, "instructions after which a run stops")
+	patches := &patchFlags{}
+	patches.register(flags)
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode optimize [-o file] [-ascii] [-input values] [-verify] [-steps n] [-poke address=value] [-patch file[:name]] program [input files]")
+		os.Exit(2)
+	}
+
+	program := loadPatchedProgram(flags.Arg(0), patches)
+
+	// Every input file is a recorded run, as for cover; the runs show which
+	// words the program accesses as data.
+	var inputs [][]int64
+	var names []string
+	for _, filename := range flags.Args()[1:] {
+		var input []int64
+		if *inputFlag != "" {
+			input = parseProgram(*inputFlag)
+		}
+		if *asciiFlag {
+			for _, char := range readFile(filename) + "\n" {
+				input = append(input, int64(char))
+			}
+		} else {
+			input = append(input, parseProgram(readFile(filename))...)
+		}
+		inputs = append(inputs, input)
+		names = append(names, filename)
+	}
+	if len(inputs) == 0 && *inputFlag != "" {
+		inputs = append(inputs, parseProgram(*inputFlag))
+		names = append(names, "-input")
+	}
+
+	accessed := make(map[int64]bool)
+	type run struct {
+		output []int64
+		ended  string
+		steps  int64
+	}
+	var before []run
+	for _, input := range inputs {
+		output, ended, steps := optimizationRun(program, input, *stepsFlag, accessed)
+		before = append(before, run{output, ended, steps})
+	}
+
+	result := optimize(program, accessed)
+	fmt.Fprintf(os.Stderr, "intcode: folded %d constants, threaded %d jumps, removed %d words of unreachable code, trimmed %d words; left %d instructions accessed as data alone\n",
+		result.Folded, result.Threaded, result.Removed, result.Trimmed, result.Protected)
+	fmt.Fprintf(os.Stderr, "intcode: %d words, was %d\n", len(result.Program), len(program))
+
+	if *verifyFlag {
+		if len(inputs) == 0 {
+			fmt.Fprintln(os.Stderr, "intcode: -verify needs input files or -input")
+			os.Exit(2)
+		}
+		failed := false
+		for i, input := range inputs {
+			output, ended, steps := optimizationRun(result.Program, input, *stepsFlag, map[int64]bool{})
+			status := "ok"
+			if formatProgram(output) != formatProgram(before[i].output) || ended != before[i].ended {
+				status = "DIFFERS"
+				failed = true
+			}
+			fmt.Fprintf(os.Stderr, "%s: %s, %s after %d steps, was %d\n", names[i], status, ended, steps, before[i].steps)
+			if status != "ok" {
+				fmt.Fprintf(os.Stderr, "  before: %s: %s\n  after:  %s: %s\n", before[i].ended, formatProgram(before[i].output), ended, formatProgram(output))
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	}
+
+	text := formatProgram(result.Program) + "\n"
+	if *outputFlag != "" {
+		check(ioutil.WriteFile(*outputFlag, []byte(text), 0644))
+	} else {
+		writer := bufio.NewWriter(os.Stdout)
+		fmt.Fprint(writer, text)
+		check(writer.Flush())
+	}
+}
@@ -0,0 +1,641 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file contains the lexer and parser of the small language compiled by
+// "intcode compile". A program is a list of global variables and functions:
+//
+//	var count = 0;
+//	var buffer[16];
+//
+//	func square(x) {
+//		return x * x;
+//	}
+//
+//	func main() {
+//		var n = input();
+//		while (n > 0) {
+//			print("square: ", square(n), "\n");
+//			n = n - 1;
+//		}
+//	}
+//
+// All values are 64-bit integers. Division truncates toward zero, and
+// division by zero does not fail: x / 0 is 0, so x % 0 is x. Arrays evaluate
+// to their address, so they can be passed to functions and indexed there.
+// String literals evaluate to the address of a zero-terminated array of
+// characters, except as arguments of print, which writes them as ASCII.
+//
+// A function declared without a body, like "func readline(buffer, size);",
+// is defined in another module, and linked in (see object.go).
+
+type Position struct {
+	Filename     string
+	Line, Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+}
+
+type CompileError struct {
+	Pos     Position
+	Message string
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("%v: %s", e.Pos, e.Message)
+}
+
+func errorf(pos Position, format string, args ...interface{}) {
+	panic(&CompileError{pos, fmt.Sprintf(format, args...)})
+}
+
+type TokenKind int
+
+const (
+	TokenEOF TokenKind = iota
+	TokenIdent
+	TokenNumber
+	TokenString
+	TokenPunct
+)
+
+type Token struct {
+	Kind  TokenKind
+	Text  string
+	Value int64 // numbers and character literals
+	Pos   Position
+}
+
+var keywords = map[string]bool{
+	"var":      true,
+	"func":     true,
+	"if":       true,
+	"else":     true,
+	"while":    true,
+	"return":   true,
+	"break":    true,
+	"continue": true,
+}
+
+// Punctuation, longest first so that "<=" is not lexed as "<".
+var punctuation = []string{
+	"==", "!=", "<=", ">=", "&&", "||", "+=", "-=", "*=", "/=", "%=",
+	"+", "-", "*", "/", "%", "<", ">", "=", "!", "(", ")", "{", "}", "[", "]", ",", ";",
+}
+
+var escapes = map[rune]rune{'n': '\n', 't': '\t', 'r': '\r', '0': 0, '\\': '\\', '\'': '\'', '"': '"'}
+
+func lex(filename, source string) (tokens []Token) {
+	runes := []rune(source)
+	line, column := 1, 1
+	i := 0
+
+	advance := func() rune {
+		r := runes[i]
+		i++
+		if r == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+		return r
+	}
+
+	// readChar reads a possibly escaped character of a string or character literal.
+	readChar := func(pos Position) rune {
+		if i >= len(runes) || runes[i] == '\n' {
+			errorf(pos, "unterminated literal")
+		}
+		r := advance()
+		if r != '\\' {
+			return r
+		}
+		if i >= len(runes) {
+			errorf(pos, "unterminated literal")
+		}
+		escaped, ok := escapes[runes[i]]
+		if !ok {
+			errorf(Position{filename, line, column}, "unknown escape sequence \\%c", runes[i])
+		}
+		advance()
+		return escaped
+	}
+
+	for {
+		// Skip whitespace and comments.
+		for i < len(runes) {
+			if unicode.IsSpace(runes[i]) {
+				advance()
+			} else if runes[i] == '/' && i+1 < len(runes) && runes[i+1] == '/' {
+				for i < len(runes) && runes[i] != '\n' {
+					advance()
+				}
+			} else {
+				break
+			}
+		}
+
+		pos := Position{filename, line, column}
+		if i >= len(runes) {
+			return append(tokens, Token{Kind: TokenEOF, Pos: pos})
+		}
+
+		r := runes[i]
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				advance()
+			}
+			tokens = append(tokens, Token{Kind: TokenIdent, Text: string(runes[start:i]), Pos: pos})
+
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				advance()
+			}
+			text := string(runes[start:i])
+			value, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				errorf(pos, "number out of range: %s", text)
+			}
+			tokens = append(tokens, Token{Kind: TokenNumber, Text: text, Value: value, Pos: pos})
+
+		case r == '\'':
+			advance()
+			value := readChar(pos)
+			if i >= len(runes) || runes[i] != '\'' {
+				errorf(pos, "character literal must contain exactly one character")
+			}
+			advance()
+			tokens = append(tokens, Token{Kind: TokenNumber, Text: string(value), Value: int64(value), Pos: pos})
+
+		case r == '"':
+			advance()
+			var builder strings.Builder
+			for i >= len(runes) || runes[i] != '"' {
+				builder.WriteRune(readChar(pos))
+			}
+			advance()
+			tokens = append(tokens, Token{Kind: TokenString, Text: builder.String(), Pos: pos})
+
+		default:
+			matched := false
+			for _, p := range punctuation {
+				if strings.HasPrefix(string(runes[i:min(i+len(p), len(runes))]), p) {
+					for range p {
+						advance()
+					}
+					tokens = append(tokens, Token{Kind: TokenPunct, Text: p, Pos: pos})
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				errorf(pos, "unexpected character %q", r)
+			}
+		}
+	}
+}
+
+type Expr interface {
+	Pos() Position
+}
+
+type NumberExpr struct {
+	At    Position
+	Value int64
+}
+
+type StringExpr struct {
+	At    Position
+	Value string
+}
+
+type NameExpr struct {
+	At   Position
+	Name string
+}
+
+type IndexExpr struct {
+	At    Position
+	Array Expr
+	Index Expr
+}
+
+type CallExpr struct {
+	At   Position
+	Name string
+	Args []Expr
+}
+
+type UnaryExpr struct {
+	At Position
+	Op string
+	X  Expr
+}
+
+type BinaryExpr struct {
+	At   Position
+	Op   string
+	X, Y Expr
+}
+
+func (e *NumberExpr) Pos() Position { return e.At }
+func (e *StringExpr) Pos() Position { return e.At }
+func (e *NameExpr) Pos() Position   { return e.At }
+func (e *IndexExpr) Pos() Position  { return e.At }
+func (e *CallExpr) Pos() Position   { return e.At }
+func (e *UnaryExpr) Pos() Position  { return e.At }
+func (e *BinaryExpr) Pos() Position { return e.At }
+
+type Stmt interface {
+	Pos() Position
+}
+
+// VarStmt declares a variable, or an array if Size is not zero.
+type VarStmt struct {
+	At   Position
+	Name string
+	Size int64
+	Init Expr
+}
+
+type AssignStmt struct {
+	At     Position
+	Target Expr // *NameExpr or *IndexExpr
+	Op     string
+	Value  Expr
+}
+
+type IfStmt struct {
+	At   Position
+	Cond Expr
+	Then Stmt
+	Else Stmt
+}
+
+type WhileStmt struct {
+	At   Position
+	Cond Expr
+	Body Stmt
+}
+
+type ReturnStmt struct {
+	At    Position
+	Value Expr
+}
+
+type BreakStmt struct {
+	At Position
+}
+
+type ContinueStmt struct {
+	At Position
+}
+
+type BlockStmt struct {
+	At    Position
+	Stmts []Stmt
+}
+
+type ExprStmt struct {
+	At Position
+	X  Expr
+}
+
+func (s *VarStmt) Pos() Position      { return s.At }
+func (s *AssignStmt) Pos() Position   { return s.At }
+func (s *IfStmt) Pos() Position       { return s.At }
+func (s *WhileStmt) Pos() Position    { return s.At }
+func (s *ReturnStmt) Pos() Position   { return s.At }
+func (s *BreakStmt) Pos() Position    { return s.At }
+func (s *ContinueStmt) Pos() Position { return s.At }
+func (s *BlockStmt) Pos() Position    { return s.At }
+func (s *ExprStmt) Pos() Position     { return s.At }
+
+type Function struct {
+	At     Position
+	Name   string
+	Params []string
+	Body   *BlockStmt // nil if only declared
+}
+
+type Source struct {
+	Globals   []*VarStmt
+	Functions []*Function
+}
+
+type parser struct {
+	tokens []Token
+	pos    int
+}
+
+func parse(filename, source string) (result *Source, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			compileError, ok := r.(*CompileError)
+			if !ok {
+				panic(r)
+			}
+			err = compileError
+		}
+	}()
+
+	p := &parser{tokens: lex(filename, source)}
+	return p.parseSource(), nil
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() Token {
+	token := p.tokens[p.pos]
+	if token.Kind != TokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+// is reports whether the next token is the given punctuation or keyword.
+func (p *parser) is(text string) bool {
+	token := p.peek()
+	return (token.Kind == TokenPunct || token.Kind == TokenIdent) && token.Text == text
+}
+
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) Token {
+	if !p.is(text) {
+		p.unexpected("expected " + strconv.Quote(text))
+	}
+	return p.next()
+}
+
+func (p *parser) ident() Token {
+	token := p.peek()
+	if token.Kind != TokenIdent || keywords[token.Text] {
+		p.unexpected("expected name")
+	}
+	return p.next()
+}
+
+func (p *parser) unexpected(message string) {
+	token := p.peek()
+	switch token.Kind {
+	case TokenEOF:
+		errorf(token.Pos, "%s, found end of file", message)
+	case TokenString:
+		errorf(token.Pos, "%s, found string %q", message, token.Text)
+	default:
+		errorf(token.Pos, "%s, found %q", message, token.Text)
+	}
+}
+
+func (p *parser) parseSource() *Source {
+	source := &Source{}
+	for p.peek().Kind != TokenEOF {
+		switch {
+		case p.is("var"):
+			source.Globals = append(source.Globals, p.parseVar())
+		case p.is("func"):
+			source.Functions = append(source.Functions, p.parseFunction())
+		default:
+			p.unexpected("expected \"var\" or \"func\"")
+		}
+	}
+	return source
+}
+
+func (p *parser) parseFunction() *Function {
+	at := p.expect("func").Pos
+	function := &Function{At: at, Name: p.ident().Text}
+
+	p.expect("(")
+	for !p.is(")") {
+		if len(function.Params) != 0 {
+			p.expect(",")
+		}
+		function.Params = append(function.Params, p.ident().Text)
+	}
+	p.expect(")")
+
+	// A declaration without a body is defined in another module.
+	if p.accept(";") {
+		return function
+	}
+	function.Body = p.parseBlock()
+	return function
+}
+
+func (p *parser) parseVar() *VarStmt {
+	at := p.expect("var").Pos
+	stmt := &VarStmt{At: at, Name: p.ident().Text}
+
+	if p.accept("[") {
+		size := p.next()
+		if size.Kind != TokenNumber || size.Value <= 0 {
+			errorf(size.Pos, "array size must be a positive number")
+		}
+		stmt.Size = size.Value
+		p.expect("]")
+	} else if p.accept("=") {
+		stmt.Init = p.parseExpr()
+	}
+
+	p.expect(";")
+	return stmt
+}
+
+func (p *parser) parseBlock() *BlockStmt {
+	block := &BlockStmt{At: p.expect("{").Pos}
+	for !p.accept("}") {
+		if p.peek().Kind == TokenEOF {
+			p.unexpected("expected \"}\"")
+		}
+		block.Stmts = append(block.Stmts, p.parseStmt())
+	}
+	return block
+}
+
+func (p *parser) parseStmt() Stmt {
+	at := p.peek().Pos
+
+	switch {
+	case p.is("{"):
+		return p.parseBlock()
+
+	case p.is("var"):
+		return p.parseVar()
+
+	case p.accept("if"):
+		stmt := &IfStmt{At: at}
+		p.expect("(")
+		stmt.Cond = p.parseExpr()
+		p.expect(")")
+		stmt.Then = p.parseStmt()
+		if p.accept("else") {
+			stmt.Else = p.parseStmt()
+		}
+		return stmt
+
+	case p.accept("while"):
+		stmt := &WhileStmt{At: at}
+		p.expect("(")
+		stmt.Cond = p.parseExpr()
+		p.expect(")")
+		stmt.Body = p.parseStmt()
+		return stmt
+
+	case p.accept("return"):
+		stmt := &ReturnStmt{At: at}
+		if !p.is(";") {
+			stmt.Value = p.parseExpr()
+		}
+		p.expect(";")
+		return stmt
+
+	case p.accept("break"):
+		p.expect(";")
+		return &BreakStmt{At: at}
+
+	case p.accept("continue"):
+		p.expect(";")
+		return &ContinueStmt{At: at}
+	}
+
+	x := p.parseExpr()
+	for _, op := range []string{"=", "+=", "-=", "*=", "/=", "%="} {
+		if p.accept(op) {
+			switch x.(type) {
+			case *NameExpr, *IndexExpr:
+			default:
+				errorf(at, "cannot assign to expression")
+			}
+			stmt := &AssignStmt{At: at, Target: x, Op: op, Value: p.parseExpr()}
+			p.expect(";")
+			return stmt
+		}
+	}
+
+	p.expect(";")
+	return &ExprStmt{At: at, X: x}
+}
+
+// Binary operators by precedence, lowest first.
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) parseExpr() Expr {
+	return p.parseBinary(0)
+}
+
+func (p *parser) parseBinary(level int) Expr {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+
+	x := p.parseBinary(level + 1)
+	for {
+		matched := false
+		for _, op := range precedence[level] {
+			if p.peek().Kind == TokenPunct && p.peek().Text == op {
+				at := p.next().Pos
+				x = &BinaryExpr{At: at, Op: op, X: x, Y: p.parseBinary(level + 1)}
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return x
+		}
+	}
+}
+
+func (p *parser) parseUnary() Expr {
+	at := p.peek().Pos
+	if p.accept("-") {
+		return &UnaryExpr{At: at, Op: "-", X: p.parseUnary()}
+	}
+	if p.accept("!") {
+		return &UnaryExpr{At: at, Op: "!", X: p.parseUnary()}
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() Expr {
+	x := p.parsePrimary()
+	for p.is("[") {
+		at := p.next().Pos
+		index := p.parseExpr()
+		p.expect("]")
+		x = &IndexExpr{At: at, Array: x, Index: index}
+	}
+	return x
+}
+
+func (p *parser) parsePrimary() Expr {
+	token := p.peek()
+	switch token.Kind {
+	case TokenNumber:
+		p.next()
+		return &NumberExpr{At: token.Pos, Value: token.Value}
+
+	case TokenString:
+		p.next()
+		return &StringExpr{At: token.Pos, Value: token.Text}
+
+	case TokenIdent:
+		name := p.ident()
+		if !p.accept("(") {
+			return &NameExpr{At: name.Pos, Name: name.Text}
+		}
+
+		call := &CallExpr{At: name.Pos, Name: name.Text}
+		for !p.is(")") {
+			if len(call.Args) != 0 {
+				p.expect(",")
+			}
+			call.Args = append(call.Args, p.parseExpr())
+		}
+		p.expect(")")
+		return call
+	}
+
+	if p.accept("(") {
+		x := p.parseExpr()
+		p.expect(")")
+		return x
+	}
+
+	p.unexpected("expected expression")
+	return nil
+}
+
+func min(x, y int) int {
+	if y < x {
+		return y
+	}
+	return x
+}
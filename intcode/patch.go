@@ -0,0 +1,244 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Patches modify a program before it runs, like the puzzles do to insert
+// quarters (day 13) or wake up the robot (day 17). A patch file holds named
+// patch sets, each a list of words to write:
+//
+//	# Free play for the arcade.
+//	patch free-play
+//	0: 1 -> 2
+//
+//	patch alarm
+//	1: 0,0 -> 12,2
+//	# Without the expected words, the write is not verified.
+//	2 = 2
+//
+// "address: before -> after" writes after to consecutive words starting at
+// address, but only if they hold before; a patch set refuses to apply to a
+// program it was not written for. "address = after" writes unconditionally.
+
+type Patch struct {
+	Address int64
+	Before  []int64 // nil if not verified
+	After   []int64
+}
+
+type PatchSet struct {
+	Name    string
+	Patches []Patch
+}
+
+// parsePatches parses a patch file.
+func parsePatches(filename, text string) ([]PatchSet, error) {
+	var sets []PatchSet
+
+	for i, line := range strings.Split(text, "\n") {
+		errorf := func(format string, args ...interface{}) ([]PatchSet, error) {
+			return nil, fmt.Errorf("%s:%d: %s", filename, i+1, fmt.Sprintf(format, args...))
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if fields := strings.Fields(line); fields[0] == "patch" {
+			if len(fields) != 2 {
+				return errorf("expected patch <name>")
+			}
+			sets = append(sets, PatchSet{Name: fields[1]})
+			continue
+		}
+		if len(sets) == 0 {
+			return errorf("patch outside of a patch set")
+		}
+
+		patch, err := parsePatch(line)
+		if err != nil {
+			return errorf("%v", err)
+		}
+		set := &sets[len(sets)-1]
+		set.Patches = append(set.Patches, patch)
+	}
+
+	return sets, nil
+}
+
+// parsePatch parses "address: before -> after" or "address = after".
+func parsePatch(text string) (patch Patch, err error) {
+	separator := "="
+	if strings.Contains(text, ":") {
+		separator = ":"
+	}
+	parts := strings.SplitN(text, separator, 2)
+	if len(parts) != 2 {
+		return patch, fmt.Errorf("expected address: before -> after or address = after")
+	}
+
+	patch.Address, err = strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil || patch.Address < 0 {
+		return patch, fmt.Errorf("invalid address %q", strings.TrimSpace(parts[0]))
+	}
+
+	words := func(text string) ([]int64, error) {
+		var values []int64
+		for _, field := range strings.Split(text, ",") {
+			value, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", strings.TrimSpace(field))
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+
+	if separator == "=" {
+		patch.After, err = words(parts[1])
+		return patch, err
+	}
+
+	sides := strings.SplitN(parts[1], "->", 2)
+	if len(sides) != 2 {
+		return patch, fmt.Errorf("expected before -> after")
+	}
+	if patch.Before, err = words(sides[0]); err != nil {
+		return patch, err
+	}
+	if patch.After, err = words(sides[1]); err != nil {
+		return patch, err
+	}
+	if len(patch.Before) != len(patch.After) {
+		return patch, fmt.Errorf("%d words before, but %d after", len(patch.Before), len(patch.After))
+	}
+	return patch, nil
+}
+
+// applyPatches applies patch sets to the program, in order, and returns it.
+// Each set is checked against the program as the sets before it left it, and
+// nothing of a set is written unless all its words to verify match. Unverified
+// writes past the end of the program extend it, as the memory would grow at
+// run time.
+func applyPatches(program []int64, sets ...PatchSet) ([]int64, error) {
+	for _, set := range sets {
+		for _, patch := range set.Patches {
+			end := patch.Address + int64(len(patch.After))
+			if patch.Before != nil && end > int64(len(program)) {
+				return program, fmt.Errorf("patch %s: address %d is outside of the program", set.Name, end-1)
+			}
+			for i, before := range patch.Before {
+				address := patch.Address + int64(i)
+				if program[address] != before {
+					return program, fmt.Errorf("patch %s: address %d is %d, expected %d", set.Name, address, program[address], before)
+				}
+			}
+		}
+
+		for _, patch := range set.Patches {
+			for end := patch.Address + int64(len(patch.After)); int64(len(program)) < end; {
+				program = append(program, 0)
+			}
+			copy(program[patch.Address:], patch.After)
+		}
+	}
+	return program, nil
+}
+
+// findPatchSet returns the patch set with the given name.
+func findPatchSet(sets []PatchSet, name string) (PatchSet, error) {
+	var names []string
+	for _, set := range sets {
+		if set.Name == name {
+			return set, nil
+		}
+		names = append(names, set.Name)
+	}
+	return PatchSet{}, fmt.Errorf("no patch set %q (have %s)", name, strings.Join(names, ", "))
+}
+
+// patchFlags collects the -poke and -patch flags of a command.
+type patchFlags struct {
+	pokes   []string
+	patches []string
+}
+
+type pokeFlag struct{ flags *patchFlags }
+
+func (f pokeFlag) String() string { return "" }
+
+func (f pokeFlag) Set(value string) error {
+	if _, err := parsePatch(value); err != nil || !strings.Contains(value, "=") {
+		return fmt.Errorf("expected address=value")
+	}
+	f.flags.pokes = append(f.flags.pokes, value)
+	return nil
+}
+
+type patchFlag struct{ flags *patchFlags }
+
+func (f patchFlag) String() string { return "" }
+
+func (f patchFlag) Set(value string) error {
+	f.flags.patches = append(f.flags.patches, value)
+	return nil
+}
+
+func (flags *patchFlags) register(set *flag.FlagSet) {
+	set.Var(pokeFlag{flags}, "poke", "write `address=value` before running; may be repeated")
+	set.Var(patchFlag{flags}, "patch", "apply the patch sets in `file`, or only the one named file:name, or a binary patch; may be repeated")
+}
+
+// apply applies the patch sets named by -patch file:name (or all sets in the
+// file, without a name), or the binary patch in the file (see binpatch.go),
+// and then the -poke address=value flags.
+func (flags *patchFlags) apply(program []int64) ([]int64, error) {
+	for _, argument := range flags.patches {
+		if data, err := ioutil.ReadFile(argument); err == nil && isBinaryPatch(data) {
+			patch, err := decodeBinaryPatch(data)
+			if err == nil {
+				program, err = patch.apply(program, false)
+			}
+			if err != nil {
+				return program, fmt.Errorf("%s: %v", argument, err)
+			}
+			continue
+		}
+
+		filename, name := argument, ""
+		if i := strings.LastIndex(argument, ":"); i >= 0 {
+			filename, name = argument[:i], argument[i+1:]
+		}
+
+		sets, err := parsePatches(filename, readFile(filename))
+		if err != nil {
+			return program, err
+		}
+		if name != "" {
+			set, err := findPatchSet(sets, name)
+			if err != nil {
+				return program, fmt.Errorf("%s: %v", filename, err)
+			}
+			sets = []PatchSet{set}
+		}
+		if program, err = applyPatches(program, sets...); err != nil {
+			return program, fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+
+	pokes := PatchSet{Name: "-poke"}
+	for _, poke := range flags.pokes {
+		patch, err := parsePatch(poke)
+		if err != nil {
+			return program, err
+		}
+		pokes.Patches = append(pokes.Patches, patch)
+	}
+	return applyPatches(program, pokes)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Pool answers many independent queries with the same program, for query
+// style programs like the tractor beam of day 19: each query runs the program
+// from the start with its own input and collects its output. The emulators
+// are reset between queries instead of being created again.
+type Pool struct {
+	emulators []*Emulator
+}
+
+func makePool(program []int64, size int) *Pool {
+	pool := &Pool{}
+	for i := 0; i < size; i++ {
+		pool.emulators = append(pool.emulators, makeEmulator(program))
+	}
+	return pool
+}
+
+// Batch runs the program once for every input, concurrently, and returns the
+// outputs in the order of the inputs.
+func (pool *Pool) Batch(inputs [][]int64) ([][]int64, error) {
+	outputs := make([][]int64, len(inputs))
+	errors := make([]error, len(inputs))
+
+	jobs := make(chan int, len(inputs))
+	for i := range inputs {
+		jobs <- i
+	}
+	close(jobs)
+
+	var wait sync.WaitGroup
+	for _, emulator := range pool.emulators {
+		wait.Add(1)
+		go func(emulator *Emulator) {
+			defer wait.Done()
+			// The outputs of the queries of the worker, one after another,
+			// so that they do not each allocate their own.
+			var buffer []int64
+			for i := range jobs {
+				start := len(buffer)
+				buffer, errors[i] = query(emulator, inputs[i], buffer)
+				outputs[i] = buffer[start:len(buffer):len(buffer)]
+			}
+		}(emulator)
+	}
+	wait.Wait()
+
+	for i, err := range errors {
+		if err != nil {
+			return outputs, fmt.Errorf("query %d: %v", i+1, err)
+		}
+	}
+	return outputs, nil
+}
+
+// query resets the emulator and runs the program with the input until it
+// halts, appending its output to output, also the output so far if it fails.
+// Passing the output of the previous query avoids allocating.
+func query(emulator *Emulator, input []int64, output []int64) (result []int64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = output, fmt.Errorf("%v", r)
+		}
+	}()
+
+	emulator.Reset()
+	emulator.input = append(emulator.input, input...)
+	for {
+		value, status := emulate(emulator)
+		switch status {
+		case EmulatorStatusHalted:
+			return output, nil
+		case EmulatorStatusOutput:
+			output = append(output, value)
+		case EmulatorStatusWaitingForInput:
+			return output, fmt.Errorf("program is waiting for more input")
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file implements a local HTTP service for running Intcode programs, so
+// that other tools can drive the emulator without shelling out. Programs are
+// posted as the form field or uploaded file "program", as text or as an
+// image, with optional "input" values, "text" to send as ASCII, and "ascii"
+// to receive output as text. Output comes back as Server-Sent Events:
+//
+//	POST   /run                    runs a program and streams its events until
+//	                               it halts, faults or waits for input
+//	POST   /sessions               starts an interactive session, and returns
+//	                               its id
+//	GET    /sessions/{id}          shows the state of a session
+//	GET    /sessions/{id}/events   streams the events of a session, from the
+//	                               start or from the Last-Event-ID header
+//	POST   /sessions/{id}/input    sends "input" values or a "text" line, and
+//	                               runs the program until it waits again
+//	DELETE /sessions/{id}          ends a session
+//
+// The events are "output" with a value, "text" with a JSON string of ASCII
+// output, "waiting" when the program waits for input, and finally "halted",
+// "fault" or "limit" with the reason. Every run between two inputs is limited
+// in steps, and memory is limited in words.
+
+const serveMaxRequest = 16 << 20
+
+type serveEvent struct {
+	Name string
+	Data string
+}
+
+type serveSession struct {
+	id    string
+	ascii bool
+
+	mutex    sync.Mutex // guards everything below
+	emulator *Emulator  // used by the running run only, while running is set
+	events   []serveEvent
+	changed  chan struct{} // closed and replaced when events are added
+	running  bool
+	done     bool // halted, faulted, over the limit or deleted
+	lastUsed time.Time
+}
+
+type server struct {
+	steps  int
+	memory int64
+
+	mutex    sync.Mutex // guards sessions and lastID
+	sessions map[string]*serveSession
+	lastID   int
+}
+
+func serveCommand(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	portFlag := flags.Int("port", 8019, "listen on this local TCP port")
+	stepsFlag := flags.Int("steps", 10000000, "instructions a program may execute between two inputs")
+	memoryFlag := flags.Int64("memory", 1<<20, "words of memory a program may use")
+	idleFlag := flags.Duration("idle", 30*time.Minute, "end sessions unused for this long")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: intcode serve [-port n] [-steps n] [-memory words] [-idle duration]")
+		os.Exit(2)
+	}
+
+	s := &server{steps: *stepsFlag, memory: *memoryFlag, sessions: make(map[string]*serveSession)}
+	go s.expire(*idleFlag)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", *portFlag))
+	check(err)
+	fmt.Fprintf(os.Stderr, "intcode: listening on http://%s\n", listener.Addr())
+	check(http.Serve(listener, s))
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, serveMaxRequest)
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(path) == 1 && path[0] == "run":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		session, err := s.start(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		session.stream(w, r, true)
+
+	case len(path) == 1 && path[0] == "sessions":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		session, err := s.start(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mutex.Lock()
+		s.sessions[session.id] = session
+		s.mutex.Unlock()
+
+		w.Header().Set("Location", "/sessions/"+session.id)
+		writeJSON(w, http.StatusCreated, session.state())
+
+	case len(path) >= 2 && len(path) <= 3 && path[0] == "sessions":
+		s.mutex.Lock()
+		session := s.sessions[path[1]]
+		s.mutex.Unlock()
+		if session == nil {
+			http.NotFound(w, r)
+			return
+		}
+		session.touch()
+
+		action := r.Method
+		if len(path) == 3 {
+			action += " " + path[2]
+		}
+		switch action {
+		case "GET":
+			writeJSON(w, http.StatusOK, session.state())
+		case "GET events":
+			session.stream(w, r, false)
+		case "POST input":
+			input, err := requestInput(r, session.ascii)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := session.resume(input, s.steps); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		case "DELETE":
+			s.mutex.Lock()
+			delete(s.sessions, session.id)
+			s.mutex.Unlock()
+			session.end()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// start creates a session for the program of the request, and runs it.
+func (s *server) start(r *http.Request) (*serveSession, error) {
+	if err := r.ParseMultipartForm(serveMaxRequest); err != nil && err != http.ErrNotMultipart {
+		return nil, err
+	}
+
+	data := []byte(r.FormValue("program"))
+	if file, _, err := r.FormFile("program"); err == nil {
+		data, err = ioutil.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var program []int64
+	var err error
+	if isImage(data) {
+		var image Image
+		image, err = decodeImage(data)
+		program = image.Program
+	} else {
+		program, err = parseValues(string(data))
+	}
+	if err == nil && len(program) == 0 {
+		err = errors.New("no program")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("program: %v", err)
+	}
+
+	ascii, _ := strconv.ParseBool(r.FormValue("ascii"))
+	input, err := requestInput(r, ascii)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	s.lastID++
+	id := strconv.Itoa(s.lastID)
+	s.mutex.Unlock()
+
+	emulator := makeEmulator(program)
+	emulator.singleStep = true
+	emulator.memoryLimit = s.memory
+	session := &serveSession{
+		id:       id,
+		ascii:    ascii,
+		emulator: emulator,
+		changed:  make(chan struct{}),
+		lastUsed: time.Now(),
+	}
+	check(session.resume(input, s.steps))
+	return session, nil
+}
+
+// requestInput returns the "input" values of a request, followed by its
+// "text" as ASCII. In ASCII sessions the text is sent as a line.
+func requestInput(r *http.Request, ascii bool) ([]int64, error) {
+	input, err := parseValues(r.FormValue("input"))
+	if err != nil {
+		return nil, fmt.Errorf("input: %v", err)
+	}
+	if text := r.FormValue("text"); text != "" {
+		if ascii && !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		for _, char := range text {
+			input = append(input, int64(char))
+		}
+	}
+	return input, nil
+}
+
+// parseValues is parseProgram returning an error instead of panicking.
+func parseValues(text string) (values []int64, err error) {
+	for _, value := range strings.Split(text, ",") {
+		for _, field := range strings.Fields(value) {
+			parsed, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", field)
+			}
+			values = append(values, parsed)
+		}
+	}
+	return values, nil
+}
+
+// expire ends sessions that are not running and have not been used for idle.
+func (s *server) expire(idle time.Duration) {
+	for range time.Tick(idle / 10) {
+		s.mutex.Lock()
+		for id, session := range s.sessions {
+			session.mutex.Lock()
+			expired := !session.running && time.Since(session.lastUsed) > idle
+			session.mutex.Unlock()
+			if expired {
+				delete(s.sessions, id)
+				session.end()
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
+
+func (session *serveSession) touch() {
+	session.mutex.Lock()
+	session.lastUsed = time.Now()
+	session.mutex.Unlock()
+}
+
+func (session *serveSession) state() map[string]interface{} {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	status := "running"
+	if !session.running && len(session.events) > 0 {
+		status = session.events[len(session.events)-1].Name
+	}
+	return map[string]interface{}{"id": session.id, "status": status, "events": len(session.events)}
+}
+
+// resume adds input and runs the program in the background until it stops.
+func (session *serveSession) resume(input []int64, steps int) error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	switch {
+	case session.done:
+		return errors.New("the program has ended")
+	case session.running:
+		return errors.New("the program is still running")
+	}
+	session.running = true
+	session.emulator.input = append(session.emulator.input, input...)
+	go session.run(steps)
+	return nil
+}
+
+// run runs the program for at most steps instructions, adding its output to
+// the events.
+func (session *serveSession) run(steps int) {
+	emulator := session.emulator
+	var text []byte
+
+	flush := func() {
+		if len(text) > 0 {
+			quoted, _ := json.Marshal(string(text))
+			session.add(serveEvent{"text", string(quoted)}, false)
+			text = text[:0]
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			flush()
+			session.add(serveEvent{"fault", fmt.Sprint(r)}, true)
+		}
+	}()
+
+	for step := 0; ; step++ {
+		if step == steps {
+			flush()
+			session.add(serveEvent{"limit", fmt.Sprintf("%s at ip=%d", stepLimit(steps), emulator.ip)}, true)
+			return
+		}
+
+		value, status := emulate(emulator)
+		switch status {
+		case EmulatorStatusHalted:
+			flush()
+			session.add(serveEvent{"halted", ""}, true)
+			return
+
+		case EmulatorStatusWaitingForInput:
+			flush()
+			session.add(serveEvent{"waiting", ""}, false)
+			return
+
+		case EmulatorStatusOutput:
+			if !session.ascii || value < 0 || value >= 128 {
+				flush()
+				session.add(serveEvent{"output", strconv.FormatInt(value, 10)}, false)
+			} else if text = append(text, byte(value)); value == '\n' {
+				flush()
+			}
+		}
+	}
+}
+
+// end ends the streams of a session that is deleted. A run still going is
+// left to stop by itself.
+func (session *serveSession) end() {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	session.done = true
+	close(session.changed)
+	session.changed = make(chan struct{})
+}
+
+// add adds an event, and wakes up the streams. The program stops with a
+// "waiting" event, or ends if done.
+func (session *serveSession) add(event serveEvent, done bool) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	session.events = append(session.events, event)
+	if done || event.Name == "waiting" {
+		session.running = false
+	}
+	session.done = session.done || done
+	close(session.changed)
+	session.changed = make(chan struct{})
+}
+
+// stream writes the events of the session as Server-Sent Events, until the
+// program ends, or with once until it first stops, or the client goes away.
+func (session *serveSession) stream(w http.ResponseWriter, r *http.Request, once bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	next := 0
+	if id, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil {
+		next = id + 1
+	}
+
+	for {
+		session.mutex.Lock()
+		var events []serveEvent
+		if next < len(session.events) {
+			events = session.events[next:]
+		}
+		changed, done := session.changed, session.done
+		session.mutex.Unlock()
+
+		// A client resuming after the end gets nothing more.
+		if done && len(events) == 0 {
+			return
+		}
+
+		for _, event := range events {
+			fmt.Fprintf(w, "id: %d\nevent: %s\n", next, event.Name)
+			for _, line := range strings.Split(event.Data, "\n") {
+				fmt.Fprintf(w, "data: %s\n", line)
+			}
+			fmt.Fprint(w, "\n")
+			next++
+
+			switch event.Name {
+			case "halted", "fault", "limit":
+				flusher.Flush()
+				return
+			case "waiting":
+				if once {
+					flusher.Flush()
+					return
+				}
+			}
+		}
+		flusher.Flush()
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	check(json.NewEncoder(w).Encode(value))
+}
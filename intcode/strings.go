@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Like strings(1), this finds the text in a program, to read the messages of
+// the puzzles without running them: the rooms and items of day 25, or what
+// the springdroid of day 21 can say. Text is found as
+//
+//   - runs of printable words in data,
+//   - length-prefixed strings in data, plain or encoded: with an offset added
+//     to every character, an offset and the index of the character (as day 25
+//     does, with the length as the offset), or XORed with a key,
+//   - characters written by code one at a time, as immediates of OUT
+//     instructions or as the first argument of calls, as day 21 does.
+//
+// It also finds candidate data tables: the constants code adds to an index
+// to load or store words, like the table of pointers to the strings of the
+// commands of day 25.
+
+type Text struct {
+	Address  int64  // the first word, the length of prefixed strings
+	Words    int64  // words taken, with the length
+	Kind     string // data, prefixed or code
+	Encoding string // for prefixed strings, "+a" for an offset, "+a+i" for an offset and the index, "^k" for a key
+	Value    string
+	Refs     []Reference
+}
+
+// A Reference is a word holding the address of a string or table: an
+// operand of the instruction at From, or a data word.
+type Reference struct {
+	From int64
+	Code bool
+}
+
+func (ref Reference) String() string {
+	if ref.Code {
+		return fmt.Sprintf("code %d", ref.From)
+	}
+	return fmt.Sprintf("data %d", ref.From)
+}
+
+type DataTable struct {
+	Address int64
+	Words   int64
+	Refs    []Reference
+}
+
+// isTextCharacter reports whether a word can be a character of text.
+func isTextCharacter(value int64) bool {
+	return value == '\n' || (value >= 32 && value < 127)
+}
+
+// textScore rates how much text looks like English: the share of letters
+// and spaces, less capitals in the middle of words, or nothing if too few of
+// the letters are vowels. Runs of small numbers and wrong decodings score low.
+func textScore(text string) float64 {
+	var good, vowels, letters int
+	previous := ' '
+	for _, r := range text {
+		switch {
+		case unicode.IsUpper(r) && unicode.IsLetter(previous):
+			good--
+		case unicode.IsLetter(r) || r == ' ':
+			good++
+		}
+		if unicode.IsLetter(r) {
+			letters++
+			if strings.ContainsRune("aeiouyAEIOUY", r) {
+				vowels++
+			}
+		}
+		previous = r
+	}
+	if letters == 0 || 5*vowels < letters {
+		return 0
+	}
+	return float64(good) / float64(len(text))
+}
+
+// minTextScore is the score text needs to be shown.
+const minTextScore = 0.75
+
+// looksLikeWords reports whether text has more than one word, which text
+// found without a length must.
+func looksLikeWords(text string) bool {
+	return strings.ContainsAny(strings.TrimSpace(text), " \n")
+}
+
+// looksLikeSentence reports whether text decoded with an arbitrary offset or
+// key is text: some words of letters.
+func looksLikeSentence(text string) bool {
+	return len(text) >= 8 && looksLikeWords(text) && textScore(text) >= 0.9
+}
+
+// decodeText decodes the characters of a length-prefixed string. It tries
+// every encoding putting all characters in range: an offset, none for plain
+// text, an offset and the index, and a key, and keeps the best scoring text.
+// Plain text, and the length as the offset along with the index, as day 25
+// uses, need a lower score; other offsets and keys turn tables of small
+// numbers into letters too easily, so they need a sentence.
+func decodeText(values []int64) (text, encoding string, ok bool) {
+	length := int64(len(values))
+	best := 0.0
+	try := func(name string, bonus float64, char func(i int, value int64) int64) {
+		var builder strings.Builder
+		for i, value := range values {
+			c := char(i, value)
+			if !isTextCharacter(c) {
+				return
+			}
+			builder.WriteRune(rune(c))
+		}
+		score := textScore(builder.String()) + bonus
+		if bonus == 0 && !looksLikeSentence(builder.String()) {
+			return
+		}
+		if score >= minTextScore && score > best {
+			best, text, encoding, ok = score, builder.String(), name, true
+		}
+	}
+
+	for _, index := range []int64{0, 1} {
+		low, high := int64(1<<62), int64(-1<<62)
+		for i, value := range values {
+			w := value + index*int64(i)
+			if 10-w > high {
+				high = 10 - w
+			}
+			if 126-w < low {
+				low = 126 - w
+			}
+		}
+		// Every offset from high to low puts all characters in range.
+		for offset := high; offset <= low; offset++ {
+			name, bonus := fmt.Sprintf("%+d", offset), 0.0
+			switch {
+			case index == 0 && offset == 0:
+				name, bonus = "", 0.25
+			case index == 1:
+				name += "+i"
+				if offset == length {
+					bonus = 0.15
+				}
+			}
+			try(name, bonus, func(i int, value int64) int64 { return value + offset + index*int64(i) })
+		}
+	}
+
+	for _, value := range values {
+		if value < 0 || value > 255 {
+			return text, encoding, ok
+		}
+	}
+	for key := int64(1); key < 256; key++ {
+		try(fmt.Sprintf("^%d", key), 0, func(i int, value int64) int64 { return value ^ key })
+	}
+	return text, encoding, ok
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// codeTexts finds the characters code writes one at a time, as immediates of
+// OUT instructions or constant first arguments of calls. Arguments other
+// than the first, return addresses and jumps between them do not end a run.
+func codeTexts(code map[int64]Line, minLength int) []Text {
+	var texts []Text
+	var current *Text
+	var builder strings.Builder
+	end := int64(-1)
+
+	flush := func() {
+		if current != nil && len(builder.String()) >= minLength && textScore(builder.String()) >= minTextScore {
+			current.Value = builder.String()
+			current.Words = end - current.Address
+			texts = append(texts, *current)
+		}
+		current = nil
+		builder.Reset()
+	}
+
+	for _, address := range sortedLines(code) {
+		line := code[address]
+		if address != end {
+			flush()
+		}
+
+		char := int64(-1)
+		switch value, constant := constantResult(line); {
+		case line.Opcode == OpOutput && line.Operands[0].Mode == ModeImmediate:
+			char = line.Operands[0].Value
+		case constant && line.Operands[2] == Relative(1):
+			char = value
+		case constant && line.Operands[2].Mode == ModeRelative:
+		case line.Opcode == OpJumpIfTrue || line.Opcode == OpJumpIfFalse:
+			if _, ok := unconditionalJump(line); !ok {
+				flush()
+			}
+		default:
+			flush()
+		}
+
+		if char >= 0 {
+			if !isTextCharacter(char) {
+				flush()
+			} else {
+				if current == nil {
+					current = &Text{Address: address, Kind: "code"}
+				}
+				builder.WriteRune(rune(char))
+			}
+		}
+		end = address + line.Length()
+	}
+	flush()
+	return texts
+}
+
+// dataTexts finds the prefixed strings and runs of characters in the words
+// that are not code.
+func dataTexts(memory []int64, isCode func(int64) bool, minLength int) []Text {
+	var texts []Text
+	for address := int64(0); address < int64(len(memory)); {
+		if isCode(address) {
+			address++
+			continue
+		}
+
+		if length := memory[address]; length >= int64(minLength) && length < 256 && address+length < int64(len(memory)) {
+			values := memory[address+1 : address+1+length]
+			covered := true
+			for i := address + 1; i <= address+length; i++ {
+				covered = covered && !isCode(i)
+			}
+			if text, encoding, ok := decodeText(values); ok && covered {
+				texts = append(texts, Text{Address: address, Words: length + 1, Kind: "prefixed", Encoding: encoding, Value: text})
+				address += length + 1
+				continue
+			}
+		}
+
+		end := address
+		for end < int64(len(memory)) && !isCode(end) && isTextCharacter(memory[end]) {
+			end++
+		}
+		if end-address >= int64(minLength) {
+			var builder strings.Builder
+			for _, value := range memory[address:end] {
+				builder.WriteRune(rune(value))
+			}
+			if textScore(builder.String()) >= minTextScore && looksLikeWords(builder.String()) {
+				texts = append(texts, Text{Address: address, Words: end - address, Kind: "data", Value: builder.String()})
+				address = end
+				continue
+			}
+		}
+		address++
+	}
+	return texts
+}
+
+// findReferences returns the immediate operands of code and the data words
+// holding an address, by address.
+func findReferences(memory []int64, code map[int64]Line, isCode func(int64) bool) map[int64][]Reference {
+	refs := make(map[int64][]Reference)
+	for _, address := range sortedLines(code) {
+		for _, operand := range code[address].Operands {
+			if operand.Mode == ModeImmediate {
+				refs[operand.Value] = append(refs[operand.Value], Reference{From: address, Code: true})
+			}
+		}
+	}
+	for address, value := range memory {
+		if !isCode(int64(address)) && value > 0 && value < int64(len(memory)) {
+			refs[value] = append(refs[value], Reference{From: int64(address)})
+		}
+	}
+	return refs
+}
+
+// dataTables finds the constants code adds to an index to form an address it
+// writes into an operand of an instruction, the way Intcode indexes memory.
+// A table reaches up to the next table, string or code.
+func dataTables(memory []int64, code map[int64]Line, isCode func(int64) bool, texts []Text) []DataTable {
+	bases := make(map[int64][]Reference)
+	for _, address := range sortedLines(code) {
+		line := code[address]
+		if line.Opcode != OpAdd || line.Operands[2].Mode != ModePosition || !isCode(line.Operands[2].Value) {
+			continue
+		}
+		a, b := line.Operands[0], line.Operands[1]
+		if a.Mode == ModeImmediate && b.Mode == ModeImmediate {
+			continue
+		}
+		for _, operand := range []Operand{a, b} {
+			if operand.Mode == ModeImmediate && operand.Value > 0 && operand.Value < int64(len(memory)) && !isCode(operand.Value) {
+				bases[operand.Value] = append(bases[operand.Value], Reference{From: address, Code: true})
+			}
+		}
+	}
+
+	starts := make(map[int64]bool)
+	for _, text := range texts {
+		if text.Kind != "code" {
+			starts[text.Address] = true
+		}
+	}
+	for base := range bases {
+		starts[base] = true
+	}
+
+	var tables []DataTable
+	for base, refs := range bases {
+		end := base + 1
+		for end < int64(len(memory)) && !isCode(end) && !starts[end] {
+			end++
+		}
+		tables = append(tables, DataTable{Address: base, Words: end - base, Refs: refs})
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Address < tables[j].Address })
+	return tables
+}
+
+// findTexts finds the text and data tables of a program.
+func findTexts(memory []int64, minLength int) ([]Text, []DataTable) {
+	code := analyze(memory).Code
+	covered := make(map[int64]bool)
+	for address, line := range code {
+		for i := int64(0); i < line.Length(); i++ {
+			covered[address+i] = true
+		}
+	}
+	isCode := func(address int64) bool { return covered[address] }
+
+	texts := append(codeTexts(code, minLength), dataTexts(memory, isCode, minLength)...)
+	refs := findReferences(memory, code, isCode)
+	for i := range texts {
+		if texts[i].Kind != "code" {
+			texts[i].Refs = refs[texts[i].Address]
+		}
+	}
+	sort.SliceStable(texts, func(i, j int) bool { return texts[i].Address < texts[j].Address })
+	return texts, dataTables(memory, code, isCode, texts)
+}
+
+func formatReferences(refs []Reference) string {
+	if len(refs) == 0 {
+		return ""
+	}
+	parts := make([]string, len(refs))
+	for i, ref := range refs {
+		parts[i] = ref.String()
+	}
+	return "  <- " + strings.Join(parts, ", ")
+}
+
+func stringsCommand(args []string) {
+	flags := flag.NewFlagSet("strings", flag.ExitOnError)
+	minFlag := flags.Int("n", 4, "shortest text to show, in characters")
+	tablesFlag := flags.Bool("tables", false, "list the candidate data tables as well")
+	patches := &patchFlags{}
+	patches.register(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: intcode strings [-n min] [-tables] [-poke address=value] [-patch file[:name]] program")
+		os.Exit(2)
+	}
+
+	memory := loadPatchedProgram(flags.Arg(0), patches)
+	texts, tables := findTexts(memory, *minFlag)
+
+	writer := bufio.NewWriter(os.Stdout)
+	defer writer.Flush()
+	for _, text := range texts {
+		kind := text.Kind
+		if text.Encoding != "" {
+			kind += " " + text.Encoding
+		}
+		fmt.Fprintf(writer, "%6d  %-16s %s%s\n", text.Address, kind, strconv.Quote(text.Value), formatReferences(text.Refs))
+	}
+
+	if !*tablesFlag {
+		return
+	}
+	fmt.Fprintln(writer)
+	for _, table := range tables {
+		end := table.Address + table.Words
+		values := memory[table.Address:end]
+		suffix := ""
+		if len(values) > 8 {
+			values, suffix = values[:8], ",..."
+		}
+		fmt.Fprintf(writer, "%6d  table of %-7d %s%s%s\n", table.Address, table.Words, formatProgram(values), suffix, formatReferences(table.Refs))
+	}
+}
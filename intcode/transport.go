@@ -0,0 +1,103 @@
+package main
+
+// The emulators of the puzzles that run in a goroutine used to pass every
+// value through an unbuffered channel, so that each output value, like each
+// character of the camera image of day 17, cost a handoff between goroutines.
+// A BatchedMachine hands values over in batches instead: the emulator collects
+// its output in a slice, and hands it over only when it waits for input,
+// halts or has written batchSize values, and input sent by the driver is
+// handed over at once when the program waits for it. The blocking is the
+// same as with channels: Receive blocks until the program writes a value,
+// waits for input or halts, and the program blocks on input until the
+// driver sends some. Days 11, 13 and 17 have a copy of BatchedMachine, and
+// the benchmarks of days 13 and 17 compare it with unbuffered channels, on a
+// whole game and on the camera.
+
+// batchSize is how many values the program writes before they are handed
+// over anyway, so that long output streams.
+const batchSize = 1024
+
+type outputBatch struct {
+	Values []int64
+	Event  int // MessageOutput if the program runs on
+}
+
+type BatchedMachine struct {
+	inputs  chan []int64
+	outputs chan outputBatch
+
+	// The side of the emulator.
+	written []int64 // not handed over yet
+	unread  []int64 // handed over, not read yet
+
+	// The side of the driver.
+	received []int64 // handed over, not received yet
+	event    int     // that ended the last batch received
+	sent     []int64 // not handed over yet
+}
+
+func makeBatchedMachine() *BatchedMachine {
+	return &BatchedMachine{
+		inputs:  make(chan []int64),
+		outputs: make(chan outputBatch),
+		event:   MessageOutput,
+	}
+}
+
+// write is called by the emulator for an output value.
+func (machine *BatchedMachine) write(value int64) {
+	machine.written = append(machine.written, value)
+	if len(machine.written) == batchSize {
+		machine.flush(MessageOutput)
+	}
+}
+
+// read is called by the emulator for an input value, and blocks until the
+// driver sends some.
+func (machine *BatchedMachine) read() int64 {
+	if len(machine.unread) == 0 {
+		machine.flush(MessageWaitingForInput)
+		machine.unread = <-machine.inputs
+	}
+	value := machine.unread[0]
+	machine.unread = machine.unread[1:]
+	return value
+}
+
+// halt is called by the emulator when the program halts.
+func (machine *BatchedMachine) halt() {
+	machine.flush(MessageHalt)
+}
+
+func (machine *BatchedMachine) flush(event int) {
+	machine.outputs <- outputBatch{Values: machine.written, Event: event}
+	machine.written = nil
+}
+
+func (machine *BatchedMachine) Send(value int64) {
+	machine.sent = append(machine.sent, value)
+}
+
+func (machine *BatchedMachine) Receive() Message {
+	for {
+		if len(machine.received) > 0 {
+			value := machine.received[0]
+			machine.received = machine.received[1:]
+			return Message{Kind: MessageOutput, Value: value}
+		}
+
+		switch machine.event {
+		case MessageHalt:
+			return Message{Kind: MessageHalt}
+		case MessageWaitingForInput:
+			if len(machine.sent) == 0 {
+				return Message{Kind: MessageWaitingForInput}
+			}
+			machine.inputs <- machine.sent
+			machine.sent = nil
+		}
+
+		batch := <-machine.outputs
+		machine.received, machine.event = batch.Values, batch.Event
+	}
+}
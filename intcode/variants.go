@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The emulators of the puzzles, kept here so that they can be checked against
+// each other and the emulator of the toolbox, by the conformance command and
+// the fuzzer. They are copies of the emulators in day05 to day25, with a
+// limit on the number of steps added, generated into variants_days.go by
+// genvariants (see genvariants/main.go); regenerate them when fixing those,
+// `go run genvariants/main.go -check` fails if a copy differs from its day.
+
+//go:generate go run genvariants/main.go
+
+// An Implementation is an Intcode emulator that can be checked.
+type Implementation interface {
+	Name() string
+
+	// Supports reports whether the implementation can run the program,
+	// if it uses relative mode and needs memory words of memory.
+	Supports(program []int64, relative bool, memory int64) bool
+
+	// Run runs the program until it halts, or until it has executed steps
+	// instructions. Memory is the memory at the end, or nil if the
+	// implementation does not expose it.
+	Run(program, input []int64, steps int) (output, memory []int64, err error)
+}
+
+// variant is an Implementation running a function.
+type variant struct {
+	name     string
+	relative bool  // supports ARB and relative mode, which came with day 9
+	memory   int64 // size of memory, 0 if it is the size of the program, or -1 if it grows
+	run      func(program, input []int64, steps int) (output, memory []int64)
+}
+
+func (v variant) Name() string {
+	return v.name
+}
+
+func (v variant) Supports(program []int64, relative bool, memory int64) bool {
+	switch {
+	case relative && !v.relative:
+		return false
+	case v.memory == 0:
+		return memory <= int64(len(program))
+	case v.memory > 0:
+		return memory <= v.memory && int64(len(program)) <= v.memory
+	}
+	return true
+}
+
+func (v variant) Run(program, input []int64, steps int) (output, memory []int64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	output, memory = v.run(program, input, steps)
+	return output, memory, nil
+}
+
+var errWaitingForInput = errors.New("waiting for input")
+
+// implementations are the emulators to check, the toolbox first.
+var implementations = []Implementation{
+	variant{"intcode", true, -1, func(program, input []int64, steps int) ([]int64, []int64) {
+		emulator := makeEmulator(program, input...)
+		emulator.singleStep = true
+		var output []int64
+		for step := 0; ; step++ {
+			if step == steps {
+				panic(stepLimit(steps))
+			}
+			value, status := emulate(emulator)
+			switch status {
+			case EmulatorStatusHalted:
+				return output, emulator.memory
+			case EmulatorStatusWaitingForInput:
+				panic(errWaitingForInput)
+			case EmulatorStatusOutput:
+				output = append(output, value)
+			}
+		}
+	}},
+	variant{"day05", false, 0, func(program, input []int64, steps int) ([]int64, []int64) {
+		trace := &day05Trace{Executed: make(map[int]bool)}
+		output := emulateWithTraceDay05(toInts(program), toInts(input), trace, steps)
+		return fromInts(output), fromInts(trace.Memory)
+	}},
+	variant{"day07", false, 0, func(program, input []int64, steps int) ([]int64, []int64) {
+		ints := toInts(program)
+		return runConcurrently(input, func(input <-chan int64, output chan<- int64) {
+			// The emulator of day 7 works on ints.
+			inputs, outputs := make(chan int), make(chan int)
+			halt := make(chan bool, 1)
+			go func() {
+				for value := range input {
+					inputs <- int(value)
+				}
+				close(inputs)
+			}()
+			forwarded := make(chan bool)
+			go func() {
+				for value := range outputs {
+					output <- int64(value)
+				}
+				close(forwarded)
+			}()
+			defer func() { <-forwarded }()
+			defer close(outputs)
+			emulateDay07(ints, inputs, outputs, halt, steps)
+		}), nil
+	}},
+	variant{"day09", true, 3000, func(program, input []int64, steps int) ([]int64, []int64) {
+		trace := &day09Trace{Executed: make(map[int64]bool)}
+		output := emulateWithTraceDay09(program, input, trace, steps)
+		return output, trace.Memory
+	}},
+	variant{"day11", true, 3000, func(program, input []int64, steps int) ([]int64, []int64) {
+		machine := makeBatchedMachineDay11()
+		return runBatched(input, func() { emulateDay11(program, machine, steps) }, machine.halt, machine.Send, func() (int, int64) {
+			message := machine.Receive()
+			return message.Kind, message.Value
+		}), nil
+	}},
+	variant{"day13", true, 3000, func(program, input []int64, steps int) ([]int64, []int64) {
+		machine := makeBatchedMachineDay13()
+		return runBatched(input, func() { emulateDay13(program, machine, steps) }, machine.halt, machine.Send, func() (int, int64) {
+			message := machine.Receive()
+			return message.Kind, message.Value
+		}), nil
+	}},
+	variant{"day15", true, 3000, func(program, input []int64, steps int) ([]int64, []int64) {
+		return runConcurrently(input, func(input <-chan int64, output chan<- int64) {
+			directions := make(chan day15Direction)
+			go func() {
+				for value := range input {
+					directions <- day15Direction(value)
+				}
+				close(directions)
+			}()
+			emulateDay15(program, directions, output, make(chan bool, 1), steps)
+		}), nil
+	}},
+	variant{"day17", true, -1, func(program, input []int64, steps int) ([]int64, []int64) {
+		machine := makeBatchedMachineDay17()
+		return runBatched(input, func() { emulateDay17(program, machine, steps) }, machine.halt, machine.Send, func() (int, int64) {
+			message := machine.Receive()
+			return message.Kind, message.Value
+		}), nil
+	}},
+	variant{"day19", true, -1, func(program, input []int64, steps int) ([]int64, []int64) {
+		machine := makeMachineDay19(program)
+		output := machine.Run(input, nil, steps)
+		return output, machine.memory
+	}},
+	variant{"day21", true, 5000, func(program, input []int64, steps int) ([]int64, []int64) {
+		return runConcurrently(input, func(input <-chan int64, output chan<- int64) {
+			emulateDay21(program, input, output, make(chan bool, 1), steps)
+		}), nil
+	}},
+	variant{"day23", true, 5000, func(program, input []int64, steps int) ([]int64, []int64) {
+		return runConcurrently(input, func(input <-chan int64, output chan<- int64) {
+			emulateDay23(program, input, output, steps)
+		}), nil
+	}},
+	variant{"day25", true, -1, func(program, input []int64, steps int) ([]int64, []int64) {
+		emulator := makeEmulatorDay25(program, input...)
+		var output []int64
+		for {
+			value, status := emulateDay25(emulator, steps)
+			switch status {
+			case day25EmulatorStatusHalted:
+				return output, emulator.memory
+			case day25EmulatorStatusWaitingForInput:
+				panic(errWaitingForInput)
+			case day25EmulatorStatusOutput:
+				output = append(output, value)
+			}
+		}
+	}},
+}
+
+func toInts(values []int64) []int {
+	ints := make([]int, len(values))
+	for i, value := range values {
+		ints[i] = int(value)
+	}
+	return ints
+}
+
+func fromInts(values []int) []int64 {
+	result := make([]int64, len(values))
+	for i, value := range values {
+		result[i] = int64(value)
+	}
+	return result
+}
+
+func stepLimit(steps int) string {
+	return fmt.Sprintf("stopped after %d steps", steps)
+}
+
+// runConcurrently runs an emulator reading from and writing to channels,
+// feeding it the input and collecting its output until it returns. Reading
+// past the input gets zeros, as from a closed channel.
+func runConcurrently(input []int64, emulate func(input <-chan int64, output chan<- int64)) (output []int64) {
+	inputs := make(chan int64, len(input))
+	for _, value := range input {
+		inputs <- value
+	}
+	close(inputs)
+
+	outputs := make(chan int64)
+	done := make(chan struct{})
+	faults := make(chan interface{}, 1)
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				faults <- r
+			}
+		}()
+		emulate(inputs, outputs)
+	}()
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case value := <-outputs:
+			output = append(output, value)
+		case <-done:
+			select {
+			case r := <-faults:
+				panic(r)
+			default:
+				return output
+			}
+		case <-timeout:
+			panic("timed out")
+		}
+	}
+}
+
+// runBatched runs an emulator writing to a copy of BatchedMachine (see
+// transport.go) in a goroutine, and drives it like runConcurrently, feeding it
+// the input, and zeros past its end, and collecting its output until it
+// halts. run runs the emulator, and halt ends a batch the way it does when the
+// program halts, for the driver to stop if the emulator panics.
+func runBatched(input []int64, run func(), halt func(), send func(int64), receive func() (kind int, value int64)) (output []int64) {
+	var fault interface{}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				fault = r
+				halt()
+			}
+		}()
+		run()
+	}()
+
+	// The copies number their messages like machine.go.
+	for {
+		switch kind, value := receive(); kind {
+		case MessageOutput:
+			output = append(output, value)
+		case MessageWaitingForInput:
+			if len(input) == 0 {
+				send(0)
+			} else {
+				send(input[0])
+				input = input[1:]
+			}
+		case MessageHalt:
+			if fault != nil {
+				panic(fault)
+			}
+			return output
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+// TestVariantsGenerated fails if a copy in variants_days.go differs from the
+// emulator of its day.
+func TestVariantsGenerated(t *testing.T) {
+	output, err := exec.Command("go", "run", "genvariants/main.go", "-check").CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, output)
+	}
+}